@@ -15,22 +15,16 @@ import (
 
 type EventStopped struct{}
 
-type timerFuncInfo struct {
-	F func(t time.Time)
-	T time.Time
-}
-
 type EventQueue struct {
-	overQueue     uint32
-	overTimeQueue uint32
-	stopped       uint32
+	stopped uint32
 
-	queue          chan interface{}
-	postQueue      chan interface{}
-	wg             *sync.WaitGroup
-	timerPostQueue chan *timerFuncInfo
-	timerQueue     chan *timerFuncInfo
-	log            *logger.Logger
+	lanes    [priorityCount]lane
+	priority priorityMap
+	onDrop   func(event interface{}, p Priority)
+	stats    eventStats
+	wg       *sync.WaitGroup
+	timers   *timerWheel
+	log      *logger.Logger
 
 	onceMap map[string]func()
 }
@@ -41,10 +35,11 @@ func NewEventQueue(cap int, log *logger.Logger) *EventQueue {
 	}
 
 	e := &EventQueue{}
-	e.queue = make(chan interface{}, cap)
-	e.postQueue = e.queue
-	e.timerQueue = make(chan *timerFuncInfo, cap)
-	e.timerPostQueue = e.timerQueue
+	for i := range e.lanes {
+		e.lanes[i].queue = make(chan interface{}, cap)
+		e.lanes[i].postQueue = e.lanes[i].queue
+	}
+	e.timers = newTimerWheel(defaultTimerTick, time.Now())
 	e.wg = &sync.WaitGroup{}
 	e.log = log
 
@@ -59,13 +54,9 @@ func (this_ *EventQueue) Stopped() bool {
 	return atomic.LoadUint32(&this_.stopped) == 1
 }
 
+// Post 队列满了时丢弃事件，见 SetOnDrop 和 Dropped，优先级见 SetPriority
 func (this_ *EventQueue) Post(msg interface{}) {
-	if !this_.Stopped() {
-		select {
-		case this_.postQueue <- msg:
-		default:
-		}
-	}
+	this_.post(this_.priority.get(msg), msg)
 }
 
 func (this_ *EventQueue) PostWait(f func()) error {
@@ -100,21 +91,32 @@ func (this_ *EventQueue) PostWait(f func()) error {
 	return err
 }
 
-// ticker 每隔几秒调用,如果函数返回false,则停止
-func (this_ *EventQueue) Tick(d time.Duration, f func(time.Time) bool) {
-	if !this_.Stopped() {
-		time.AfterFunc(d, func() {
-			this_.timerPostQueue <- &timerFuncInfo{
-				F: func(t time.Time) {
-					if f(t) {
-						this_.Tick(d, f)
-					}
-				},
-				T: time.Now(),
-			}
-		})
+// ticker 每隔几秒调用,如果函数返回false,则停止，也可以通过返回的 TimerID 停止
+func (this_ *EventQueue) Tick(d time.Duration, f func(time.Time) bool) TimerID {
+	if this_.Stopped() {
+		return TimerID{}
 	}
+	return this_.timers.newTimer(time.Now(), d, d, true, f)
 }
+
+// SetTimerTick 设置定时器的精度，默认10毫秒，定时器最多晚一个 tick 执行
+// 需要在 Run 和添加定时器之前调用，之后调用会被忽略，返回 false
+func (this_ *EventQueue) SetTimerTick(d time.Duration) bool {
+	if d <= 0 {
+		return false
+	}
+	w := this_.timers
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running || w.count != 0 {
+		return false
+	}
+	w.tick = d
+	w.start = time.Now()
+	w.next = 0
+	return true
+}
+
 func (this_ *EventQueue) Once(key string, f func()) {
 	this_.Post(func() {
 		if this_.onceMap == nil {
@@ -128,60 +130,24 @@ func (this_ *EventQueue) Once(key string, f func()) {
 }
 
 // 多少时间之后调用
-func (this_ *EventQueue) AfterFunc(d time.Duration, f func(time.Time)) {
-	if !this_.Stopped() {
-		if d <= 0 {
-			d = time.Nanosecond
-		}
-
-		time.AfterFunc(d, func() {
-			this_.timerPostQueue <- &timerFuncInfo{
-				F: f,
-				T: time.Now(),
-			}
-		})
+func (this_ *EventQueue) AfterFunc(d time.Duration, f func(time.Time)) TimerID {
+	if this_.Stopped() {
+		return TimerID{}
 	}
+	return this_.timers.newTimer(time.Now(), d, 0, false, func(t time.Time) bool {
+		f(t)
+		return false
+	})
 }
 
 // 达到某个时间点调用
-func (this_ *EventQueue) UntilFunc(t time.Time, f func(time.Time)) {
-	if !this_.Stopped() {
-		timestamp := t.Sub(time.Now())
-		if timestamp <= 0 {
-			timestamp = time.Nanosecond
-		}
-
-		time.AfterFunc(timestamp, func() {
-			this_.timerPostQueue <- &timerFuncInfo{
-				F: f,
-				T: time.Now(),
-			}
-		})
-	}
+func (this_ *EventQueue) UntilFunc(t time.Time, f func(time.Time)) TimerID {
+	return this_.AfterFunc(time.Until(t), f)
 }
 
-// 达到某个时间点调用
-func (this_ *EventQueue) UntilFuncMillSeconds(untilMillSecondTime int64, f func(time.Time)) {
-	if !this_.Stopped() {
-		timestamp := untilMillSecondTime - time.Now().Unix()
-		if timestamp <= 0 {
-			time.AfterFunc(time.Nanosecond, func() {
-				this_.timerPostQueue <- &timerFuncInfo{
-					F: f,
-					T: time.Now(),
-				}
-			})
-
-			return
-		}
-
-		time.AfterFunc(time.Duration(timestamp)*time.Millisecond, func() {
-			this_.timerPostQueue <- &timerFuncInfo{
-				F: f,
-				T: time.Now(),
-			}
-		})
-	}
+// 达到某个时间点调用，untilMillSecondTime 为毫秒时间戳
+func (this_ *EventQueue) UntilFuncMillSeconds(untilMillSecondTime int64, f func(time.Time)) TimerID {
+	return this_.UntilFunc(time.Unix(0, untilMillSecondTime*int64(time.Millisecond)), f)
 }
 
 func (this_ *EventQueue) Run(panicF func(e interface{}), f func(event interface{}), endFuncS ...func()) {
@@ -191,6 +157,8 @@ func (this_ *EventQueue) Run(panicF func(e interface{}), f func(event interface{
 		}
 	}
 
+	// Run 返回之后 SetTimerTick 就不再生效
+	tick := this_.timers.run()
 	this_.wg.Add(1)
 	utils.SafeGO(panicF, func() {
 		defer this_.wg.Done()
@@ -199,47 +167,40 @@ func (this_ *EventQueue) Run(panicF func(e interface{}), f func(event interface{
 				v()
 			}
 		}()
+		defer this_.startWatchdog()()
 
-		for {
-			select {
-			case event, ok := <-this_.queue:
-				if ok {
-					//this_.log.Info("START event", zap.Any("data", event))
-					dealEvent(panicF, event, f)
-					//this_.log.Info("END event", zap.Any("data", event))
-				} else {
-					this_.overQueue++
-					this_.queue = nil
-					if this_.dealRunGoRoutineStop() {
-						return
-					}
-				}
-			case timerF, ok := <-this_.timerQueue:
-				if ok {
+		var lanes [priorityCount]chan interface{}
+		for i := range this_.lanes {
+			lanes[i] = this_.lanes[i].queue
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		// 所有的 channel 都关闭并且处理完才退出，之后到期的定时器不再执行
+		open := len(lanes)
+		for open > 0 {
+			event, isTick, closed := this_.next(&lanes, ticker.C)
+			switch {
+			case closed:
+				open--
+			case isTick:
+				// 同一批到期的定时器使用同一个时间
+				now := time.Now()
+				for _, v := range this_.timers.advance(now) {
+					start := this_.beginEvent(timerEventType)
 					utils.RecoverWithFunc(panicF, func() {
-						//this_.log.Info("START timerF", zap.String("data", reflect.TypeOf(timerF.F).String()))
-						timerF.F(timerF.T)
-						//this_.log.Info("END timerF", zap.Any("data", reflect.TypeOf(timerF.F).String()))
+						this_.timers.fire(v, now)
 					})
-				} else {
-					this_.overTimeQueue++
-					this_.timerQueue = nil
-					if this_.dealRunGoRoutineStop() {
-						return
-					}
+					this_.endEvent(timerEventType, start)
 				}
+			default:
+				typ := eventType(event)
+				start := this_.beginEvent(typ)
+				dealEvent(panicF, event, f)
+				this_.endEvent(typ, start)
 			}
 		}
-	})
-}
-
-func (this_ *EventQueue) dealRunGoRoutineStop() (isReturn bool) {
-	if this_.overQueue > 0 && this_.overTimeQueue > 0 {
 		this_.log.Debug("event queue stopped")
-		return true
-	}
-
-	return false
+	})
 }
 
 func dealEvent(panicF func(e interface{}), event interface{}, f func(event interface{})) {
@@ -260,9 +221,9 @@ func dealEvent(panicF func(e interface{}), event interface{}, f func(event inter
 func (this_ *EventQueue) Stop() {
 	this_.Post(&EventStopped{})
 	this_.setStopped()
-	this_.postQueue = nil
-	this_.timerPostQueue = nil
-	close(this_.queue)
-	close(this_.timerQueue)
+	for i := range this_.lanes {
+		this_.lanes[i].postQueue = nil
+		close(this_.lanes[i].queue)
+	}
 	this_.wg.Wait()
 }
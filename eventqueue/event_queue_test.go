@@ -0,0 +1,93 @@
+package eventqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type controlEvent struct{}
+
+func findStats(st *Stats, typ string) *EventStats {
+	for i := range st.Events {
+		if st.Events[i].Type == typ {
+			return &st.Events[i]
+		}
+	}
+	return nil
+}
+
+func TestPriority(t *testing.T) {
+	r := require.New(t)
+	q := NewEventQueue(0, nil)
+	q.SetPriority(&controlEvent{}, PriorityHigh)
+
+	q.PostPriority(PriorityLow, 1)
+	q.Post(2)
+	q.Post(&controlEvent{})
+	q.PostPriority(PriorityHigh, 3)
+
+	got := make(chan interface{}, 10)
+	q.Run(nil, func(event interface{}) {
+		got <- event
+	})
+	for _, v := range []interface{}{&controlEvent{}, 3, 2, 1} {
+		select {
+		case e := <-got:
+			r.Equal(v, e)
+		case <-time.After(time.Second * 3):
+			r.FailNow("no event")
+		}
+	}
+}
+
+func TestDropAndStats(t *testing.T) {
+	r := require.New(t)
+	q := NewEventQueue(0, nil)
+	var dropped []interface{}
+	q.SetOnDrop(func(event interface{}, p Priority) {
+		r.Equal(PriorityNormal, p)
+		dropped = append(dropped, event)
+	})
+	n := cap(q.lanes[PriorityNormal].queue)
+	for i := 0; i <= n; i++ {
+		q.Post(i)
+	}
+	r.EqualValues(1, q.Dropped())
+	r.Equal([]interface{}{n}, dropped)
+	st := q.Stats()
+	r.Equal(n, st.Lanes[PriorityNormal].Depth)
+	r.EqualValues(n, st.Lanes[PriorityNormal].Posted)
+	r.EqualValues(1, st.Dropped)
+
+	var slow []*SlowEvent
+	slowC := make(chan *SlowEvent, 2)
+	q.SetSlowHandler(time.Millisecond*20, func(e *SlowEvent) {
+		slowC <- e
+	})
+	q.Run(nil, func(interface{}) {})
+	// 普通队列是满的，放到高优先级
+	q.PostPriority(PriorityHigh, func() {
+		time.Sleep(time.Millisecond * 100)
+	})
+	for len(slow) < 2 {
+		select {
+		case e := <-slowC:
+			slow = append(slow, e)
+		case <-time.After(time.Second * 3):
+			r.FailNow("no slow event")
+		}
+	}
+	// 先由 watchdog 发现还没处理完，处理完之后再通知一次
+	r.False(slow[0].Finished)
+	r.True(slow[1].Finished)
+	r.Equal("func()", slow[1].Type)
+	r.GreaterOrEqual(slow[1].Cost, time.Millisecond*100)
+
+	st = q.Stats()
+	r.Equal(0, st.Lanes[PriorityNormal].Depth)
+	r.EqualValues(1, findStats(st, "func()").Slow)
+	r.EqualValues(n, findStats(st, "int").Count)
+	r.Zero(findStats(st, "int").Slow)
+}
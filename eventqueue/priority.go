@@ -0,0 +1,160 @@
+package eventqueue
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority 事件的优先级，Run 总是先处理高优先级的事件，高优先级的事件一直很多时低优先级的事件会一直等待
+// 不同优先级的事件之间没有顺序保证，同一个连接的消息和断开事件要用同一个优先级
+type Priority int
+
+const (
+	// 控制类事件，比如连接建立、GM 指令
+	PriorityHigh Priority = iota
+	// Post 默认的优先级
+	PriorityNormal
+	// 可以延后处理的事件，比如统计、日志
+	PriorityLow
+	priorityCount
+)
+
+func (this_ Priority) String() string {
+	switch this_ {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	}
+	return "unknown"
+}
+
+func (this_ Priority) valid() bool {
+	return this_ >= PriorityHigh && this_ < priorityCount
+}
+
+type lane struct {
+	queue     chan interface{}
+	postQueue chan interface{}
+	posted    uint64
+	dropped   uint64
+}
+
+// 按事件类型指定优先级，写时复制，Post 时不加锁
+type priorityMap struct {
+	mu sync.Mutex
+	m  atomic.Value
+}
+
+func (this_ *priorityMap) get(event interface{}) Priority {
+	m, _ := this_.m.Load().(map[reflect.Type]Priority)
+	if p, ok := m[reflect.TypeOf(event)]; ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+func (this_ *priorityMap) set(t reflect.Type, p Priority) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	old, _ := this_.m.Load().(map[reflect.Type]Priority)
+	m := make(map[reflect.Type]Priority, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[t] = p
+	this_.m.Store(m)
+}
+
+// SetPriority 之后 Post 和 event 同一类型的事件都使用优先级 p，比如
+//
+//	queue.SetPriority(&tcp.AcceptSession{}, eventqueue.PriorityHigh)
+func (this_ *EventQueue) SetPriority(event interface{}, p Priority) {
+	if !p.valid() {
+		return
+	}
+	this_.priority.set(reflect.TypeOf(event), p)
+}
+
+// PostPriority 使用指定的优先级投递事件，忽略 SetPriority 的设置
+func (this_ *EventQueue) PostPriority(p Priority, msg interface{}) {
+	if !p.valid() {
+		p = PriorityNormal
+	}
+	this_.post(p, msg)
+}
+
+// SetOnDrop 队列满了丢弃事件时调用 f，在 Post 的协程中调用，f 里面不能阻塞也不能再 Post
+func (this_ *EventQueue) SetOnDrop(f func(event interface{}, p Priority)) {
+	this_.onDrop = f
+}
+
+// Dropped 队列满了被丢弃的事件总数
+func (this_ *EventQueue) Dropped() uint64 {
+	var n uint64
+	for i := range this_.lanes {
+		n += atomic.LoadUint64(&this_.lanes[i].dropped)
+	}
+	return n
+}
+
+func (this_ *EventQueue) post(p Priority, msg interface{}) {
+	if this_.Stopped() {
+		return
+	}
+	l := &this_.lanes[p]
+	select {
+	case l.postQueue <- msg:
+		atomic.AddUint64(&l.posted, 1)
+	default:
+		if this_.Stopped() {
+			return
+		}
+		atomic.AddUint64(&l.dropped, 1)
+		if f := this_.onDrop; f != nil {
+			f(msg, p)
+		}
+	}
+}
+
+// 先按优先级依次取，都为空时再一起等待，定时器排在 PriorityHigh 之后
+// 有 channel 被关闭时 closed 为 true，并且把对应的 channel 置为 nil
+func (this_ *EventQueue) next(lanes *[priorityCount]chan interface{}, tick <-chan time.Time) (event interface{}, isTick bool, closed bool) {
+	select {
+	case e, ok := <-lanes[PriorityHigh]:
+		return recvLane(lanes, PriorityHigh, e, ok)
+	default:
+	}
+	select {
+	case <-tick:
+		return nil, true, false
+	default:
+	}
+	select {
+	case e, ok := <-lanes[PriorityNormal]:
+		return recvLane(lanes, PriorityNormal, e, ok)
+	default:
+	}
+	select {
+	case e, ok := <-lanes[PriorityHigh]:
+		return recvLane(lanes, PriorityHigh, e, ok)
+	case <-tick:
+		return nil, true, false
+	case e, ok := <-lanes[PriorityNormal]:
+		return recvLane(lanes, PriorityNormal, e, ok)
+	case e, ok := <-lanes[PriorityLow]:
+		return recvLane(lanes, PriorityLow, e, ok)
+	}
+}
+
+func recvLane(lanes *[priorityCount]chan interface{}, p Priority, e interface{}, ok bool) (interface{}, bool, bool) {
+	if !ok {
+		lanes[p] = nil
+		return nil, false, true
+	}
+	return e, false, false
+}
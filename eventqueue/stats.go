@@ -0,0 +1,221 @@
+package eventqueue
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const timerEventType = "timer"
+
+// LaneStats 一个优先级队列的统计
+type LaneStats struct {
+	Priority Priority
+	// 当前排队的事件数
+	Depth int
+	Cap   int
+	// 累计投递成功和丢弃的事件数
+	Posted  uint64
+	Dropped uint64
+}
+
+// EventStats 一种事件的处理耗时统计，定时器回调的类型为 "timer"，Post 的函数为 "func()"
+type EventStats struct {
+	Type  string
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+	// 超过 SetSlowHandler 阈值的次数
+	Slow uint64
+}
+
+func (this_ *EventStats) Avg() time.Duration {
+	if this_.Count == 0 {
+		return 0
+	}
+	return this_.Total / time.Duration(this_.Count)
+}
+
+// Stats 队列的统计，Events 按总耗时从大到小排序
+type Stats struct {
+	Lanes []LaneStats
+	// 还没有执行的定时器数量
+	Timers  int
+	Dropped uint64
+	Events  []EventStats
+	// 正在处理的事件类型和已经处理了多久，Run 阻塞在某个事件上时可以看到是哪一种
+	Running    string
+	RunningFor time.Duration
+}
+
+// SlowEvent 处理时间超过阈值的事件
+type SlowEvent struct {
+	Type string
+	Cost time.Duration
+	// 为 false 时事件还在处理中，是 watchdog 发现的，处理完之后还会再通知一次
+	Finished bool
+}
+
+type eventStats struct {
+	mu      sync.Mutex
+	events  map[string]*EventStats
+	running string
+	start   time.Time
+	seq     uint64
+
+	slowThreshold time.Duration
+	onSlow        func(e *SlowEvent)
+	reportedSeq   uint64
+}
+
+// SetSlowHandler 处理时间超过 threshold 的事件调用 f，f 为 nil 时打印警告日志，需要在 Run 之前调用
+// 处理完的事件在 Run 的协程中通知，还没处理完的由 watchdog 协程通知，每个事件只通知一次未完成
+func (this_ *EventQueue) SetSlowHandler(threshold time.Duration, f func(e *SlowEvent)) {
+	this_.stats.slowThreshold = threshold
+	this_.stats.onSlow = f
+}
+
+func (this_ *EventQueue) reportSlow(e *SlowEvent) {
+	if f := this_.stats.onSlow; f != nil {
+		f(e)
+		return
+	}
+	if this_.log != nil {
+		this_.log.Warn("event queue slow handler", zap.String("type", e.Type), zap.Duration("cost", e.Cost), zap.Bool("finished", e.Finished))
+	}
+}
+
+func eventType(event interface{}) string {
+	if event == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(event).String()
+}
+
+// 在 Run 的协程中调用
+func (this_ *EventQueue) beginEvent(typ string) time.Time {
+	now := time.Now()
+	s := &this_.stats
+	s.mu.Lock()
+	s.running = typ
+	s.start = now
+	s.seq++
+	s.mu.Unlock()
+	return now
+}
+
+func (this_ *EventQueue) endEvent(typ string, start time.Time) {
+	cost := time.Since(start)
+	s := &this_.stats
+	slow := s.slowThreshold > 0 && cost > s.slowThreshold
+	s.mu.Lock()
+	if s.events == nil {
+		s.events = map[string]*EventStats{}
+	}
+	es, ok := s.events[typ]
+	if !ok {
+		es = &EventStats{Type: typ}
+		s.events[typ] = es
+	}
+	es.Count++
+	es.Total += cost
+	if cost > es.Max {
+		es.Max = cost
+	}
+	if slow {
+		es.Slow++
+	}
+	s.running = ""
+	s.mu.Unlock()
+	if slow {
+		this_.reportSlow(&SlowEvent{Type: typ, Cost: cost, Finished: true})
+	}
+}
+
+// 定期检查正在处理的事件，Run 被阻塞住的时候也能发现
+func (this_ *EventQueue) startWatchdog() (stop func()) {
+	s := &this_.stats
+	if s.slowThreshold <= 0 {
+		return func() {}
+	}
+	interval := s.slowThreshold / 2
+	if interval < time.Millisecond*10 {
+		interval = time.Millisecond * 10
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				s.mu.Lock()
+				var e *SlowEvent
+				if s.running != "" && s.seq != s.reportedSeq && now.Sub(s.start) > s.slowThreshold {
+					s.reportedSeq = s.seq
+					e = &SlowEvent{Type: s.running, Cost: now.Sub(s.start)}
+				}
+				s.mu.Unlock()
+				if e != nil {
+					this_.reportSlow(e)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// Stats 当前的队列深度、丢弃数和各种事件的处理耗时，可以在任意协程调用
+func (this_ *EventQueue) Stats() *Stats {
+	st := &Stats{
+		Lanes:  make([]LaneStats, 0, priorityCount),
+		Timers: this_.timers.Len(),
+	}
+	for i := range this_.lanes {
+		l := &this_.lanes[i]
+		ls := LaneStats{
+			Priority: Priority(i),
+			Depth:    len(l.queue),
+			Cap:      cap(l.queue),
+			Posted:   atomic.LoadUint64(&l.posted),
+			Dropped:  atomic.LoadUint64(&l.dropped),
+		}
+		st.Dropped += ls.Dropped
+		st.Lanes = append(st.Lanes, ls)
+	}
+
+	s := &this_.stats
+	s.mu.Lock()
+	st.Events = make([]EventStats, 0, len(s.events))
+	for _, v := range s.events {
+		st.Events = append(st.Events, *v)
+	}
+	if s.running != "" {
+		st.Running = s.running
+		st.RunningFor = time.Since(s.start)
+	}
+	s.mu.Unlock()
+	sort.Slice(st.Events, func(i, j int) bool {
+		if st.Events[i].Total != st.Events[j].Total {
+			return st.Events[i].Total > st.Events[j].Total
+		}
+		return st.Events[i].Type < st.Events[j].Type
+	})
+	return st
+}
+
+// ResetStats 清空事件的耗时统计，队列深度和丢弃数不受影响
+func (this_ *EventQueue) ResetStats() {
+	s := &this_.stats
+	s.mu.Lock()
+	s.events = nil
+	s.mu.Unlock()
+}
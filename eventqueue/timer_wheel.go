@@ -0,0 +1,326 @@
+package eventqueue
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 分层时间轮，定时器都在 Run 的协程中执行
+// 第一层 256 个槽，每个槽一个 tick，上面四层每层 64 个槽，每个槽是下一层转一圈的时间
+// tick 为 10 毫秒时最长可以表示 497 天，更长的定时器先放在最上层，转下来之后再重新放
+// 定时器不会提前执行，最多晚一个 tick
+
+const (
+	wheelRootBits  = 8
+	wheelLevelBits = 6
+	wheelLevels    = 4
+	wheelRootSize  = 1 << wheelRootBits
+	wheelLevelSize = 1 << wheelLevelBits
+	wheelRootMask  = wheelRootSize - 1
+	wheelLevelMask = wheelLevelSize - 1
+	wheelMaxDelta  = 1<<(wheelRootBits+wheelLevelBits*wheelLevels) - 1
+)
+
+// 默认精度10毫秒
+const defaultTimerTick = time.Millisecond * 10
+
+const (
+	timerPending uint8 = iota
+	timerFired
+	timerCanceled
+)
+
+type timer struct {
+	// 创建的顺序，同一时刻到期的定时器按创建的先后执行
+	id uint64
+	// 到期时间，相对于时间轮创建时的纳秒数
+	when int64
+	// 到期的 tick
+	expire int64
+	// Tick 的间隔，AfterFunc 和 UntilFunc 为 0
+	period time.Duration
+	repeat bool
+	f      func(time.Time) bool
+	// Reset 和 Cancel 之后加1，已经取出来准备执行的旧定时器不再执行
+	gen   uint64
+	state uint8
+
+	slot       *timerSlot
+	prev, next *timer
+}
+
+type timerSlot struct {
+	first *timer
+}
+
+func (this_ *timerSlot) push(t *timer) {
+	t.slot = this_
+	t.prev = nil
+	t.next = this_.first
+	if this_.first != nil {
+		this_.first.prev = t
+	}
+	this_.first = t
+}
+
+func (this_ *timerSlot) remove(t *timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		this_.first = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.slot, t.prev, t.next = nil, nil, nil
+}
+
+// 取出所有的定时器，槽变为空
+func (this_ *timerSlot) take() *timer {
+	first := this_.first
+	this_.first = nil
+	return first
+}
+
+type timerFire struct {
+	t   *timer
+	gen uint64
+}
+
+type timerWheel struct {
+	mu   sync.Mutex
+	tick time.Duration
+	// tick 0 的时间，使用单调时钟，修改系统时间不影响定时器
+	start time.Time
+	// 下一个要处理的 tick
+	next   int64
+	root   [wheelRootSize]timerSlot
+	levels [wheelLevels][wheelLevelSize]timerSlot
+	seq    uint64
+	// 还没有执行的定时器数量
+	count int
+	// Run 已经按照 tick 创建了 ticker，之后不能再修改 tick
+	running bool
+	// 只在 Run 的协程中使用
+	fires []timerFire
+}
+
+func newTimerWheel(tick time.Duration, now time.Time) *timerWheel {
+	return &timerWheel{tick: tick, start: now}
+}
+
+// Run 开始时调用，返回 ticker 使用的 tick
+func (this_ *timerWheel) run() time.Duration {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	this_.running = true
+	return this_.tick
+}
+
+// now 之后 d 相对于时间轮创建时的纳秒数
+func (this_ *timerWheel) at(now time.Time, d time.Duration) int64 {
+	return int64(now.Sub(this_.start) + d)
+}
+
+// 向上取整，保证不会提前执行
+func (this_ *timerWheel) expireTick(when int64) int64 {
+	if when <= 0 {
+		return 0
+	}
+	return (when + int64(this_.tick) - 1) / int64(this_.tick)
+}
+
+func (this_ *timerWheel) add(t *timer) {
+	e := t.expire
+	delta := e - this_.next
+	if delta < 0 {
+		// 已经到期，下一个 tick 执行
+		this_.root[this_.next&wheelRootMask].push(t)
+		return
+	}
+	if delta < wheelRootSize {
+		this_.root[e&wheelRootMask].push(t)
+		return
+	}
+	if delta > wheelMaxDelta {
+		e = this_.next + wheelMaxDelta
+		delta = wheelMaxDelta
+	}
+	for l := 0; l < wheelLevels; l++ {
+		shift := uint(wheelRootBits + wheelLevelBits*l)
+		if delta < 1<<(shift+wheelLevelBits) || l == wheelLevels-1 {
+			this_.levels[l][(e>>shift)&wheelLevelMask].push(t)
+			return
+		}
+	}
+}
+
+func (this_ *timerWheel) schedule(t *timer, when int64) {
+	t.when = when
+	t.expire = this_.expireTick(when)
+	t.gen++
+	if t.state != timerPending {
+		t.state = timerPending
+		this_.count++
+	}
+	this_.add(t)
+}
+
+// now 之后 d 执行
+func (this_ *timerWheel) newTimer(now time.Time, d time.Duration, period time.Duration, repeat bool, f func(time.Time) bool) TimerID {
+	this_.mu.Lock()
+	this_.seq++
+	t := &timer{id: this_.seq, period: period, repeat: repeat, f: f, state: timerCanceled}
+	this_.schedule(t, this_.at(now, d))
+	this_.mu.Unlock()
+	return TimerID{w: this_, t: t}
+}
+
+// 上一层的槽转到了，里面的定时器重新放到下面的层
+func (this_ *timerWheel) cascade(n int64) {
+	for l := 0; l < wheelLevels; l++ {
+		shift := uint(wheelRootBits + wheelLevelBits*l)
+		idx := (n >> shift) & wheelLevelMask
+		for t := this_.levels[l][idx].take(); t != nil; {
+			next := t.next
+			t.slot, t.prev, t.next = nil, nil, nil
+			this_.add(t)
+			t = next
+		}
+		if idx != 0 {
+			return
+		}
+	}
+}
+
+// 处理到 now 为止到期的 tick，返回按到期时间和创建顺序排好的定时器
+func (this_ *timerWheel) advance(now time.Time) []timerFire {
+	// 上一批已经执行完，不再引用
+	for i := range this_.fires {
+		this_.fires[i] = timerFire{}
+	}
+	fires := this_.fires[:0]
+	this_.mu.Lock()
+	target := int64(now.Sub(this_.start) / this_.tick)
+	if this_.count == 0 {
+		// 没有定时器时所有的槽都是空的，直接跳过
+		if target >= this_.next {
+			this_.next = target + 1
+		}
+	}
+	for this_.next <= target {
+		n := this_.next
+		if n&wheelRootMask == 0 {
+			this_.cascade(n)
+		}
+		this_.next++
+		for t := this_.root[n&wheelRootMask].take(); t != nil; {
+			next := t.next
+			t.slot, t.prev, t.next = nil, nil, nil
+			if t.expire > n {
+				// 超过最上层范围的定时器，还没有到期
+				this_.add(t)
+			} else {
+				fires = append(fires, timerFire{t: t, gen: t.gen})
+			}
+			t = next
+		}
+	}
+	this_.mu.Unlock()
+	sort.Slice(fires, func(i, j int) bool {
+		if fires[i].t.when != fires[j].t.when {
+			return fires[i].t.when < fires[j].t.when
+		}
+		return fires[i].t.id < fires[j].t.id
+	})
+	this_.fires = fires
+	return fires
+}
+
+// 执行一个到期的定时器，执行之前被 Cancel 或者 Reset 的不执行
+func (this_ *timerWheel) fire(ft timerFire, now time.Time) {
+	t := ft.t
+	this_.mu.Lock()
+	if t.gen != ft.gen || t.state != timerPending {
+		this_.mu.Unlock()
+		return
+	}
+	t.state = timerFired
+	this_.count--
+	this_.mu.Unlock()
+
+	again := t.f(now)
+	if !t.repeat || !again {
+		return
+	}
+	this_.mu.Lock()
+	// 回调中没有 Cancel 或者 Reset 才继续
+	if t.gen == ft.gen && t.state == timerFired {
+		this_.schedule(t, this_.at(now, t.period))
+	}
+	this_.mu.Unlock()
+}
+
+func (this_ *timerWheel) Len() int {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	return this_.count
+}
+
+// TimerID AfterFunc、UntilFunc 和 Tick 返回的定时器，可以在任意协程中 Cancel 或者 Reset
+// 零值表示没有定时器，Cancel 和 Reset 都返回 false
+type TimerID struct {
+	w *timerWheel
+	t *timer
+}
+
+// Cancel 取消定时器，返回 false 表示已经执行、已经取消或者是零值
+// 在 Tick 的回调中调用时回调返回 true 也不会再执行
+func (this_ TimerID) Cancel() bool {
+	if this_.t == nil {
+		return false
+	}
+	w, t := this_.w, this_.t
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	active := t.state == timerPending
+	if t.slot != nil {
+		t.slot.remove(t)
+	}
+	if active {
+		w.count--
+	}
+	if t.state != timerCanceled {
+		t.state = timerCanceled
+		t.gen++
+	}
+	return active
+}
+
+// Reset 改为 d 之后执行，已经执行或者取消的定时器也会重新开始，Tick 之后仍然按原来的间隔执行
+// 返回 Reset 之前定时器是否还在等待执行
+func (this_ TimerID) Reset(d time.Duration) bool {
+	if this_.t == nil {
+		return false
+	}
+	w, t := this_.w, this_.t
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	active := t.state == timerPending
+	if t.slot != nil {
+		t.slot.remove(t)
+	}
+	w.schedule(t, w.at(time.Now(), d))
+	return active
+}
+
+// Active 定时器是否还在等待执行
+func (this_ TimerID) Active() bool {
+	if this_.t == nil {
+		return false
+	}
+	this_.w.mu.Lock()
+	defer this_.w.mu.Unlock()
+	return this_.t.state == timerPending
+}
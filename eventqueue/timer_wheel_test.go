@@ -0,0 +1,163 @@
+package eventqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fireAll(w *timerWheel, now time.Time) {
+	for _, v := range w.advance(now) {
+		w.fire(v, now)
+	}
+}
+
+func TestTimerWheel(t *testing.T) {
+	r := require.New(t)
+	base := time.Unix(1000, 0)
+	w := newTimerWheel(time.Millisecond, base)
+
+	var got []int
+	add := func(d time.Duration, v int) TimerID {
+		return w.newTimer(base, d, 0, false, func(time.Time) bool {
+			got = append(got, v)
+			return false
+		})
+	}
+
+	// 每一层的边界，不会提前也不会晚于一个 tick
+	for i, d := range []time.Duration{1, 255, 256, 300, 1 << 14, 1<<14 + 1, 70000, 1 << 20, 1<<20 + 5} {
+		got = nil
+		add(d*time.Millisecond, i)
+		fireAll(w, base.Add(d*time.Millisecond-time.Millisecond))
+		r.Empty(got, "delay %d", d)
+		fireAll(w, base.Add(d*time.Millisecond))
+		r.Equal([]int{i}, got, "delay %d", d)
+		base = base.Add(d * time.Millisecond)
+	}
+	r.Equal(0, w.Len())
+
+	// 同一个 tick 到期的按到期时间和创建顺序执行
+	got = nil
+	add(time.Millisecond*5, 1)
+	add(time.Millisecond*5-time.Microsecond, 0)
+	add(time.Millisecond*5, 2)
+	add(time.Millisecond*5, 3)
+	fireAll(w, base.Add(time.Millisecond*10))
+	r.Equal([]int{0, 1, 2, 3}, got)
+
+	// 已经过期的下一个 tick 执行
+	got = nil
+	add(-time.Second, 0)
+	fireAll(w, base.Add(time.Millisecond*11))
+	r.Equal([]int{0}, got)
+}
+
+func TestTimerID(t *testing.T) {
+	r := require.New(t)
+	now := time.Now()
+	w := newTimerWheel(time.Millisecond, now)
+	var got []int
+	add := func(d time.Duration, v int) TimerID {
+		return w.newTimer(now, d, 0, false, func(time.Time) bool {
+			got = append(got, v)
+			return false
+		})
+	}
+
+	var zero TimerID
+	r.False(zero.Cancel())
+	r.False(zero.Reset(time.Second))
+
+	id := add(time.Millisecond*10, 1)
+	r.True(id.Active())
+	r.True(id.Cancel())
+	r.False(id.Cancel())
+	r.False(id.Active())
+	r.Equal(0, w.Len())
+
+	// 取消之后 Reset 重新开始
+	r.False(id.Reset(time.Hour))
+	r.Equal(1, w.Len())
+	r.True(id.Reset(0))
+	now = time.Now().Add(time.Millisecond)
+	fireAll(w, now)
+	r.Equal([]int{1}, got)
+	r.False(id.Active())
+
+	// 同一批中前面的回调取消后面的定时器
+	got = nil
+	var second TimerID
+	w.newTimer(now, time.Millisecond*5, 0, false, func(time.Time) bool {
+		got = append(got, 1)
+		second.Cancel()
+		return false
+	})
+	second = add(time.Millisecond*5, 2)
+	// 到期时间向上取整到 tick，最多晚一个 tick
+	now = now.Add(time.Millisecond * 6)
+	fireAll(w, now)
+	r.Equal([]int{1}, got)
+	r.Equal(0, w.Len())
+
+	// Tick 返回 false 或者 Cancel 之后停止
+	n := 0
+	var tick TimerID
+	tick = w.newTimer(now, 0, time.Millisecond, true, func(time.Time) bool {
+		n++
+		if n == 3 {
+			tick.Cancel()
+		}
+		return true
+	})
+	for i := 1; i < 10; i++ {
+		fireAll(w, now.Add(time.Millisecond*time.Duration(i*2)))
+	}
+	r.Equal(3, n)
+	r.Equal(0, w.Len())
+}
+
+func TestRunTimers(t *testing.T) {
+	r := require.New(t)
+	q := NewEventQueue(0, nil)
+	r.False(q.SetTimerTick(0))
+	r.True(q.SetTimerTick(time.Millisecond))
+	q.Run(nil, func(interface{}) {})
+	// ticker 已经创建，不能再修改
+	r.False(q.SetTimerTick(time.Millisecond * 5))
+
+	done := make(chan time.Time, 1)
+	start := time.Now()
+	q.AfterFunc(time.Millisecond*20, func(now time.Time) {
+		done <- now
+	})
+	canceled := q.AfterFunc(time.Millisecond*10, func(time.Time) {
+		r.Fail("canceled timer fired")
+	})
+	r.True(canceled.Cancel())
+
+	ticks := make(chan struct{}, 10)
+	n := 0
+	q.Tick(time.Millisecond*5, func(time.Time) bool {
+		n++
+		ticks <- struct{}{}
+		return n < 3
+	})
+
+	select {
+	case now := <-done:
+		r.GreaterOrEqual(now.Sub(start), time.Millisecond*20)
+	case <-time.After(time.Second * 3):
+		r.FailNow("timer not fired")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(time.Second * 3):
+			r.FailNow("tick not fired")
+		}
+	}
+	r.Eventually(func() bool { return q.Stats().Timers == 0 }, time.Second, time.Millisecond*10)
+	r.NotNil(findStats(q.Stats(), timerEventType))
+}
@@ -0,0 +1,74 @@
+package attr
+
+import (
+	"sync"
+)
+
+// Attributes 连接上附带的数据，tcp.Session 和 ws.Session 都嵌入了这个结构，处理消息的代码可以通过 Holder 共用
+// 并发安全，连接关闭之后清空，之后再设置的值会被忽略
+type Attributes struct {
+	mu      sync.RWMutex
+	m       map[string]interface{}
+	user    interface{}
+	cleared bool
+}
+
+// Holder tcp.Session 和 ws.Session 都实现了这个接口
+type Holder interface {
+	Set(key string, value interface{})
+	Get(key string) (interface{}, bool)
+	Delete(key string)
+	SetUser(user interface{})
+	User() interface{}
+}
+
+// Set 设置 key 对应的值，连接已经关闭时忽略
+func (this_ *Attributes) Set(key string, value interface{}) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.cleared {
+		return
+	}
+	if this_.m == nil {
+		this_.m = map[string]interface{}{}
+	}
+	this_.m[key] = value
+}
+
+func (this_ *Attributes) Get(key string) (interface{}, bool) {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	v, ok := this_.m[key]
+	return v, ok
+}
+
+func (this_ *Attributes) Delete(key string) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	delete(this_.m, key)
+}
+
+// SetUser 设置连接对应的用户，一般是登录之后的玩家对象，连接已经关闭时忽略
+func (this_ *Attributes) SetUser(user interface{}) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.cleared {
+		return
+	}
+	this_.user = user
+}
+
+func (this_ *Attributes) User() interface{} {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	return this_.user
+}
+
+// ClearAttributes 清空所有的值，之后的 Set 和 SetUser 被忽略，连接关闭时自动调用
+func (this_ *Attributes) ClearAttributes() {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	this_.m = nil
+	this_.user = nil
+	this_.cleared = true
+}
@@ -0,0 +1,39 @@
+package attr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type player struct {
+	name string
+}
+
+func TestAttributes(t *testing.T) {
+	r := require.New(t)
+	a := &Attributes{}
+	_, ok := a.Get("room")
+	r.False(ok)
+	a.Set("room", 3)
+	v, ok := a.Get("room")
+	r.True(ok)
+	r.Equal(3, v)
+	a.Delete("room")
+	_, ok = a.Get("room")
+	r.False(ok)
+
+	a.SetUser(&player{name: "a"})
+	p, ok := a.User().(*player)
+	r.True(ok)
+	r.Equal("a", p.name)
+
+	// 关闭之后清空，再设置也不会保存
+	a.ClearAttributes()
+	r.Nil(a.User())
+	a.Set("room", 4)
+	a.SetUser(&player{})
+	_, ok = a.Get("room")
+	r.False(ok)
+	r.Nil(a.User())
+}
@@ -1,239 +1,386 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
 // source: basepb.proto
 
 package basepb
 
 import (
-	fmt "fmt"
-	proto "github.com/golang/protobuf/proto"
-	math "math"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
 )
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ = proto.Marshal
-var _ = fmt.Errorf
-var _ = math.Inf
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the proto package it is being compiled against.
-// A compilation error at this line likely means your copy of the
-// proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
 
 // 基础结构，主要提供给tcp内部使用，目前客户端用不到
 type Base struct {
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 }
 
-func (m *Base) Reset()         { *m = Base{} }
-func (m *Base) String() string { return proto.CompactTextString(m) }
-func (*Base) ProtoMessage()    {}
-func (*Base) Descriptor() ([]byte, []int) {
-	return fileDescriptor_acce15d0fbf96fc9, []int{0}
+func (x *Base) Reset() {
+	*x = Base{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_basepb_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Base) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Base.Unmarshal(m, b)
+func (x *Base) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Base) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Base.Marshal(b, m, deterministic)
-}
-func (m *Base) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Base.Merge(m, src)
-}
-func (m *Base) XXX_Size() int {
-	return xxx_messageInfo_Base.Size(m)
-}
-func (m *Base) XXX_DiscardUnknown() {
-	xxx_messageInfo_Base.DiscardUnknown(m)
+
+func (*Base) ProtoMessage() {}
+
+func (x *Base) ProtoReflect() protoreflect.Message {
+	mi := &file_basepb_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Base proto.InternalMessageInfo
+// Deprecated: Use Base.ProtoReflect.Descriptor instead.
+func (*Base) Descriptor() ([]byte, []int) {
+	return file_basepb_proto_rawDescGZIP(), []int{0}
+}
 
 // rpc 无消息返回时可以返回这个值
 type Base_Success struct {
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 }
 
-func (m *Base_Success) Reset()         { *m = Base_Success{} }
-func (m *Base_Success) String() string { return proto.CompactTextString(m) }
-func (*Base_Success) ProtoMessage()    {}
-func (*Base_Success) Descriptor() ([]byte, []int) {
-	return fileDescriptor_acce15d0fbf96fc9, []int{0, 0}
+func (x *Base_Success) Reset() {
+	*x = Base_Success{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_basepb_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Base_Success) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Base_Success.Unmarshal(m, b)
-}
-func (m *Base_Success) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Base_Success.Marshal(b, m, deterministic)
-}
-func (m *Base_Success) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Base_Success.Merge(m, src)
+func (x *Base_Success) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Base_Success) XXX_Size() int {
-	return xxx_messageInfo_Base_Success.Size(m)
-}
-func (m *Base_Success) XXX_DiscardUnknown() {
-	xxx_messageInfo_Base_Success.DiscardUnknown(m)
+
+func (*Base_Success) ProtoMessage() {}
+
+func (x *Base_Success) ProtoReflect() protoreflect.Message {
+	mi := &file_basepb_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Base_Success proto.InternalMessageInfo
+// Deprecated: Use Base_Success.ProtoReflect.Descriptor instead.
+func (*Base_Success) Descriptor() ([]byte, []int) {
+	return file_basepb_proto_rawDescGZIP(), []int{0, 0}
+}
 
 // rpc 发生错误时可以返回这个值
 type Base_Error struct {
-	ErrorCode            int64    `protobuf:"varint,1,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
-	ErrorMessage         string   `protobuf:"bytes,2,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
-	Fields               []string `protobuf:"bytes,3,rep,name=fields,proto3" json:"fields,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
-}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *Base_Error) Reset()         { *m = Base_Error{} }
-func (m *Base_Error) String() string { return proto.CompactTextString(m) }
-func (*Base_Error) ProtoMessage()    {}
-func (*Base_Error) Descriptor() ([]byte, []int) {
-	return fileDescriptor_acce15d0fbf96fc9, []int{0, 1}
+	ErrorCode    int64    `protobuf:"varint,1,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	ErrorMessage string   `protobuf:"bytes,2,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	Fields       []string `protobuf:"bytes,3,rep,name=fields,proto3" json:"fields,omitempty"`
 }
 
-func (m *Base_Error) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Base_Error.Unmarshal(m, b)
-}
-func (m *Base_Error) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Base_Error.Marshal(b, m, deterministic)
-}
-func (m *Base_Error) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Base_Error.Merge(m, src)
+func (x *Base_Error) Reset() {
+	*x = Base_Error{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_basepb_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *Base_Error) XXX_Size() int {
-	return xxx_messageInfo_Base_Error.Size(m)
+
+func (x *Base_Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Base_Error) XXX_DiscardUnknown() {
-	xxx_messageInfo_Base_Error.DiscardUnknown(m)
+
+func (*Base_Error) ProtoMessage() {}
+
+func (x *Base_Error) ProtoReflect() protoreflect.Message {
+	mi := &file_basepb_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Base_Error proto.InternalMessageInfo
+// Deprecated: Use Base_Error.ProtoReflect.Descriptor instead.
+func (*Base_Error) Descriptor() ([]byte, []int) {
+	return file_basepb_proto_rawDescGZIP(), []int{0, 1}
+}
 
-func (m *Base_Error) GetErrorCode() int64 {
-	if m != nil {
-		return m.ErrorCode
+func (x *Base_Error) GetErrorCode() int64 {
+	if x != nil {
+		return x.ErrorCode
 	}
 	return 0
 }
 
-func (m *Base_Error) GetErrorMessage() string {
-	if m != nil {
-		return m.ErrorMessage
+func (x *Base_Error) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
 	}
 	return ""
 }
 
-func (m *Base_Error) GetFields() []string {
-	if m != nil {
-		return m.Fields
+func (x *Base_Error) GetFields() []string {
+	if x != nil {
+		return x.Fields
 	}
 	return nil
 }
 
 // ping client 发给服务器
 type Base_Ping struct {
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 }
 
-func (m *Base_Ping) Reset()         { *m = Base_Ping{} }
-func (m *Base_Ping) String() string { return proto.CompactTextString(m) }
-func (*Base_Ping) ProtoMessage()    {}
-func (*Base_Ping) Descriptor() ([]byte, []int) {
-	return fileDescriptor_acce15d0fbf96fc9, []int{0, 2}
+func (x *Base_Ping) Reset() {
+	*x = Base_Ping{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_basepb_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Base_Ping) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Base_Ping.Unmarshal(m, b)
-}
-func (m *Base_Ping) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Base_Ping.Marshal(b, m, deterministic)
+func (x *Base_Ping) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Base_Ping) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Base_Ping.Merge(m, src)
-}
-func (m *Base_Ping) XXX_Size() int {
-	return xxx_messageInfo_Base_Ping.Size(m)
-}
-func (m *Base_Ping) XXX_DiscardUnknown() {
-	xxx_messageInfo_Base_Ping.DiscardUnknown(m)
+
+func (*Base_Ping) ProtoMessage() {}
+
+func (x *Base_Ping) ProtoReflect() protoreflect.Message {
+	mi := &file_basepb_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Base_Ping proto.InternalMessageInfo
+// Deprecated: Use Base_Ping.ProtoReflect.Descriptor instead.
+func (*Base_Ping) Descriptor() ([]byte, []int) {
+	return file_basepb_proto_rawDescGZIP(), []int{0, 2}
+}
 
 // Pong server 收到client 发来的ping时立马回复Pong
 type Base_Pong struct {
-	Now                  string   `protobuf:"bytes,1,opt,name=now,proto3" json:"now,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Now string `protobuf:"bytes,1,opt,name=now,proto3" json:"now,omitempty"`
 }
 
-func (m *Base_Pong) Reset()         { *m = Base_Pong{} }
-func (m *Base_Pong) String() string { return proto.CompactTextString(m) }
-func (*Base_Pong) ProtoMessage()    {}
-func (*Base_Pong) Descriptor() ([]byte, []int) {
-	return fileDescriptor_acce15d0fbf96fc9, []int{0, 3}
+func (x *Base_Pong) Reset() {
+	*x = Base_Pong{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_basepb_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Base_Pong) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Base_Pong.Unmarshal(m, b)
+func (x *Base_Pong) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Base_Pong) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Base_Pong.Marshal(b, m, deterministic)
+
+func (*Base_Pong) ProtoMessage() {}
+
+func (x *Base_Pong) ProtoReflect() protoreflect.Message {
+	mi := &file_basepb_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
-func (m *Base_Pong) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Base_Pong.Merge(m, src)
+
+// Deprecated: Use Base_Pong.ProtoReflect.Descriptor instead.
+func (*Base_Pong) Descriptor() ([]byte, []int) {
+	return file_basepb_proto_rawDescGZIP(), []int{0, 3}
 }
-func (m *Base_Pong) XXX_Size() int {
-	return xxx_messageInfo_Base_Pong.Size(m)
+
+func (x *Base_Pong) GetNow() string {
+	if x != nil {
+		return x.Now
+	}
+	return ""
 }
-func (m *Base_Pong) XXX_DiscardUnknown() {
-	xxx_messageInfo_Base_Pong.DiscardUnknown(m)
+
+var File_basepb_proto protoreflect.FileDescriptor
+
+var file_basepb_proto_rawDesc = []byte{
+	0x0a, 0x0c, 0x62, 0x61, 0x73, 0x65, 0x70, 0x62, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06,
+	0x62, 0x61, 0x73, 0x65, 0x70, 0x62, 0x22, 0x98, 0x01, 0x0a, 0x04, 0x62, 0x61, 0x73, 0x65, 0x1a,
+	0x09, 0x0a, 0x07, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x1a, 0x63, 0x0a, 0x05, 0x45, 0x72,
+	0x72, 0x6f, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x64,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f,
+	0x64, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x6d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x69, 0x65, 0x6c, 0x64,
+	0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x1a,
+	0x06, 0x0a, 0x04, 0x50, 0x69, 0x6e, 0x67, 0x1a, 0x18, 0x0a, 0x04, 0x50, 0x6f, 0x6e, 0x67, 0x12,
+	0x10, 0x0a, 0x03, 0x6e, 0x6f, 0x77, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6e, 0x6f,
+	0x77, 0x42, 0x42, 0x0a, 0x18, 0x63, 0x6f, 0x6d, 0x2e, 0x73, 0x72, 0x75, 0x69, 0x2e, 0x67, 0x61,
+	0x6d, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x2e, 0x62, 0x61, 0x73, 0x65, 0x70, 0x62, 0x5a, 0x26, 0x67,
+	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x6a, 0x6d, 0x64, 0x6b, 0x2f,
+	0x63, 0x6f, 0x6d, 0x6d, 0x6f, 0x6e, 0x2f, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x2f, 0x62,
+	0x61, 0x73, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
-var xxx_messageInfo_Base_Pong proto.InternalMessageInfo
+var (
+	file_basepb_proto_rawDescOnce sync.Once
+	file_basepb_proto_rawDescData = file_basepb_proto_rawDesc
+)
 
-func (m *Base_Pong) GetNow() string {
-	if m != nil {
-		return m.Now
-	}
-	return ""
+func file_basepb_proto_rawDescGZIP() []byte {
+	file_basepb_proto_rawDescOnce.Do(func() {
+		file_basepb_proto_rawDescData = protoimpl.X.CompressGZIP(file_basepb_proto_rawDescData)
+	})
+	return file_basepb_proto_rawDescData
 }
 
-func init() {
-	proto.RegisterType((*Base)(nil), "basepb.base")
-	proto.RegisterType((*Base_Success)(nil), "basepb.base.Success")
-	proto.RegisterType((*Base_Error)(nil), "basepb.base.Error")
-	proto.RegisterType((*Base_Ping)(nil), "basepb.base.Ping")
-	proto.RegisterType((*Base_Pong)(nil), "basepb.base.Pong")
-}
-
-func init() { proto.RegisterFile("basepb.proto", fileDescriptor_acce15d0fbf96fc9) }
-
-var fileDescriptor_acce15d0fbf96fc9 = []byte{
-	// 191 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x2c, 0x8f, 0x41, 0x4a, 0xc5, 0x30,
-	0x10, 0x40, 0x89, 0xa9, 0x91, 0x0c, 0x15, 0x24, 0x0b, 0x09, 0x01, 0xa1, 0xe8, 0xa6, 0xab, 0x6c,
-	0xbc, 0x81, 0xe2, 0x52, 0x28, 0xf1, 0x00, 0xd2, 0xa6, 0x63, 0x08, 0xb4, 0x9d, 0x92, 0x58, 0xbd,
-	0x8a, 0xc7, 0x95, 0x26, 0x7f, 0xf7, 0xde, 0x9b, 0xc5, 0xcc, 0x40, 0x3b, 0x8d, 0x19, 0xf7, 0xc9,
-	0xee, 0x89, 0xbe, 0x49, 0x89, 0x6a, 0x8f, 0x7f, 0x0c, 0x9a, 0x13, 0x8d, 0x84, 0x9b, 0x8f, 0xc3,
-	0x7b, 0xcc, 0xd9, 0x78, 0xb8, 0x7e, 0x4b, 0x89, 0x92, 0x7a, 0x00, 0xc0, 0x13, 0x3e, 0x3d, 0xcd,
-	0xa8, 0x59, 0xc7, 0x7a, 0xee, 0x64, 0x29, 0xaf, 0x34, 0xa3, 0x7a, 0x82, 0xdb, 0x3a, 0x5e, 0x31,
-	0xe7, 0x31, 0xa0, 0xbe, 0xea, 0x58, 0x2f, 0x5d, 0x5b, 0xe2, 0x7b, 0x6d, 0xea, 0x1e, 0xc4, 0x57,
-	0xc4, 0x65, 0xce, 0x9a, 0x77, 0xbc, 0x97, 0xee, 0x62, 0x46, 0x40, 0x33, 0xc4, 0x2d, 0x18, 0x0d,
-	0xcd, 0x40, 0x5b, 0x50, 0x77, 0xc0, 0x37, 0xfa, 0x2d, 0x4b, 0xa4, 0x3b, 0xf1, 0xc5, 0x80, 0xf6,
-	0xb4, 0xda, 0x9c, 0x8e, 0x68, 0xc3, 0xb8, 0xe2, 0x12, 0x7f, 0xd0, 0xd6, 0xb3, 0x27, 0x51, 0xbe,
-	0x78, 0xfe, 0x0f, 0x00, 0x00, 0xff, 0xff, 0xfe, 0xe9, 0xc0, 0x9b, 0xd5, 0x00, 0x00, 0x00,
+var file_basepb_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_basepb_proto_goTypes = []interface{}{
+	(*Base)(nil),         // 0: basepb.base
+	(*Base_Success)(nil), // 1: basepb.base.Success
+	(*Base_Error)(nil),   // 2: basepb.base.Error
+	(*Base_Ping)(nil),    // 3: basepb.base.Ping
+	(*Base_Pong)(nil),    // 4: basepb.base.Pong
+}
+var file_basepb_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_basepb_proto_init() }
+func file_basepb_proto_init() {
+	if File_basepb_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_basepb_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Base); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_basepb_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Base_Success); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_basepb_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Base_Error); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_basepb_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Base_Ping); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_basepb_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Base_Pong); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_basepb_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_basepb_proto_goTypes,
+		DependencyIndexes: file_basepb_proto_depIdxs,
+		MessageInfos:      file_basepb_proto_msgTypes,
+	}.Build()
+	File_basepb_proto = out.File
+	file_basepb_proto_rawDesc = nil
+	file_basepb_proto_goTypes = nil
+	file_basepb_proto_depIdxs = nil
 }
@@ -1,5 +1,5 @@
  for /r %%s in (*.proto) do (
- 	protoc -I. -I%GOPATH%/src -I%GOPATH%/src/github.com/njmdk/common/pbbase --go_out . --proto_path . ./%%~ns.proto
+ 	protoc -I. -I%GOPATH%/src -I%GOPATH%/src/github.com/njmdk/common/pbbase --go_out=paths=source_relative:. --proto_path . ./%%~ns.proto
  	protoc -I. -I%GOPATH%/src -I%GOPATH%/src/github.com/njmdk/common/pbbase --java_out %GOPATH%/src/github.com/njmdk/Server/pbmsg/java/ --proto_path . ./%%~ns.proto
  	protoc -I. -I%GOPATH%/src -I%GOPATH%/src/github.com/njmdk/common/pbbase --objc_out %GOPATH%/src/github.com/njmdk/Server/pbmsg/oc/basepb --proto_path . ./%%~ns.proto
  	protoc -I. -I%GOPATH%/src -I%GOPATH%/src/github.com/njmdk/common/pbbase --js_out %GOPATH%/src/github.com/njmdk/Server/pbmsg/js/basepb --proto_path . ./%%~ns.proto
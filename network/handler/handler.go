@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/attr"
+)
+
+// Session tcp.Session 和 ws.Session 共有的方法，只用到这些方法的处理代码可以同时服务 tcp 和 websocket 的客户端
+type Session interface {
+	attr.Holder
+	Send(msg proto.Message) error
+	Close(err error)
+	RemoteAddr() string
+}
+
+// DispatchInterface 和 tcp.DispatchInterface 一样，只是参数换成了 Session 接口
+// 通过 tcp.WrapDispatch 和 ws.WrapDispatch 转换之后设置给 Acceptor
+type DispatchInterface interface {
+	OnSessionConnected(Session)
+	OnSessionDisConnected(Session, error)
+	OnRPCRequest(Session, proto.Message) proto.Message
+	OnNormalMsg(Session, proto.Message)
+}
@@ -1,19 +1,18 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
-	"strconv"
 	"sync"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
 	"github.com/njmdk/common/network/basepb"
-	"github.com/njmdk/common/network/reuseport"
 	"github.com/njmdk/common/utils"
 )
 
@@ -52,7 +51,32 @@ type Acceptor struct {
 	codeC      CodeC
 	logger     *logger.Logger
 	dialConn   func(conn net.Conn)
+	transport  Transport
+	tlsConfig  *tls.Config
 	isDebugLog bool
+
+	maxMessageLen int
+	isCompress    bool
+	compressLen   int
+	// 当前所有的连接，用于 Shutdown
+	sessions sync.Map
+	limiter  *connLimiter
+
+	heartbeat HeartbeatConfig
+	sendQueue SendQueueConfig
+
+	isNumericID bool
+	// 连接认证，见 auth.go
+	auth        Authenticator
+	authTimeout time.Duration
+	// 握手时选择编码，见 codec_switch.go
+	isCodeCNegotiation bool
+	codecNames         []string
+
+	// 断线恢复，见 resume.go
+	isResume  bool
+	resumeCfg ResumeConfig
+	resumable sync.Map
 	sync.Once
 }
 
@@ -61,13 +85,20 @@ func (this_ *Acceptor) GetListener() net.Listener {
 }
 
 func NewAcceptor(addr string, queue *eventqueue.EventQueue, codeC CodeC, logger *logger.Logger, isDebugLog bool) (*Acceptor, error) {
-	l, err := reuseport.Listen("tcp4", addr)
+	return NewAcceptorWithTransport(addr, &TCPTransport{}, queue, codeC, logger, isDebugLog)
+}
+
+// NewAcceptorWithTransport 使用指定的 Transport 监听，比如 UnixTransport 或者 kcp.Transport
+func NewAcceptorWithTransport(addr string, transport Transport, queue *eventqueue.EventQueue, codeC CodeC, logger *logger.Logger, isDebugLog bool) (*Acceptor, error) {
+	l, err := transport.Listen(addr)
 	if err != nil {
 		return nil, err
 	}
-	tcpAddr := l.Addr().(*net.TCPAddr)
-	port := strconv.Itoa(tcpAddr.Port)
-	ip := tcpAddr.IP.String()
+	// unix socket 之类的地址没有端口
+	ip, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		ip, port = l.Addr().String(), ""
+	}
 	acceptor := &Acceptor{
 		addr:       addr,
 		queue:      queue,
@@ -78,6 +109,8 @@ func NewAcceptor(addr string, queue *eventqueue.EventQueue, codeC CodeC, logger
 		IP:         ip,
 		close:      make(chan struct{}),
 		isDebugLog: isDebugLog,
+		heartbeat:  DefaultHeartbeat,
+		transport:  transport,
 	}
 	acceptor.dialConn = acceptor.startConn
 	acceptor.ai = acceptor
@@ -85,6 +118,47 @@ func NewAcceptor(addr string, queue *eventqueue.EventQueue, codeC CodeC, logger
 	return acceptor, nil
 }
 
+// NewTLSAcceptor 创建使用 tls 的 Acceptor
+// 需要校验客户端证书时，设置 tlsConfig.ClientAuth 和 tlsConfig.ClientCAs，或者直接使用 NewServerTLSConfig 创建
+func NewTLSAcceptor(addr string, tlsConfig *tls.Config, queue *eventqueue.EventQueue, codeC CodeC, logger *logger.Logger, isDebugLog bool) (*Acceptor, error) {
+	return NewTLSAcceptorWithTransport(addr, &TCPTransport{}, tlsConfig, queue, codeC, logger, isDebugLog)
+}
+
+// NewTLSAcceptorWithTransport 在指定的 Transport 上使用 tls
+func NewTLSAcceptorWithTransport(addr string, transport Transport, tlsConfig *tls.Config, queue *eventqueue.EventQueue, codeC CodeC, logger *logger.Logger, isDebugLog bool) (*Acceptor, error) {
+	if tlsConfig == nil {
+		return nil, errors.New("tls config is nil")
+	}
+	acceptor, err := NewAcceptorWithTransport(addr, transport, queue, codeC, logger, isDebugLog)
+	if err != nil {
+		return nil, err
+	}
+	acceptor.tlsConfig = tlsConfig
+	acceptor.listener = tls.NewListener(acceptor.listener, tlsConfig)
+	acceptor.dialConn = acceptor.startTLSConn
+	return acceptor, nil
+}
+
+// GetTransport 监听使用的 Transport
+func (this_ *Acceptor) GetTransport() Transport {
+	return this_.transport
+}
+
+func (this_ *Acceptor) IsTLS() bool {
+	return this_.tlsConfig != nil
+}
+
+// SetMaxMessageLen 设置分片重组后单个消息的最大长度，对之后接入的连接生效
+func (this_ *Acceptor) SetMaxMessageLen(n int) {
+	this_.maxMessageLen = n
+}
+
+// SetCompress 开启消息压缩，body 超过 threshold 字节的消息会被压缩，只对同样开启了压缩的客户端生效
+func (this_ *Acceptor) SetCompress(threshold int) {
+	this_.isCompress = true
+	this_.compressLen = threshold
+}
+
 func (this_ *Acceptor) SetCallback(ai DispatchInterface) {
 	this_.ai = ai
 }
@@ -98,25 +172,25 @@ func (this_ *Acceptor) DoConcurrent(msgID string) bool {
 }
 
 func (this_ *Acceptor) OnSessionConnected(session *Session) {
-	this_.logger.Debug("session connected", zap.String("local", session.LocalAddr()), zap.String("remote", session.remoteAddr))
+	this_.logger.Debug("session connected", zap.String("local", session.LocalAddr()), zap.String("remote", session.RemoteAddr()))
 }
 
 func (this_ *Acceptor) OnSessionDisConnected(session *Session, err error) {
 	if this_.isDebugLog {
-		this_.logger.Warn("session disconnected", zap.Error(err), zap.String("local", session.LocalAddr()), zap.String("remote", session.remoteAddr))
+		this_.logger.Warn("session disconnected", zap.Error(err), zap.String("local", session.LocalAddr()), zap.String("remote", session.RemoteAddr()))
 	}
 }
 
 func (this_ *Acceptor) OnRPCRequest(session *Session, msg proto.Message) proto.Message {
 	if this_.isDebugLog {
-		this_.logger.Debug("session recv rpc request", zap.String("msgID", proto.MessageName(msg)), zap.Any("request", msg), zap.String("local", session.LocalAddr()), zap.String("remote", session.remoteAddr))
+		this_.logger.Debug("session recv rpc request", zap.String("msgID", messageName(msg)), zap.Any("request", msg), zap.String("local", session.LocalAddr()), zap.String("remote", session.RemoteAddr()))
 	}
 	return &basepb.Base_Success{}
 }
 
 func (this_ *Acceptor) OnNormalMsg(session *Session, msg proto.Message) {
 	if this_.isDebugLog {
-		this_.logger.Debug("session normal message", zap.String("msgID", proto.MessageName(msg)), zap.Any("msg", msg), zap.String("local", session.LocalAddr()), zap.String("remote", session.remoteAddr))
+		this_.logger.Debug("session normal message", zap.String("msgID", messageName(msg)), zap.Any("msg", msg), zap.String("local", session.LocalAddr()), zap.String("remote", session.RemoteAddr()))
 	}
 }
 
@@ -129,29 +203,95 @@ func (this_ *Acceptor) Close() {
 
 func (this_ *Acceptor) startConn(conn net.Conn) {
 	sess := NewSession(conn, this_.queue, true, this_.codeC, this_.logger, time.Second*2, this_.isDebugLog)
+	sess.SetMaxMessageLen(this_.maxMessageLen)
+	if this_.isCompress {
+		sess.EnableCompress(this_.compressLen)
+	}
+	if this_.isNumericID {
+		sess.EnableNumericID()
+	}
+	if this_.isCodeCNegotiation {
+		sess.SetCodeCNegotiation(this_.codecNames...)
+	}
+	sess.SetReadIdleTimeout(this_.heartbeat.ReadIdleTimeout)
+	sess.SetSendQueue(this_.sendQueue)
+	if this_.limiter != nil {
+		sess.msgLimiter = newTokenBucket(this_.limiter.limit.MsgRate, this_.limiter.limit.MsgBurst)
+	}
+	// 先设置好再通知上层，AcceptSession 在 queue 中会读取
+	sess.Dispatch = this_.ai
+	if this_.dc != nil {
+		sess.DoConcurrent = this_.dc
+	}
+	this_.sessions.Store(sess, struct{}{})
 	sess.onClose = func(e error) {
+		// 过期的会话在断线的时候已经释放过了
+		if !sess.isExpired() {
+			this_.sessions.Delete(sess)
+			this_.releaseConn(sess.getConn())
+		}
+		if sess.resume != nil && !this_.forgetResumable(sess) {
+			return
+		}
+		if !sess.authPassed() {
+			sess.ClearAttributes()
+			return
+		}
 		this_.queue.Post(&SessionClosed{
 			Err:  e,
 			Sess: sess,
 		})
 	}
 
+	if this_.auth != nil {
+		this_.startAuth(sess)
+	}
+	if this_.isResume {
+		// 等客户端的第一个包确定是新会话还是恢复
+		sess.resume = &resumeState{acceptor: this_, limit: this_.resumeCfg.ReplayBuffer}
+		this_.queue.AfterFunc(this_.resumeCfg.WaitFirstFrame, func(time.Time) {
+			this_.announceOnce(sess)
+		})
+	} else {
+		this_.postAccept(sess)
+		this_.startHeartbeat(sess)
+	}
+	sess.Start()
+}
+
+// 需要认证的连接等认证通过之后再通知上层，见 auth.go
+func (this_ *Acceptor) postAccept(sess *Session) {
+	if sess.auth != nil {
+		return
+	}
 	this_.queue.Post(&AcceptSession{
 		sess,
 	})
+}
 
-	this_.queue.Tick(time.Second*30, func(i time.Time) bool {
-		if i.Unix()-sess.lastPongTime > 30 {
-			sess.Close(errors.New("ping pong timeout"))
-			return false
+// tls 握手放在单独的协程里面做，避免阻塞 Accept
+func (this_ *Acceptor) startTLSConn(conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		this_.startConn(conn)
+		return
+	}
+	utils.SafeGO(func(e interface{}) {
+		this_.logger.Error("tls handshake panic", zap.Any("panic info", e), zap.String("remote", conn.RemoteAddr().String()))
+		_ = conn.Close()
+		this_.releaseConn(conn)
+	}, func() {
+		err := tlsHandshake(tlsConn, tlsHandshakeTimeout)
+		if err != nil {
+			if this_.isDebugLog {
+				this_.logger.Warn("tls handshake failed", zap.Error(err), zap.String("remote", conn.RemoteAddr().String()))
+			}
+			_ = conn.Close()
+			this_.releaseConn(conn)
+			return
 		}
-		return true
+		this_.startConn(conn)
 	})
-	sess.Dispatch = this_.ai
-	if this_.dc != nil {
-		sess.DoConcurrent = this_.dc
-	}
-	sess.Start()
 }
 
 func (this_ *Acceptor) StartAccept() {
@@ -184,6 +324,9 @@ func (this_ *Acceptor) StartAccept() {
 				return
 			}
 			tempDelay = 0
+			if !this_.acquireConn(conn) {
+				continue
+			}
 			this_.dialConn(conn)
 		}
 	})
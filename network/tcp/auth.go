@@ -0,0 +1,198 @@
+package tcp
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/network/basepb"
+)
+
+// 连接认证，设置了 Authenticator 的 Acceptor 接入的连接先进入认证阶段
+// 客户端的第一个消息(普通消息或者 rpc 请求)或者 token 包交给 Authenticator，通过之后才调用 OnSessionConnected
+// 认证期间心跳正常处理，其他的包直接关闭连接，认证之前发过来的后续消息等认证通过之后按顺序分发
+// 没有通过认证的连接关闭时不调用 OnSessionDisConnected
+//
+// token 包 = header(packetProtocolAuth) + msgID(Ping) + token
+
+var (
+	// 连接建立之后没有在规定时间内通过认证
+	ErrAuthTimeout = errors.New("authenticate timeout")
+	// 认证被拒绝，或者认证之前发送了不允许的包
+	ErrAuthRejected = errors.New("authenticate rejected")
+)
+
+// 默认10秒内需要通过认证
+const defaultAuthTimeout = time.Second * 10
+
+// 拒绝之后最多等多久把错误发给客户端
+const authRejectFlushTimeout = time.Second
+
+// AuthRequest 交给 Authenticator 的认证请求
+type AuthRequest struct {
+	// 客户端的第一个消息，客户端发送的是 token 包时为 nil
+	Msg proto.Message
+	// 第一个消息是 rpc 请求，通过时返回 Base_Success，拒绝时返回 Base_Error
+	IsRPC bool
+	// 客户端通过 WithAuthToken 发送的 token
+	Token []byte
+}
+
+// Authenticator 在 EventQueue 中调用
+// 通过时返回 nil，identity 可以通过 Session.Identity 取到，也可以在这里设置 SessionID 和 SessionName
+// 拒绝时返回的 Base_Error 会发给客户端，然后关闭连接
+type Authenticator interface {
+	Authenticate(s *Session, req *AuthRequest) (identity interface{}, reject *basepb.Base_Error)
+}
+
+type AuthenticatorFunc func(s *Session, req *AuthRequest) (interface{}, *basepb.Base_Error)
+
+func (this_ AuthenticatorFunc) Authenticate(s *Session, req *AuthRequest) (interface{}, *basepb.Base_Error) {
+	return this_(s, req)
+}
+
+const (
+	authPending uint32 = iota
+	authPassed
+	authRejected
+)
+
+type authState struct {
+	acceptor *Acceptor
+	phase    uint32
+	identity interface{}
+	// 认证超时的定时器，离开 authPending 时停止
+	timer eventqueue.TimerID
+}
+
+// 从 authPending 转到 phase，同时停止认证超时的定时器，已经不是 authPending 时返回 false
+func (this_ *authState) leave(phase uint32) bool {
+	if !atomic.CompareAndSwapUint32(&this_.phase, authPending, phase) {
+		return false
+	}
+	this_.timer.Cancel()
+	return true
+}
+
+// SetAuthenticator 开启连接认证，timeout 内没有通过认证的连接会被关闭，为0时使用10秒，对之后接入的连接生效
+func (this_ *Acceptor) SetAuthenticator(auth Authenticator, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+	this_.auth = auth
+	this_.authTimeout = timeout
+}
+
+// 在 Start 之前调用
+func (this_ *Acceptor) startAuth(sess *Session) {
+	sess.auth = &authState{acceptor: this_}
+	sess.auth.timer = this_.queue.AfterFunc(this_.authTimeout, func(time.Time) {
+		if sess.auth.leave(authRejected) {
+			if this_.isDebugLog {
+				this_.logger.Debug("session authenticate timeout", zap.String("remote", sess.RemoteAddr()))
+			}
+			sess.Close(ErrAuthTimeout)
+		}
+	})
+}
+
+// 在 queue 中调用，返回 true 表示包已经被认证处理掉，passed 表示这个包让连接通过了认证
+func (this_ *Session) authPacket(p *Packet) (consumed bool, passed bool) {
+	a := this_.auth
+	if a == nil {
+		return false, false
+	}
+	switch atomic.LoadUint32(&a.phase) {
+	case authPassed:
+		return false, false
+	case authRejected:
+		if p.Protocol == packetProtocolRPCRequest {
+			this_.rpcDone()
+		}
+		return true, false
+	}
+	switch p.Protocol {
+	case packetProtocolNormal:
+		if p.MsgID == messagePingName || p.MsgID == messagePongName {
+			return false, false
+		}
+	case packetProtocolRPCRequest, packetProtocolAuth:
+	case packetProtocolRPCResponse, packetProtocolGoAway, packetProtocolResumeResult:
+		return false, false
+	default:
+		a.leave(authRejected)
+		this_.Close(ErrAuthRejected)
+		return true, false
+	}
+	return true, a.acceptor.authenticate(this_, p)
+}
+
+func (this_ *Acceptor) authenticate(sess *Session, p *Packet) bool {
+	req := &AuthRequest{Msg: p.Msg, IsRPC: p.Protocol == packetProtocolRPCRequest}
+	if p.Protocol == packetProtocolAuth {
+		req.Token = p.data
+	}
+	identity, reject := this_.auth.Authenticate(sess, req)
+	if reject != nil {
+		if !sess.auth.leave(authRejected) {
+			return false
+		}
+		if this_.isDebugLog {
+			this_.logger.Debug("session authenticate rejected", zap.String("remote", sess.RemoteAddr()), zap.Any("reject", reject))
+		}
+		if req.IsRPC {
+			_ = sess.sendResponse(p.RPCIndex, reject)
+			sess.rpcDone()
+		} else {
+			_ = sess.Send(reject)
+		}
+		sess.closeAfterFlush(ErrAuthRejected, authRejectFlushTimeout)
+		return false
+	}
+	if !sess.auth.leave(authPassed) {
+		return false
+	}
+	sess.auth.identity = identity
+	if req.IsRPC {
+		err := sess.sendResponse(p.RPCIndex, &basepb.Base_Success{})
+		sess.rpcDone()
+		if err != nil {
+			sess.Close(err)
+		}
+	}
+	return true
+}
+
+// 没有通过认证的连接关闭时不通知上层
+func (this_ *Session) authPassed() bool {
+	return this_.auth == nil || atomic.LoadUint32(&this_.auth.phase) == authPassed
+}
+
+// Identity 认证通过时 Authenticator 返回的 identity，没有开启认证时返回 nil
+func (this_ *Session) Identity() interface{} {
+	if this_.auth == nil || !this_.authPassed() {
+		return nil
+	}
+	return this_.auth.identity
+}
+
+// IsAuthenticated 连接是否已经通过认证，没有开启认证的连接总是返回 true
+func (this_ *Session) IsAuthenticated() bool {
+	return this_.authPassed()
+}
+
+// 客户端在握手之后发送 token
+func (this_ *Session) sendAuthToken(token []byte) error {
+	return this_.sendRawByte(packetProtocolAuth, 0, messagePingName, token)
+}
+
+// WithAuthToken 连接建立之后发送 token 给服务器的 Authenticator，每次连接都会调用 token 取最新的值
+func WithAuthToken(token func() []byte) Option {
+	return func(c *Connector) {
+		c.authToken = token
+	}
+}
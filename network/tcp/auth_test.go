@@ -0,0 +1,92 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+type recordDispatch struct {
+	connected int
+	msgs      []proto.Message
+}
+
+func (this_ *recordDispatch) OnSessionConnected(*Session)           { this_.connected++ }
+func (this_ *recordDispatch) OnSessionDisConnected(*Session, error) {}
+func (this_ *recordDispatch) OnNormalMsg(_ *Session, m proto.Message) {
+	this_.msgs = append(this_.msgs, m)
+}
+func (this_ *recordDispatch) OnRPCRequest(*Session, proto.Message) proto.Message {
+	return &basepb.Base_Success{}
+}
+
+func TestAuthenticator(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	acceptor := &Acceptor{logger: log}
+	acceptor.SetAuthenticator(AuthenticatorFunc(func(s *Session, req *AuthRequest) (interface{}, *basepb.Base_Error) {
+		if string(req.Token) == "ok" {
+			s.SessionID = "1"
+			return "player", nil
+		}
+		return nil, &basepb.Base_Error{ErrorCode: 401, ErrorMessage: "bad token"}
+	}), time.Minute)
+	dispatch := DispatchMsg(func(interface{}) {}, nil)
+
+	newSession := func() (*Session, *recordDispatch) {
+		sess := newQueuedSession(r, log, SendQueueConfig{})
+		acceptor.queue = sess.queue
+		acceptor.startAuth(sess)
+		d := &recordDispatch{}
+		sess.Dispatch = d
+		return sess, d
+	}
+
+	sess, d := newSession()
+	// 心跳不需要认证
+	dispatch(&Packet{Sess: sess, MsgID: messagePingName, Msg: &basepb.Base_Ping{}, Protocol: packetProtocolNormal})
+	r.Len(sess.sendBuf, 1)
+	r.False(sess.IsAuthenticated())
+	r.True(sess.auth.timer.Active())
+	dispatch(&Packet{Sess: sess, MsgID: messagePingName, Protocol: packetProtocolAuth, data: []byte("ok")})
+	r.True(sess.IsAuthenticated())
+	r.False(sess.auth.timer.Active())
+	r.Equal(1, d.connected)
+	r.Equal("player", sess.Identity())
+	r.Equal("1", sess.SessionID)
+	dispatch(&Packet{Sess: sess, MsgID: messageName(&basepb.Base_Error{}), Msg: &basepb.Base_Error{}, Protocol: packetProtocolNormal})
+	r.Len(d.msgs, 1)
+
+	// rpc 请求被拒绝时返回 Base_Error，之后的消息丢弃
+	sess, d = newSession()
+	sess.inflight = 2
+	dispatch(&Packet{Sess: sess, MsgID: messageName(&basepb.Base_Success{}), Msg: &basepb.Base_Success{}, Protocol: packetProtocolRPCRequest, RPCIndex: 3})
+	dispatch(&Packet{Sess: sess, MsgID: messageName(&basepb.Base_Success{}), Msg: &basepb.Base_Success{}, Protocol: packetProtocolRPCRequest, RPCIndex: 4})
+	r.False(sess.IsAuthenticated())
+	r.Nil(sess.Identity())
+	r.Equal(0, d.connected)
+	r.EqualValues(0, sess.inflight)
+	r.Len(sess.sendBuf, 1)
+	p, _, err := newQueuedSession(r, log, SendQueueConfig{}).recvOne(sess.sendBuf[0])
+	r.NoError(err)
+	r.Equal(packetProtocolRPCResponse, p.Protocol)
+	r.EqualValues(401, p.Msg.(*basepb.Base_Error).ErrorCode)
+	r.False(sess.auth.timer.Active())
+	// 发送协程写完拒绝的返回之后关闭连接
+	buf, ok := sess.waitSendBuf(sess.gen)
+	r.True(ok)
+	r.Len(buf, 1)
+	r.False(sess.isClose())
+	_, ok = sess.waitSendBuf(sess.gen)
+	r.False(ok)
+	r.True(sess.isClose())
+
+	// 认证之前不允许打开流
+	sess, _ = newSession()
+	dispatch(&Packet{Sess: sess, MsgID: messagePingName, Protocol: packetProtocolStreamOpen})
+	r.True(sess.isClose())
+}
@@ -1,9 +1,12 @@
 package tcp
 
 import (
-	"encoding/json"
+	"sync"
 
-	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 type CodeC interface {
@@ -12,6 +15,8 @@ type CodeC interface {
 	String() string
 }
 
+// JSONCodeC 使用 protojson 编码，字段名使用 proto 中的名字，oneof、枚举和 well-known types 按照 proto3 json 规范处理
+// 解码时忽略不认识的字段
 type JSONCodeC struct{}
 
 func (this_ *JSONCodeC) String() string {
@@ -19,11 +24,27 @@ func (this_ *JSONCodeC) String() string {
 }
 
 func (this_ *JSONCodeC) Encode(v proto.Message) ([]byte, error) {
-	return json.Marshal(v)
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(v)
 }
 
 func (this_ *JSONCodeC) Decode(data []byte, v proto.Message) error {
-	return json.Unmarshal(data, v)
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, v)
+}
+
+// ProtoJSONCodeC 标准的 proto3 json，字段名使用 lowerCamelCase 的 json_name，和各语言官方的 protobuf json 库一致
+// 解码时字段名两种写法都可以，忽略不认识的字段
+type ProtoJSONCodeC struct{}
+
+func (this_ *ProtoJSONCodeC) String() string {
+	return "protojson"
+}
+
+func (this_ *ProtoJSONCodeC) Encode(v proto.Message) ([]byte, error) {
+	return protojson.Marshal(v)
+}
+
+func (this_ *ProtoJSONCodeC) Decode(data []byte, v proto.Message) error {
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, v)
 }
 
 type ProtoCodeC struct{}
@@ -39,3 +60,50 @@ func (this_ *ProtoCodeC) Encode(v proto.Message) ([]byte, error) {
 func (this_ *ProtoCodeC) Decode(data []byte, v proto.Message) error {
 	return proto.Unmarshal(data, v)
 }
+
+// 消息的全名，作为默认的 msgID，m 为 nil 时返回空字符串
+func messageName(m proto.Message) string {
+	return string(proto.MessageName(m))
+}
+
+// 根据消息全名从 protoregistry.GlobalTypes 中查找消息类型，没有注册时返回 nil
+func findMessageType(name string) protoreflect.MessageType {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	return mt
+}
+
+// 按照 CodeC.String() 注册的编码，客户端可以在握手时选择其中一种，见 codec_switch.go
+var codecRegistry = struct {
+	sync.RWMutex
+	m map[string]CodeC
+}{
+	m: map[string]CodeC{},
+}
+
+func init() {
+	RegisterCodeC(&ProtoCodeC{})
+	RegisterCodeC(&JSONCodeC{})
+	RegisterCodeC(&ProtoJSONCodeC{})
+	RegisterCodeC(&MsgpackCodeC{})
+}
+
+// RegisterCodeC 注册一种编码，同名的会被覆盖，名字为空会 panic
+func RegisterCodeC(c CodeC) {
+	name := c.String()
+	if name == "" {
+		panic("register codec: empty name")
+	}
+	codecRegistry.Lock()
+	defer codecRegistry.Unlock()
+	codecRegistry.m[name] = c
+}
+
+// GetCodeC 根据名字返回注册的编码，没有注册时返回 nil
+func GetCodeC(name string) CodeC {
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+	return codecRegistry.m[name]
+}
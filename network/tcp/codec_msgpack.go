@@ -0,0 +1,236 @@
+package tcp
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MsgpackCodeC 按照消息的 proto 描述编码成 msgpack，消息是一个 map，key 为 proto 中的字段名
+// 枚举编码成数字，oneof 只编码设置了的那个字段，bytes 编码成 bin，没有设置的字段不编码
+// 解码时忽略不认识的字段，数字类型之间可以互相转换，方便动态语言的客户端
+type MsgpackCodeC struct{}
+
+func (this_ *MsgpackCodeC) String() string {
+	return "msgpack"
+}
+
+func (this_ *MsgpackCodeC) Encode(v proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	if err := encodeMsgpackMessage(enc, v.ProtoReflect()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (this_ *MsgpackCodeC) Decode(data []byte, v proto.Message) error {
+	proto.Reset(v)
+	return decodeMsgpackMessage(msgpack.NewDecoder(bytes.NewReader(data)), v.ProtoReflect())
+}
+
+func encodeMsgpackMessage(enc *msgpack.Encoder, m protoreflect.Message) error {
+	n := 0
+	m.Range(func(protoreflect.FieldDescriptor, protoreflect.Value) bool {
+		n++
+		return true
+	})
+	if err := enc.EncodeMapLen(n); err != nil {
+		return err
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if err = enc.EncodeString(string(fd.Name())); err != nil {
+			return false
+		}
+		err = encodeMsgpackField(enc, fd, v)
+		return err == nil
+	})
+	return err
+}
+
+func encodeMsgpackField(enc *msgpack.Encoder, fd protoreflect.FieldDescriptor, v protoreflect.Value) error {
+	switch {
+	case fd.IsList():
+		l := v.List()
+		if err := enc.EncodeArrayLen(l.Len()); err != nil {
+			return err
+		}
+		for i := 0; i < l.Len(); i++ {
+			if err := encodeMsgpackValue(enc, fd, l.Get(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case fd.IsMap():
+		mp := v.Map()
+		if err := enc.EncodeMapLen(mp.Len()); err != nil {
+			return err
+		}
+		var err error
+		mp.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			if err = encodeMsgpackValue(enc, fd.MapKey(), k.Value()); err != nil {
+				return false
+			}
+			err = encodeMsgpackValue(enc, fd.MapValue(), v)
+			return err == nil
+		})
+		return err
+	}
+	return encodeMsgpackValue(enc, fd, v)
+}
+
+func encodeMsgpackValue(enc *msgpack.Encoder, fd protoreflect.FieldDescriptor, v protoreflect.Value) error {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return enc.EncodeBool(v.Bool())
+	case protoreflect.EnumKind:
+		return enc.EncodeInt(int64(v.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return enc.EncodeInt(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return enc.EncodeUint(v.Uint())
+	case protoreflect.FloatKind:
+		return enc.EncodeFloat32(float32(v.Float()))
+	case protoreflect.DoubleKind:
+		return enc.EncodeFloat64(v.Float())
+	case protoreflect.StringKind:
+		return enc.EncodeString(v.String())
+	case protoreflect.BytesKind:
+		return enc.EncodeBytes(v.Bytes())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return encodeMsgpackMessage(enc, v.Message())
+	}
+	return fmt.Errorf("msgpack: unsupported field kind %s of %s", fd.Kind(), fd.FullName())
+}
+
+func decodeMsgpackMessage(dec *msgpack.Decoder, m protoreflect.Message) error {
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < n; i++ {
+		name, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			if err = dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = decodeMsgpackField(dec, m, fd); err != nil {
+			return fmt.Errorf("msgpack: field %s: %w", fd.FullName(), err)
+		}
+	}
+	return nil
+}
+
+func decodeMsgpackField(dec *msgpack.Decoder, m protoreflect.Message, fd protoreflect.FieldDescriptor) error {
+	switch {
+	case fd.IsList():
+		n, err := dec.DecodeArrayLen()
+		if err != nil {
+			return err
+		}
+		l := m.Mutable(fd).List()
+		for i := 0; i < n; i++ {
+			if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+				v := l.NewElement()
+				if err = decodeMsgpackMessage(dec, v.Message()); err != nil {
+					return err
+				}
+				l.Append(v)
+				continue
+			}
+			v, err := decodeMsgpackScalar(dec, fd)
+			if err != nil {
+				return err
+			}
+			l.Append(v)
+		}
+		return nil
+	case fd.IsMap():
+		n, err := dec.DecodeMapLen()
+		if err != nil {
+			return err
+		}
+		mp := m.Mutable(fd).Map()
+		for i := 0; i < n; i++ {
+			k, err := decodeMsgpackScalar(dec, fd.MapKey())
+			if err != nil {
+				return err
+			}
+			vd := fd.MapValue()
+			if vd.Kind() == protoreflect.MessageKind {
+				v := mp.NewValue()
+				if err = decodeMsgpackMessage(dec, v.Message()); err != nil {
+					return err
+				}
+				mp.Set(k.MapKey(), v)
+				continue
+			}
+			v, err := decodeMsgpackScalar(dec, vd)
+			if err != nil {
+				return err
+			}
+			mp.Set(k.MapKey(), v)
+		}
+		return nil
+	case fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind:
+		return decodeMsgpackMessage(dec, m.Mutable(fd).Message())
+	}
+	v, err := decodeMsgpackScalar(dec, fd)
+	if err != nil {
+		return err
+	}
+	m.Set(fd, v)
+	return nil
+}
+
+func decodeMsgpackScalar(dec *msgpack.Decoder, fd protoreflect.FieldDescriptor) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v, err := dec.DecodeBool()
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.EnumKind:
+		v, err := dec.DecodeInt32()
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := dec.DecodeInt32()
+		return protoreflect.ValueOfInt32(v), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := dec.DecodeInt64()
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := dec.DecodeUint32()
+		return protoreflect.ValueOfUint32(v), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := dec.DecodeUint64()
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		// 很多客户端的浮点数都编码成 float64
+		v, err := dec.DecodeFloat64()
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := dec.DecodeFloat64()
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		v, err := dec.DecodeString()
+		return protoreflect.ValueOfString(v), err
+	case protoreflect.BytesKind:
+		v, err := dec.DecodeBytes()
+		return protoreflect.ValueOfBytes(v), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
@@ -0,0 +1,139 @@
+package tcp
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// 客户端收到服务器的握手包、确认服务器支持选择编码之后，发送请求 = header(packetProtocolCodeC, rpcIndex=codecRequest) + msgID(Ping) + 编码名字
+// 服务器允许的话切换到这个编码，两个方向各自切换：
+// 发送方在发送缓存中放一个切换包 = header(packetProtocolCodeC, rpcIndex=0) + msgID(Ping) + 编码名字，之后的消息都用新的编码
+// 接收方收到这个包之后用新的编码解码，所以切换之前已经发出的消息不会解错
+// 服务器切换之后发出切换包，客户端收到后解码和发送都切换过去
+// 老版本的服务器握手时没有这个能力，客户端不发送请求，继续使用默认的编码
+
+// packetProtocolCodeC 的 rpcIndex，表示请求使用这个编码
+const codecRequest uint32 = 1
+
+// 切换编码的包，body 为编码名字
+func codecFrame(name string) []byte {
+	return appendFrame(nil, packetProtocolCodeC, 0, messagePingName, nil, []byte(name))
+}
+
+// GetCodeC 当前发送使用的编码，握手之后可能会变
+func (this_ *Session) GetCodeC() CodeC {
+	this_.codecMu.RLock()
+	defer this_.codecMu.RUnlock()
+	return this_.CodeC
+}
+
+// SetCodeCNegotiation 允许客户端在握手时选择编码，names 为空时允许所有注册了的编码，需要在 Start 之前调用
+func (this_ *Session) SetCodeCNegotiation(names ...string) {
+	this_.localCaps |= capCodeC
+	this_.allowCodeCs = names
+}
+
+// RequestCodeC 握手之后请求使用 name 编码，服务器同意之后才会切换，需要在 Start 之前调用
+func (this_ *Session) RequestCodeC(name string) {
+	this_.wantCodeC = name
+	this_.localCaps |= capCodeC
+}
+
+// 只在 recvLoop 中使用
+func (this_ *Session) decodeC() CodeC {
+	if this_.recvCodeC != nil {
+		return this_.recvCodeC
+	}
+	return this_.CodeC
+}
+
+func (this_ *Session) codecAllowed(name string) bool {
+	if this_.localCaps&capCodeC == 0 || this_.wantCodeC != "" {
+		return false
+	}
+	if len(this_.allowCodeCs) == 0 {
+		return true
+	}
+	for _, v := range this_.allowCodeCs {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 服务器收到客户端想要使用的编码，不允许或者没有注册时继续使用默认的编码
+func (this_ *Session) acceptCodeC(name string) error {
+	if !this_.codecAllowed(name) {
+		return nil
+	}
+	c := GetCodeC(name)
+	if c == nil {
+		return nil
+	}
+	return this_.switchCodeC(c)
+}
+
+// 切换发送使用的编码，和切换包一起放进发送缓存，不受发送缓存的限制
+func (this_ *Session) switchCodeC(c CodeC) error {
+	this_.codecMu.Lock()
+	defer this_.codecMu.Unlock()
+	this_.sendCond.L.Lock()
+	if this_.isClose() {
+		this_.sendCond.L.Unlock()
+		return ErrSessionClosed
+	}
+	if this_.CodeC.String() == c.String() {
+		this_.sendCond.L.Unlock()
+		return nil
+	}
+	this_.CodeC = c
+	this_.pushSendBuf(codecFrame(c.String()))
+	this_.sendCond.L.Unlock()
+	this_.sendCond.Signal()
+	return nil
+}
+
+// 对端之后的消息使用 name 编码，客户端收到服务器同意的编码之后自己也切换过去
+func (this_ *Session) onCodeC(rpcIndex uint32, name string) error {
+	if rpcIndex == codecRequest {
+		return this_.acceptCodeC(name)
+	}
+	c := GetCodeC(name)
+	if c == nil {
+		return fmt.Errorf("unknown codec %s", name)
+	}
+	this_.recvCodeC = c
+	if name == this_.wantCodeC {
+		return this_.switchCodeC(c)
+	}
+	return nil
+}
+
+// 用 c 编码好的 body 发送，编码之后 CodeC 已经切换的话重新编码
+func (this_ *Session) sendEncoded(c CodeC, msgID string, bodyData []byte, msg proto.Message) error {
+	this_.codecMu.RLock()
+	defer this_.codecMu.RUnlock()
+	if this_.CodeC.String() != c.String() {
+		var err error
+		bodyData, err = this_.CodeC.Encode(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return this_.sendRawByte(packetProtocolNormal, 0, msgID, bodyData)
+}
+
+// SetCodeCNegotiation 允许客户端在握手时选择编码，names 为空时允许所有注册了的编码，对之后接入的连接生效
+func (this_ *Acceptor) SetCodeCNegotiation(names ...string) {
+	this_.codecNames = names
+	this_.isCodeCNegotiation = true
+}
+
+// WithCodeC 握手时请求使用 name 编码，服务器不支持时使用默认的编码，name 必须已经用 RegisterCodeC 注册
+func WithCodeC(name string) Option {
+	return func(c *Connector) {
+		c.wantCodeC = name
+	}
+}
@@ -0,0 +1,129 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestJSONCodeC(t *testing.T) {
+	r := require.New(t)
+	c := &JSONCodeC{}
+	data, err := c.Encode(&basepb.Base_Error{ErrorCode: 7, ErrorMessage: "x", Fields: []string{"a"}})
+	r.NoError(err)
+	r.Contains(string(data), `"error_message"`)
+
+	out := &basepb.Base_Error{}
+	r.NoError(c.Decode([]byte(`{"error_code":"7","error_message":"x","fields":["a"],"unknown":1}`), out))
+	r.EqualValues(7, out.ErrorCode)
+	r.Equal([]string{"a"}, out.Fields)
+
+	r.Nil(findMessageType("basepb.unknown"))
+	r.NotNil(findMessageType(messageName(out)))
+}
+
+func TestMsgpackCodeC(t *testing.T) {
+	r := require.New(t)
+	c := GetCodeC("msgpack")
+	r.NotNil(c)
+	in := &basepb.Base_Error{ErrorCode: -7, ErrorMessage: "x", Fields: []string{"a", "b"}}
+	data, err := c.Encode(in)
+	r.NoError(err)
+	out := &basepb.Base_Error{Fields: []string{"old"}}
+	r.NoError(c.Decode(data, out))
+	r.True(proto.Equal(in, out))
+
+	// 动态语言的客户端可能用 json 名字和其它数字类型
+	data, err = msgpack.Marshal(map[string]interface{}{"errorCode": uint8(3), "unknown": []int{1}, "fields": []string{"c"}})
+	r.NoError(err)
+	out = &basepb.Base_Error{}
+	r.NoError(c.Decode(data, out))
+	r.EqualValues(3, out.ErrorCode)
+	r.Equal([]string{"c"}, out.Fields)
+}
+
+// 把 from 发送缓存中的包交给 to 处理
+func deliverFrames(r *require.Assertions, from, to *Session) []*Packet {
+	var packets []*Packet
+	for _, frame := range from.sendBuf {
+		p, l, err := to.recvOne(frame)
+		r.NoError(err)
+		r.Equal(len(frame), l)
+		if p != nil {
+			packets = append(packets, p)
+		}
+	}
+	from.sendBuf = nil
+	return packets
+}
+
+func TestCodeCNegotiation(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	client := newQueuedSession(r, log, SendQueueConfig{})
+	client.RequestCodeC("msgpack")
+	server := newQueuedSession(r, log, SendQueueConfig{})
+	server.SetCodeCNegotiation("msgpack", "protojson")
+	for _, s := range []*Session{client, server} {
+		s.baseCodeC = s.CodeC
+		s.recvCodeC = s.CodeC
+	}
+
+	// 握手包里没有编码名字，客户端收到服务器的握手之后才发送请求
+	r.NoError(client.Send(&basepb.Base_Error{ErrorCode: 1}))
+	r.NoError(client.sendHandshake())
+	r.NoError(server.Send(&basepb.Base_Error{ErrorCode: 2}))
+	packets := deliverFrames(r, client, server)
+	r.Len(packets, 1)
+	r.EqualValues(1, packets[0].Msg.(*basepb.Base_Error).ErrorCode)
+	r.Equal("proto", server.GetCodeC().String())
+	packets = deliverFrames(r, server, client)
+	r.Len(packets, 1)
+	r.EqualValues(2, packets[0].Msg.(*basepb.Base_Error).ErrorCode)
+	r.Len(client.sendBuf, 1)
+	r.EqualValues(codecRequest, binary.LittleEndian.Uint32(client.sendBuf[0][totalLenLen+msgIDLenLen+packetProtocolLen:]))
+
+	// 客户端请求之前发出的消息还是默认编码
+	r.NoError(client.Send(&basepb.Base_Error{ErrorCode: 3}))
+	packets = deliverFrames(r, client, server)
+	r.Len(packets, 1)
+	r.EqualValues(3, packets[0].Msg.(*basepb.Base_Error).ErrorCode)
+	r.Equal("msgpack", server.GetCodeC().String())
+	r.Equal("proto", server.decodeC().String())
+
+	r.NoError(server.Send(&basepb.Base_Error{ErrorCode: 4}))
+	r.NoError(client.Send(&basepb.Base_Error{ErrorCode: 5}))
+	packets = deliverFrames(r, server, client)
+	r.Len(packets, 1)
+	r.EqualValues(4, packets[0].Msg.(*basepb.Base_Error).ErrorCode)
+	r.Equal("msgpack", client.GetCodeC().String())
+
+	r.NoError(client.Send(&basepb.Base_Error{ErrorCode: 6}))
+	packets = deliverFrames(r, client, server)
+	r.Len(packets, 2)
+	r.EqualValues(5, packets[0].Msg.(*basepb.Base_Error).ErrorCode)
+	r.EqualValues(6, packets[1].Msg.(*basepb.Base_Error).ErrorCode)
+	r.Equal("msgpack", server.decodeC().String())
+
+	// 服务器不支持选择编码时客户端不发送请求
+	client = newQueuedSession(r, log, SendQueueConfig{})
+	client.RequestCodeC("msgpack")
+	server = newQueuedSession(r, log, SendQueueConfig{})
+	r.NoError(client.sendHandshake())
+	r.Len(client.sendBuf[0], headerLen+len(messagePingName))
+	deliverFrames(r, client, server)
+	deliverFrames(r, server, client)
+	r.Empty(client.sendBuf)
+	r.Equal("proto", client.GetCodeC().String())
+
+	// 不允许的编码继续使用默认编码
+	other := newQueuedSession(r, log, SendQueueConfig{})
+	other.SetCodeCNegotiation("protojson")
+	r.NoError(other.acceptCodeC("msgpack"))
+	r.Equal("proto", other.GetCodeC().String())
+}
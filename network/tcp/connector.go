@@ -1,14 +1,17 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
@@ -37,6 +40,7 @@ type Connector struct {
 	ci             DispatchInterface
 	dc             DoConcurrentInterface
 	cf             func(connector *Connector, err error)
+	sc             func(c *Connector, old int64, new int64)
 	connectState   int64
 	addr           string
 	ConnectTimeout time.Duration
@@ -45,7 +49,24 @@ type Connector struct {
 	codeC          CodeC
 	logger         *logger.Logger
 	ConnectorName  string
+	tlsConfig      *tls.Config
 	isDebugLog     bool
+	maxMessageLen  int
+	isCompress     bool
+	compressLen    int
+	rpcTimeout     time.Duration
+
+	heartbeat        HeartbeatConfig
+	policy           ReconnectPolicy
+	reconnectAttempt int
+	stateMu          sync.Mutex
+	stateCh          chan struct{}
+	resume           *resumeClient
+	sendQueue        SendQueueConfig
+	isNumericID      bool
+	wantCodeC        string
+	authToken        func() []byte
+	transport        Transport
 }
 
 type Option func(c *Connector)
@@ -56,9 +77,11 @@ func WithOpenDebugLog() Option {
 	}
 }
 
+// WithReconnect 断开或者连接失败后使用 DefaultReconnectPolicy 重连
 func WithReconnect() Option {
 	return func(c *Connector) {
 		c.isReconnect = true
+		c.policy = DefaultReconnectPolicy
 	}
 }
 
@@ -68,6 +91,36 @@ func WithJsonCodeC() Option {
 	}
 }
 
+// WithTLS 使用 tls 连接服务器，cfg.ServerName 为空时使用地址中的 host
+// 服务器开启了双向认证时，需要在 cfg.Certificates 中配置客户端证书
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Connector) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithMaxMessageLen 设置分片重组后单个消息的最大长度
+func WithMaxMessageLen(n int) Option {
+	return func(c *Connector) {
+		c.maxMessageLen = n
+	}
+}
+
+// WithCompress 开启消息压缩，body 超过 threshold 字节的消息会被压缩，服务器不支持时自动关闭
+func WithCompress(threshold int) Option {
+	return func(c *Connector) {
+		c.isCompress = true
+		c.compressLen = threshold
+	}
+}
+
+// WithRPCTimeout 设置 Request 等待返回的超时时间，默认2秒
+func WithRPCTimeout(timeout time.Duration) Option {
+	return func(c *Connector) {
+		c.rpcTimeout = timeout
+	}
+}
+
 func WithConnectTimeout(timeout time.Duration) Option {
 	return func(c *Connector) {
 		c.ConnectTimeout = timeout
@@ -82,6 +135,7 @@ func (this_ *Connector) OnSessionConnected(s *Session) {
 	if this_.isDebugLog {
 		this_.logger.Debug("connect success", zap.String("addr", this_.addr))
 	}
+	this_.reconnectAttempt = 0
 	if this_.ci != nil {
 		this_.ci.OnSessionConnected(s)
 	}
@@ -96,7 +150,7 @@ func (this_ *Connector) OnSessionDisConnected(s *Session, err error) {
 		this_.cf(this_, err)
 	} else {
 		if this_.isReconnect {
-			this_.Connect()
+			this_.reconnect()
 		}
 	}
 	if this_.ci != nil {
@@ -106,7 +160,7 @@ func (this_ *Connector) OnSessionDisConnected(s *Session, err error) {
 
 func (this_ *Connector) OnRPCRequest(s *Session, msg proto.Message) proto.Message {
 	if this_.isDebugLog {
-		this_.logger.Debug("OnRPCRequest", zap.String("addr", this_.addr), zap.Any("msg name", proto.MessageName(msg)), zap.Any("msg", msg))
+		this_.logger.Debug("OnRPCRequest", zap.String("addr", this_.addr), zap.Any("msg name", messageName(msg)), zap.Any("msg", msg))
 	}
 	if this_.ci != nil {
 		return this_.ci.OnRPCRequest(s, msg)
@@ -116,19 +170,26 @@ func (this_ *Connector) OnRPCRequest(s *Session, msg proto.Message) proto.Messag
 
 func (this_ *Connector) OnNormalMsg(s *Session, msg proto.Message) {
 	if this_.isDebugLog {
-		this_.logger.Debug("OnNormalMsg", zap.String("addr", this_.addr), zap.Any("msg name", proto.MessageName(msg)), zap.Any("msg", msg))
+		this_.logger.Debug("OnNormalMsg", zap.String("addr", this_.addr), zap.Any("msg name", messageName(msg)), zap.Any("msg", msg))
 	}
 	if this_.ci != nil {
 		this_.ci.OnNormalMsg(s, msg)
 	}
 }
 
+func (this_ *Connector) OnGoAway(s *Session) {
+	this_.logger.Info("server going away", zap.String("addr", this_.addr), zap.String("connector name", this_.ConnectorName))
+	if g, ok := this_.ci.(GoAwayInterface); ok && this_.ci != DispatchInterface(this_) {
+		g.OnGoAway(s)
+	}
+}
+
 func (this_ *Connector) Request(msg proto.Message, resp RPCResponse) (err error) {
 	if this_.sess != nil {
 		err = this_.sess.Request(msg, resp)
 		if err != nil {
 			this_.logger.Error("request msg error", zap.Error(err), zap.String("session name", this_.sess.SessionName),
-				zap.Any("msgID", proto.MessageName(msg)), zap.Any("msg", msg))
+				zap.Any("msgID", messageName(msg)), zap.Any("msg", msg))
 			this_.sess.Close(err)
 		}
 	} else {
@@ -144,6 +205,24 @@ func (this_ *Connector) Request(msg proto.Message, resp RPCResponse) (err error)
 	return
 }
 
+// RequestContext 见 Session.RequestContext，未连接时返回 ErrSessionClosed
+func (this_ *Connector) RequestContext(ctx context.Context, msg proto.Message) (proto.Message, error) {
+	sess := this_.sess
+	if sess == nil {
+		return nil, ErrSessionClosed
+	}
+	return sess.RequestContext(ctx, msg)
+}
+
+// SyncRequestContext 见 Session.SyncRequestContext，未连接时返回 ErrSessionClosed
+func (this_ *Connector) SyncRequestContext(ctx context.Context, msg proto.Message, out proto.Message) error {
+	sess := this_.sess
+	if sess == nil {
+		return ErrSessionClosed
+	}
+	return sess.SyncRequestContext(ctx, msg, out)
+}
+
 func (this_ *Connector) RequestNoError(msg proto.Message, resp RPCResponse) {
 	if this_.sess != nil {
 		this_.sess.RequestNoError(msg, resp)
@@ -161,10 +240,14 @@ func NewConnector(addr string, queue *eventqueue.EventQueue, logger *logger.Logg
 	c := &Connector{
 		addr:           addr,
 		ConnectTimeout: time.Second,
+		rpcTimeout:     time.Second * 2,
 		queue:          queue,
 		codeC:          &ProtoCodeC{},
 		logger:         logger,
 		ConnectorName:  connectorName,
+		stateCh:        make(chan struct{}),
+		heartbeat:      DefaultHeartbeat,
+		transport:      &TCPTransport{},
 	}
 	for _, v := range options {
 		v(c)
@@ -191,7 +274,7 @@ func (this_ *Connector) SetDoConcurrent(dc DoConcurrentInterface) {
 
 func (this_ *Connector) DoConcurrent(msgID string) bool {
 	if this_.dc != nil {
-		this_.dc.DoConcurrent(msgID)
+		return this_.dc.DoConcurrent(msgID)
 	}
 	return false
 }
@@ -208,11 +291,11 @@ func (this_ *Connector) Send(msg proto.Message) (err error) {
 	if this_.sess != nil {
 		err = this_.sess.Send(msg)
 		if err != nil {
-			this_.logger.Error("Send msg error", zap.Error(err), zap.String("msgID", proto.MessageName(msg)), zap.Any("data", msg))
+			this_.logger.Error("Send msg error", zap.Error(err), zap.String("msgID", messageName(msg)), zap.Any("data", msg))
 			this_.sess.Close(err)
 		}
 	} else {
-		this_.logger.Error("Send msg failed,session not connected", zap.String("msgID", proto.MessageName(msg)), zap.Any("data", msg))
+		this_.logger.Error("Send msg failed,session not connected", zap.String("msgID", messageName(msg)), zap.Any("data", msg))
 		err = errors.New("invalid session")
 	}
 	return
@@ -225,14 +308,17 @@ func (this_ *Connector) SendNoError(msg proto.Message) {
 //func (this_ *Connector) SendRaw(msg proto.Message) error {
 //	err := this_.sess.Send(msg)
 //	if err != nil {
-//		this_.logger.Error("sendRaw msg error", zap.Error(err), zap.String("msgID", proto.MessageName(msg)), zap.Any("data", msg))
+//		this_.logger.Error("sendRaw msg error", zap.Error(err), zap.String("msgID", messageName(msg)), zap.Any("data", msg))
 //	}
 //
 //	return err
 //}
 
 func (this_ *Connector) setConnectState(state int64) {
-	atomic.StoreInt64(&this_.connectState, state)
+	old := atomic.SwapInt64(&this_.connectState, state)
+	if old != state {
+		this_.notifyState(old, state)
+	}
 }
 
 func (this_ *Connector) getConnectState() int64 {
@@ -250,10 +336,26 @@ func (this_ *Connector) Close(err error) {
 }
 
 func (this_ *Connector) startSession(conn net.Conn) {
-	this_.setConnectState(ConnectStateConnected)
-	this_.sess = NewSession(conn, this_.queue, false, this_.codeC, this_.logger, time.Second*2, this_.isDebugLog)
+	this_.sess = NewSession(conn, this_.queue, false, this_.codeC, this_.logger, this_.rpcTimeout, this_.isDebugLog)
+	this_.sess.SetMaxMessageLen(this_.maxMessageLen)
+	this_.sess.SetReadIdleTimeout(this_.heartbeat.ReadIdleTimeout)
+	this_.sess.SetSendQueue(this_.sendQueue)
+	if this_.isCompress {
+		this_.sess.EnableCompress(this_.compressLen)
+	}
+	if this_.isNumericID {
+		this_.sess.EnableNumericID()
+	}
+	if this_.wantCodeC != "" {
+		if GetCodeC(this_.wantCodeC) != nil {
+			this_.sess.RequestCodeC(this_.wantCodeC)
+		} else {
+			this_.logger.Error("connector codec not registered", zap.String("codec", this_.wantCodeC), zap.String("connector name", this_.ConnectorName))
+		}
+	}
 	this_.sess.Dispatch = this_
 	this_.sess.DoConcurrent = this_
+	this_.sess.resumeClient = this_.resume
 	this_.sess.onClose = func(err error) {
 		this_.setConnectState(ConnectStateDisconnected)
 		this_.queue.Post(&ConnectorInfo{
@@ -261,11 +363,34 @@ func (this_ *Connector) startSession(conn net.Conn) {
 			Error:     &CloseError{err},
 		})
 	}
+	this_.setConnectState(ConnectStateConnected)
 	this_.queue.Post(&ConnectorInfo{
 		Connector: this_,
 		Error:     nil,
 	})
 	this_.sess.Start()
+	if this_.resume != nil {
+		// 恢复请求必须是第一个包
+		err := this_.sess.sendResume()
+		if err != nil {
+			this_.sess.Close(err)
+			return
+		}
+	}
+	if this_.sess.localCaps != 0 {
+		err := this_.sess.sendHandshake()
+		if err != nil {
+			this_.sess.Close(err)
+			return
+		}
+	}
+	if this_.authToken != nil {
+		err := this_.sess.sendAuthToken(this_.authToken())
+		if err != nil {
+			this_.sess.Close(err)
+			return
+		}
+	}
 	this_.startHeartbeat()
 }
 
@@ -274,24 +399,25 @@ func (this_ *Connector) startHeartbeat() {
 		return
 	}
 
-	if this_.sess != nil {
-		this_.sess.lastPongTime = time.Now().Unix()
-	}
-
 	this_.isStartHeart = true
 
-	err := this_.Send(&basepb.Base_Ping{})
+	err := this_.sess.sendPing()
 	if err != nil {
 		return
 	}
 
-	this_.queue.Tick(time.Second*10, func(i time.Time) bool {
+	cfg := this_.heartbeat
+	this_.queue.Tick(cfg.Interval, func(i time.Time) bool {
 		if this_.getConnectState() == ConnectStateConnected {
-			if i.Unix()-this_.sess.lastPongTime > 30 {
-				this_.Close(errors.New("timeout"))
+			if this_.sess.heartbeatTimeout(i, cfg.Timeout) {
+				this_.Close(ErrHeartbeatTimeout)
+				this_.isStartHeart = false
 				return false
 			}
-			err := this_.Send(&basepb.Base_Ping{})
+			err := this_.sess.sendPing()
+			if err == nil {
+				err = this_.sess.sendResumeAck()
+			}
 			if err != nil {
 				this_.Close(err)
 				this_.isStartHeart = false
@@ -304,6 +430,19 @@ func (this_ *Connector) startHeartbeat() {
 	})
 }
 
+func (this_ *Connector) dial() (net.Conn, error) {
+	conn, err := this_.transport.Dial(this_.addr, this_.ConnectTimeout)
+	if err != nil || this_.tlsConfig == nil {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, clientTLSConfig(this_.tlsConfig, this_.addr))
+	if err = tlsHandshake(tlsConn, this_.ConnectTimeout); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
 func (this_ *Connector) Connect() {
 	connectState := this_.getConnectState()
 	if !this_.queue.Stopped() && (connectState == ConnectStateInvalid || connectState == ConnectStateDisconnected) {
@@ -312,7 +451,7 @@ func (this_ *Connector) Connect() {
 			this_.Close(fmt.Errorf("%+v", e))
 			this_.setConnectState(ConnectStateInvalid)
 		}, func() {
-			conn, err := net.DialTimeout("tcp4", this_.addr, this_.ConnectTimeout)
+			conn, err := this_.dial()
 			if err != nil {
 				this_.setConnectState(ConnectStateInvalid)
 				this_.queue.Post(&ConnectorInfo{
@@ -0,0 +1,251 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+var ErrNoAvailableConnector = errors.New("no available connector")
+
+// Balancer 从 ConnectorGroup 的成员中选出一个发送消息，只能选择已连接的成员，没有可用的返回 nil
+// key 为 SendByKey、RequestByKey 传入的 key，其它方法传入空字符串
+type Balancer interface {
+	Pick(members []*Connector, key string) *Connector
+}
+
+func isAvailable(c *Connector) bool {
+	return c.State() == ConnectStateConnected && c.GetSession() != nil
+}
+
+// RoundRobinBalancer 轮询
+type RoundRobinBalancer struct {
+	index uint64
+}
+
+func (this_ *RoundRobinBalancer) Pick(members []*Connector, key string) *Connector {
+	n := len(members)
+	start := atomic.AddUint64(&this_.index, 1)
+	for i := 0; i < n; i++ {
+		c := members[(start+uint64(i))%uint64(n)]
+		if isAvailable(c) {
+			return c
+		}
+	}
+	return nil
+}
+
+// LeastPendingBalancer 选择未返回的 rpc 请求最少的
+type LeastPendingBalancer struct{}
+
+func (this_ *LeastPendingBalancer) Pick(members []*Connector, key string) *Connector {
+	var picked *Connector
+	least := 0
+	for _, c := range members {
+		if !isAvailable(c) {
+			continue
+		}
+		pending := c.GetSession().PendingRPC()
+		if picked == nil || pending < least {
+			picked = c
+			least = pending
+		}
+	}
+	return picked
+}
+
+// ConsistentHashBalancer 按 key 一致性哈希，相同的 key 总是发到同一个成员，成员不可用时顺延到哈希环上的下一个
+// Replicas 为每个成员的虚拟节点数，默认100
+type ConsistentHashBalancer struct {
+	Replicas int
+
+	mu sync.Mutex
+	// 上次建环时的成员，同一个地址换了新的 Connector 也要重新建
+	members []*Connector
+	hashes  []uint32
+	nodes   map[uint32]*Connector
+}
+
+func (this_ *ConsistentHashBalancer) Pick(members []*Connector, key string) *Connector {
+	if len(members) == 0 {
+		return nil
+	}
+	hashes, nodes := this_.ring(members)
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(hashes), func(i int) bool {
+		return hashes[i] >= h
+	})
+	for j := 0; j < len(hashes); j++ {
+		c := nodes[hashes[(i+j)%len(hashes)]]
+		if isAvailable(c) {
+			return c
+		}
+	}
+	return nil
+}
+
+func sameMembers(a, b []*Connector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (this_ *ConsistentHashBalancer) ring(members []*Connector) ([]uint32, map[uint32]*Connector) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.nodes != nil && sameMembers(members, this_.members) {
+		return this_.hashes, this_.nodes
+	}
+	replicas := this_.Replicas
+	if replicas <= 0 {
+		replicas = 100
+	}
+	hashes := make([]uint32, 0, len(members)*replicas)
+	nodes := make(map[uint32]*Connector, len(members)*replicas)
+	for _, c := range members {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + c.GetAddr()))
+			if _, ok := nodes[h]; ok {
+				continue
+			}
+			nodes[h] = c
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i] < hashes[j]
+	})
+	this_.members = append([]*Connector(nil), members...)
+	this_.hashes = hashes
+	this_.nodes = nodes
+	return hashes, nodes
+}
+
+// ConnectorGroup 管理多个连接到相同服务的 Connector，按照 Balancer 选择一个已连接的发送
+type ConnectorGroup struct {
+	mu       sync.RWMutex
+	members  []*Connector
+	balancer Balancer
+}
+
+// NewConnectorGroup balancer 为 nil 时使用轮询
+func NewConnectorGroup(balancer Balancer) *ConnectorGroup {
+	if balancer == nil {
+		balancer = &RoundRobinBalancer{}
+	}
+	return &ConnectorGroup{
+		balancer: balancer,
+	}
+}
+
+// Add 添加成员，地址已经存在时返回 false，需要调用者自己 Connect
+func (this_ *ConnectorGroup) Add(c *Connector) bool {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	for _, v := range this_.members {
+		if v.GetAddr() == c.GetAddr() {
+			return false
+		}
+	}
+	members := make([]*Connector, 0, len(this_.members)+1)
+	members = append(members, this_.members...)
+	this_.members = append(members, c)
+	return true
+}
+
+// Remove 移除地址为 addr 的成员并返回，需要调用者自己 Close
+func (this_ *ConnectorGroup) Remove(addr string) *Connector {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	for i, v := range this_.members {
+		if v.GetAddr() == addr {
+			members := make([]*Connector, 0, len(this_.members)-1)
+			members = append(members, this_.members[:i]...)
+			this_.members = append(members, this_.members[i+1:]...)
+			return v
+		}
+	}
+	return nil
+}
+
+// Members 返回所有成员，不要修改返回的 slice
+func (this_ *ConnectorGroup) Members() []*Connector {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	return this_.members
+}
+
+func (this_ *ConnectorGroup) Pick(key string) (*Connector, error) {
+	c := this_.balancer.Pick(this_.Members(), key)
+	if c == nil {
+		return nil, ErrNoAvailableConnector
+	}
+	return c, nil
+}
+
+func (this_ *ConnectorGroup) Send(msg proto.Message) error {
+	return this_.SendByKey("", msg)
+}
+
+func (this_ *ConnectorGroup) SendByKey(key string, msg proto.Message) error {
+	c, err := this_.Pick(key)
+	if err != nil {
+		return err
+	}
+	return c.Send(msg)
+}
+
+func (this_ *ConnectorGroup) Request(msg proto.Message, resp RPCResponse) error {
+	return this_.RequestByKey("", msg, resp)
+}
+
+// RequestByKey 没有可用的成员时 resp 会收到 basepb.Base_Error
+func (this_ *ConnectorGroup) RequestByKey(key string, msg proto.Message, resp RPCResponse) error {
+	c, err := this_.Pick(key)
+	if err != nil {
+		if resp != nil {
+			resp(&basepb.Base_Error{
+				ErrorCode:    1,
+				ErrorMessage: err.Error(),
+			})
+		}
+		return err
+	}
+	return c.Request(msg, resp)
+}
+
+// RequestContext 见 Session.RequestContext，会阻塞，别在queue或者逻辑线程里面用
+func (this_ *ConnectorGroup) RequestContext(ctx context.Context, key string, msg proto.Message) (proto.Message, error) {
+	c, err := this_.Pick(key)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestContext(ctx, msg)
+}
+
+// SyncRequest 见 SyncRequest，会阻塞，别在queue或者逻辑线程里面用
+func (this_ *ConnectorGroup) SyncRequest(msg proto.Message, out proto.Message) error {
+	return this_.SyncRequestContext(context.Background(), "", msg, out)
+}
+
+func (this_ *ConnectorGroup) SyncRequestContext(ctx context.Context, key string, msg proto.Message, out proto.Message) error {
+	c, err := this_.Pick(key)
+	if err != nil {
+		return err
+	}
+	return c.SyncRequestContext(ctx, msg, out)
+}
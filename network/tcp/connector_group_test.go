@@ -0,0 +1,101 @@
+package tcp
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+)
+
+// 不需要真正连接，直接设置成已连接的状态
+func newTestMember(log *logger.Logger, addr string) *Connector {
+	c := NewConnector(addr, nil, log, addr)
+	c.sess = &Session{}
+	c.connectState = ConnectStateConnected
+	return c
+}
+
+func TestBalancer(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	members := []*Connector{newTestMember(log, "a"), newTestMember(log, "b"), newTestMember(log, "c")}
+
+	rr := &RoundRobinBalancer{}
+	r.Equal(members[1], rr.Pick(members, ""))
+	r.Equal(members[2], rr.Pick(members, ""))
+	r.Equal(members[0], rr.Pick(members, ""))
+	members[1].connectState = ConnectStateDisconnected
+	r.Equal(members[2], rr.Pick(members, ""))
+	r.Equal(members[2], rr.Pick(members, ""))
+	r.Nil(rr.Pick(nil, ""))
+	members[1].connectState = ConnectStateConnected
+
+	lp := &LeastPendingBalancer{}
+	members[0].sess.pendingRPC = 2
+	members[1].sess.pendingRPC = 1
+	members[2].sess.pendingRPC = 3
+	r.Equal(members[1], lp.Pick(members, ""))
+	members[1].connectState = ConnectStateDisconnected
+	r.Equal(members[0], lp.Pick(members, ""))
+	members[1].connectState = ConnectStateConnected
+
+	ch := &ConsistentHashBalancer{}
+	picked := map[string]*Connector{}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		picked[key] = ch.Pick(members, key)
+		r.Equal(picked[key], ch.Pick(members, key))
+	}
+	// 成员不可用时只有它的 key 顺延到别的成员
+	members[1].connectState = ConnectStateDisconnected
+	for key, c := range picked {
+		if c != members[1] {
+			r.Equal(c, ch.Pick(members, key))
+		} else {
+			r.NotEqual(members[1], ch.Pick(members, key))
+		}
+	}
+
+	// 同一个地址换成新的 Connector
+	renewed := []*Connector{members[0], newTestMember(log, "b"), members[2]}
+	for key, c := range picked {
+		if c == members[1] {
+			r.Equal(renewed[1], ch.Pick(renewed, key))
+		}
+	}
+}
+
+func TestConnectorGroup(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	g := NewConnectorGroup(&ConsistentHashBalancer{})
+	_, err := g.Pick("")
+	r.Equal(ErrNoAvailableConnector, err)
+	var resp proto.Message
+	r.Equal(ErrNoAvailableConnector, g.RequestByKey("1", &basepb.Base_Ping{}, func(m proto.Message) {
+		resp = m
+	}))
+	r.IsType(&basepb.Base_Error{}, resp)
+
+	a, b := newTestMember(log, "a"), newTestMember(log, "b")
+	r.True(g.Add(a))
+	r.True(g.Add(b))
+	r.False(g.Add(newTestMember(log, "a")))
+	r.Len(g.Members(), 2)
+	old, err := g.Pick("key")
+	r.NoError(err)
+
+	// 移除之后用同样的地址加回来，选中的是新的 Connector
+	r.Equal(old, g.Remove(old.GetAddr()))
+	r.Nil(g.Remove(old.GetAddr()))
+	r.Len(g.Members(), 1)
+	renewed := newTestMember(log, old.GetAddr())
+	r.True(g.Add(renewed))
+	c, err := g.Pick("key")
+	r.NoError(err)
+	r.Equal(renewed, c)
+}
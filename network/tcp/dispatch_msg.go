@@ -2,10 +2,9 @@ package tcp
 
 import (
 	"reflect"
-	"time"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
@@ -13,8 +12,8 @@ import (
 	workpool "github.com/njmdk/common/work_pool"
 )
 
-var messagePingName = proto.MessageName(&basepb.Base_Ping{})
-var messagePongName = proto.MessageName(&basepb.Base_Pong{})
+var messagePingName = messageName(&basepb.Base_Ping{})
+var messagePongName = messageName(&basepb.Base_Pong{})
 
 type DispatchInterface interface {
 	OnSessionConnected(*Session)
@@ -23,6 +22,11 @@ type DispatchInterface interface {
 	OnNormalMsg(*Session, proto.Message)
 }
 
+// GoAwayInterface 由 Session.Dispatch 实现，对端服务器准备关闭时调用，之后不要再往这个连接发送新的请求
+type GoAwayInterface interface {
+	OnGoAway(*Session)
+}
+
 type ConnectFailedInterface interface {
 	OnConnectFailed(connector *Connector, err error)
 }
@@ -56,7 +60,7 @@ func (this_ *DefaultDoConcurrent) RegisterConcurrentMsg(message proto.Message) {
 	if this_.m == nil {
 		this_.m = map[string]struct{}{}
 	}
-	msgName := proto.MessageName(message)
+	msgName := messageName(message)
 	if msgName != "" {
 		this_.m[msgName] = struct{}{}
 	}
@@ -79,7 +83,7 @@ func (this_ *AllDoConcurrent) RegisterNotConcurrentMsg(message proto.Message) {
 	if this_.notConcurrent == nil {
 		this_.notConcurrent = map[string]struct{}{}
 	}
-	msgName := proto.MessageName(message)
+	msgName := messageName(message)
 	if msgName != "" {
 		this_.notConcurrent[msgName] = struct{}{}
 	}
@@ -136,6 +140,9 @@ func DispatchMsg(f func(event interface{}), workPool *workpool.WorkPool) func(ev
 					e.Connector.OnSessionConnected(e.GetSession())
 				}
 			} else {
+				if e.Error != nil {
+					session.closeStreams(e.Error)
+				}
 				if session.Dispatch != nil {
 					if e.Error != nil {
 						session.Dispatch.OnSessionDisConnected(e.GetSession(), e.Error)
@@ -145,6 +152,10 @@ func DispatchMsg(f func(event interface{}), workPool *workpool.WorkPool) func(ev
 				} else {
 					f(event)
 				}
+				// OnSessionDisConnected 中还可以读取
+				if e.Error != nil {
+					session.ClearAttributes()
+				}
 			}
 
 		case *AcceptSession:
@@ -153,19 +164,45 @@ func DispatchMsg(f func(event interface{}), workPool *workpool.WorkPool) func(ev
 			} else {
 				f(event)
 			}
+		case *SessionResumed:
+			if r, ok := e.GetSession().Dispatch.(ResumeInterface); ok {
+				r.OnSessionResumed(e.GetSession())
+			} else {
+				f(event)
+			}
 		case *SessionClosed:
+			e.GetSession().closeStreams(e.Err)
+			if r, ok := e.GetSession().Dispatch.(ResumeInterface); ok && e.Err == ErrSessionExpired {
+				r.OnSessionExpired(e.GetSession())
+			}
 			if e.GetSession().Dispatch != nil {
 				e.GetSession().Dispatch.OnSessionDisConnected(e.GetSession(), e.Err)
 			} else {
 				f(event)
 			}
+			// OnSessionDisConnected 中还可以读取
+			e.GetSession().ClearAttributes()
 		case *Packet:
+			if e.Sess != nil {
+				// 认证阶段的包交给 Authenticator，通过之后马上通知上层，保证在后续的消息之前
+				if consumed, passed := e.Sess.authPacket(e); consumed {
+					if passed {
+						if e.Sess.Dispatch != nil {
+							e.Sess.Dispatch.OnSessionConnected(e.Sess)
+						} else {
+							f(&AcceptSession{e.Sess})
+						}
+					}
+					return
+				}
+			}
 			switch e.Protocol {
 			case packetProtocolRPCRequest:
 				sess := e.GetSession()
 				if sess != nil && sess.Dispatch != nil {
 					if workPool != nil && sess.DoConcurrent.DoConcurrent(e.MsgID) {
 						workPool.Post(func() {
+							defer sess.rpcDone()
 							resp := sess.Dispatch.OnRPCRequest(sess, e.Msg)
 							err := sess.sendResponse(e.RPCIndex, resp)
 							if err != nil {
@@ -175,11 +212,15 @@ func DispatchMsg(f func(event interface{}), workPool *workpool.WorkPool) func(ev
 					} else {
 						resp := sess.Dispatch.OnRPCRequest(sess, e.Msg)
 						err := sess.sendResponse(e.RPCIndex, resp)
+						sess.rpcDone()
 						if err != nil {
 							sess.Close(err)
 						}
 					}
 				} else {
+					if sess != nil {
+						sess.rpcDone()
+					}
 					f(event)
 				}
 			case packetProtocolRPCResponse:
@@ -189,6 +230,24 @@ func DispatchMsg(f func(event interface{}), workPool *workpool.WorkPool) func(ev
 					f(event)
 				}
 
+			case packetProtocolStreamOpen, packetProtocolStreamData, packetProtocolStreamClose, packetProtocolStreamCancel, packetProtocolStreamCredit:
+				if e.Sess != nil {
+					e.Sess.onStreamPacket(e)
+				} else {
+					f(event)
+				}
+			case packetProtocolResumeResult:
+				if e.Sess != nil {
+					e.Sess.onResumeResult(e.RPCIndex == 1)
+				} else {
+					f(event)
+				}
+			case packetProtocolGoAway:
+				if e.Sess != nil {
+					e.Sess.onGoAway()
+				} else {
+					f(event)
+				}
 			case packetProtocolNormal:
 				sess := e.GetSession()
 				if sess != nil && sess.Dispatch != nil {
@@ -196,7 +255,7 @@ func DispatchMsg(f func(event interface{}), workPool *workpool.WorkPool) func(ev
 						sess.logger.Debug("session recv msg", zap.String("sessionName", sess.SessionName), zap.String("sessionID", sess.SessionID), zap.String("msgID", e.MsgID), zap.Any("msg", e.Msg))
 					}
 					if e.MsgID == messagePingName || e.MsgID == messagePongName {
-						sess.lastPongTime = time.Now().Unix()
+						sess.onPingPong(e.MsgID == messagePongName)
 						if e.MsgID == messagePingName {
 							sess.SendNoError(&basepb.Base_Pong{})
 						}
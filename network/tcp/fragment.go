@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 超过 maxPacketLen 的消息会被拆成多个分片包连续发送，对端收齐之后重组成一个完整的消息再分发
+// 分片包 = header(packetProtocolFragment, rpcIndex) + msgID + 原始 packetProtocol + 是否最后一片 + 分片数据
+// 同一个消息的所有分片在 sendBuf 中是一个整体，不会和其它消息交叉
+
+type fragmentReader struct {
+	started  bool
+	msgID    string
+	pp       packetProtocol
+	rpcIndex uint32
+	buf      []byte
+}
+
+func (this_ *fragmentReader) reset() {
+	this_.started = false
+	this_.msgID = ""
+	this_.buf = nil
+}
+
+// SetMaxMessageLen 设置分片重组后单个消息的最大长度，默认16M，需要在 Start 之前调用
+func (this_ *Session) SetMaxMessageLen(n int) {
+	if n > 0 {
+		this_.maxMessageLen = n
+	}
+}
+
+func (this_ *Session) GetMaxMessageLen() int {
+	return this_.maxMessageLen
+}
+
+func (this_ *Session) fragment(pp packetProtocol, rpcIndex uint32, msgID string, bodyData []byte) ([]byte, error) {
+	if len(bodyData) > this_.maxMessageLen {
+		return nil, fmt.Errorf("package too large %d,max message len %d", len(bodyData), this_.maxMessageLen)
+	}
+	chunkLen := maxPacketLen - 1 - headerLen - len(msgID) - fragmentHeaderLen
+	if chunkLen <= 0 {
+		return nil, errors.New("msgID too long")
+	}
+	count := (len(bodyData) + chunkLen - 1) / chunkLen
+	data := make([]byte, 0, len(bodyData)+count*(headerLen+len(msgID)+fragmentHeaderLen))
+	for len(bodyData) > 0 {
+		n := chunkLen
+		var last uint8
+		if n >= len(bodyData) {
+			n = len(bodyData)
+			last = 1
+		}
+		data = appendFrame(data, packetProtocolFragment, rpcIndex, msgID, []byte{uint8(pp), last}, bodyData[:n])
+		bodyData = bodyData[n:]
+	}
+	return data, nil
+}
+
+// 收到一个分片，收齐之后返回原始的 packetProtocol 和完整的 body
+func (this_ *Session) onFragment(msgID string, rpcIndex uint32, body []byte) (packetProtocol, []byte, bool, error) {
+	if len(body) < fragmentHeaderLen {
+		return 0, nil, false, fmt.Errorf("invalid fragment,bodyLen too small %d", len(body))
+	}
+	pp := packetProtocol(body[0])
+	last := body[1] == 1
+	chunk := body[fragmentHeaderLen:]
+
+	f := &this_.fragReader
+	if !f.started {
+		f.started = true
+		f.msgID = msgID
+		f.pp = pp
+		f.rpcIndex = rpcIndex
+	} else if f.msgID != msgID || f.pp != pp || f.rpcIndex != rpcIndex {
+		return 0, nil, false, fmt.Errorf("invalid fragment,expected msgID %s got %s", f.msgID, msgID)
+	}
+
+	if len(f.buf)+len(chunk) > this_.maxMessageLen {
+		return 0, nil, false, fmt.Errorf("msgLen too big %d,max message len %d", len(f.buf)+len(chunk), this_.maxMessageLen)
+	}
+	f.buf = append(f.buf, chunk...)
+	if !last {
+		return 0, nil, false, nil
+	}
+
+	data := f.buf
+	f.reset()
+	return pp, data, true, nil
+}
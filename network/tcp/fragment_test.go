@@ -0,0 +1,43 @@
+package tcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestSessionFragment(t *testing.T) {
+	r := require.New(t)
+	s := &Session{CodeC: &ProtoCodeC{}, maxMessageLen: defaultMaxMessageLen}
+	msg := &basepb.Base_Error{ErrorCode: 1, ErrorMessage: strings.Repeat("0123456789", maxPacketLen/4)}
+	body, err := s.Encode(msg)
+	r.NoError(err)
+	data, err := s.fragment(packetProtocolRPCResponse, 7, messageName(msg), body)
+	r.NoError(err)
+
+	count := 0
+	var got *Packet
+	for len(data) > 0 {
+		p, l, err := s.recvOne(data)
+		r.NoError(err)
+		r.True(l > 0 && l < maxPacketLen)
+		count++
+		data = data[l:]
+		if p != nil {
+			got = p
+		}
+	}
+	r.Equal(3, count)
+	r.NotNil(got)
+	r.Equal(packetProtocolRPCResponse, got.Protocol)
+	r.Equal(uint32(7), got.RPCIndex)
+	r.True(proto.Equal(msg, got.Msg))
+
+	s.SetMaxMessageLen(maxPacketLen)
+	_, err = s.fragment(packetProtocolNormal, 0, messageName(msg), body)
+	r.Error(err)
+}
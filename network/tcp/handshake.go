@@ -0,0 +1,106 @@
+package tcp
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+)
+
+// 连接建立后双方交换各自支持的能力，两端都支持的能力才会启用，这样老版本的对端可以继续正常通信
+// 握手包 = header(packetProtocolHandshake, rpcIndex=能力位) + msgID(Ping)，body 必须为空
+// 老版本不认识这个 packetProtocol，会把 body 按 Ping 解码之后忽略，body 不为空可能解码失败断开连接
+// 能力需要的其它数据在收到对端的握手包、确认对端支持之后再单独发送：
+// 注册表 hash = header(packetProtocolRegistryHash, rpcIndex=hash) + msgID(Ping)，双方都开启数字消息ID时发送
+// 选择编码的请求见 codec_switch.go
+
+const (
+	// 支持压缩 body
+	capCompress uint32 = 1 << 0
+	// 支持数字消息ID
+	capNumericID uint32 = 1 << 1
+	// 支持握手时选择编码，见 codec_switch.go
+	capCodeC uint32 = 1 << 2
+)
+
+// 默认超过 1K 的消息才压缩
+const defaultCompressThreshold = 1024
+
+// EnableCompress 开启消息压缩，body 超过 threshold 字节的消息会用 snappy 压缩之后发送
+// 只有对端也开启了压缩才会生效，需要在 Start 之前调用
+func (this_ *Session) EnableCompress(threshold int) {
+	if threshold <= 0 {
+		threshold = defaultCompressThreshold
+	}
+	this_.compressThreshold = threshold
+	this_.localCaps |= capCompress
+}
+
+// CompressEnabled 双方是否都支持压缩
+func (this_ *Session) CompressEnabled() bool {
+	return this_.localCaps&this_.getPeerCaps()&capCompress != 0
+}
+
+func (this_ *Session) getPeerCaps() uint32 {
+	return atomic.LoadUint32(&this_.peerCaps)
+}
+
+// 每个连接只发送一次
+func (this_ *Session) sendHandshake() error {
+	if !atomic.CompareAndSwapUint32(&this_.handshakeSent, 0, 1) {
+		return nil
+	}
+	return this_.sendRawByte(packetProtocolHandshake, this_.localCaps, messagePingName, nil)
+}
+
+// 收到对端的握手包，如果自己还没发过，回复自己的能力，然后发送对端也支持的能力需要的数据
+func (this_ *Session) onHandshake(caps uint32) error {
+	atomic.StoreUint32(&this_.peerCaps, caps)
+	err := this_.sendHandshake()
+	if err != nil {
+		return err
+	}
+	if this_.localCaps&caps&capNumericID != 0 {
+		if err = this_.sendRawByte(packetProtocolRegistryHash, registryHash(), messagePingName, nil); err != nil {
+			return err
+		}
+	}
+	if this_.localCaps&caps&capCodeC != 0 && this_.wantCodeC != "" {
+		return this_.sendRawByte(packetProtocolCodeC, codecRequest, messagePingName, []byte(this_.wantCodeC))
+	}
+	return nil
+}
+
+// 对端的注册表 hash，和自己的一样时才发送数字消息ID
+func (this_ *Session) onRegistryHash(hash uint32) {
+	atomic.StoreUint32(&this_.peerRegistryHash, hash)
+}
+
+func (this_ *Session) compress(pp packetProtocol, bodyData []byte) (packetProtocol, []byte) {
+	if !pp.compressible() || len(bodyData) < this_.compressThreshold || !this_.CompressEnabled() {
+		return pp, bodyData
+	}
+	data := snappy.Encode(nil, bodyData)
+	if len(data) >= len(bodyData) {
+		return pp, bodyData
+	}
+	return pp | packetProtocolCompressFlag, data
+}
+
+func (this_ *Session) decompress(pp packetProtocol, body []byte) (packetProtocol, []byte, error) {
+	if pp&packetProtocolCompressFlag == 0 {
+		return pp, body, nil
+	}
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return pp, nil, err
+	}
+	if n > this_.maxMessageLen {
+		return pp, nil, fmt.Errorf("msgLen too big %d,max message len %d", n, this_.maxMessageLen)
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return pp, nil, err
+	}
+	return pp &^ packetProtocolCompressFlag, data, nil
+}
@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestSessionCompress(t *testing.T) {
+	r := require.New(t)
+	s := &Session{maxMessageLen: defaultMaxMessageLen}
+	s.EnableCompress(0)
+	body := []byte(strings.Repeat("abcdefgh", 1024))
+
+	pp, data := s.compress(packetProtocolNormal, body)
+	r.Equal(packetProtocolNormal, pp, "peer not support compress")
+	r.Equal(body, data)
+
+	s.peerCaps = capCompress
+	pp, data = s.compress(packetProtocolRPCRequest, body)
+	r.Equal(packetProtocolRPCRequest|packetProtocolCompressFlag, pp)
+	r.True(len(data) < len(body))
+
+	pp, data, err := s.decompress(pp, data)
+	r.NoError(err)
+	r.Equal(packetProtocolRPCRequest, pp)
+	r.Equal(body, data)
+
+	pp, data = s.compress(packetProtocolHandshake, body)
+	r.Equal(packetProtocolHandshake, pp)
+	r.Equal(body, data)
+}
+
+func TestHandshake(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	local := newQueuedSession(r, log, SendQueueConfig{})
+	local.EnableCompress(0)
+	local.EnableNumericID()
+	r.NoError(local.sendHandshake())
+	r.Len(local.sendBuf, 1)
+	frame := local.sendBuf[0]
+	// 老版本会按 Ping 解码，body 必须为空
+	r.Len(frame, headerLen+len(messagePingName))
+	r.NoError((&ProtoCodeC{}).Decode(frame[headerLen+len(messagePingName):], &basepb.Base_Ping{}))
+
+	// 没有开启任何能力的对端只回复握手包
+	plain := newQueuedSession(r, log, SendQueueConfig{})
+	p, l, err := plain.recvOne(frame)
+	r.NoError(err)
+	r.Nil(p)
+	r.Equal(len(frame), l)
+	r.Len(plain.sendBuf, 1)
+	r.Len(plain.sendBuf[0], headerLen+len(messagePingName))
+	_, _, err = local.recvOne(plain.sendBuf[0])
+	r.NoError(err)
+	r.Len(local.sendBuf, 1)
+	r.False(local.CompressEnabled())
+	r.False(local.NumericIDEnabled())
+
+	// 双方都开启数字消息ID时，确认对端支持之后才单独发送注册表 hash
+	local = newQueuedSession(r, log, SendQueueConfig{})
+	local.EnableNumericID()
+	r.NoError(local.sendHandshake())
+	peer := newQueuedSession(r, log, SendQueueConfig{})
+	peer.EnableNumericID()
+	_, _, err = peer.recvOne(local.sendBuf[0])
+	r.NoError(err)
+	r.Len(peer.sendBuf, 2)
+	r.Equal(packetProtocolRegistryHash, packetProtocol(peer.sendBuf[1][totalLenLen+msgIDLenLen]))
+	for _, b := range peer.sendBuf {
+		_, _, err = local.recvOne(b)
+		r.NoError(err)
+	}
+	r.True(local.NumericIDEnabled())
+	r.Len(local.sendBuf, 2)
+	_, _, err = peer.recvOne(local.sendBuf[1])
+	r.NoError(err)
+	r.True(peer.NumericIDEnabled())
+}
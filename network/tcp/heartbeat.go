@@ -0,0 +1,120 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+var (
+	ErrHeartbeatTimeout = errors.New("ping pong timeout")
+	ErrReadIdleTimeout  = errors.New("read idle timeout")
+)
+
+// HeartbeatConfig 心跳配置
+type HeartbeatConfig struct {
+	// 每隔多久发送一次 Ping
+	Interval time.Duration
+	// 超过多久没有收到对端的 Ping 或者 Pong 认为对端已经断开
+	Timeout time.Duration
+	// 超过多久没有收到任何数据就关闭连接，为0时不检查
+	ReadIdleTimeout time.Duration
+}
+
+var DefaultHeartbeat = HeartbeatConfig{
+	Interval: time.Second * 10,
+	Timeout:  time.Second * 30,
+}
+
+func (this_ HeartbeatConfig) withDefault() HeartbeatConfig {
+	if this_.Interval <= 0 {
+		this_.Interval = DefaultHeartbeat.Interval
+	}
+	if this_.Timeout <= 0 {
+		this_.Timeout = this_.Interval * 3
+	}
+	return this_
+}
+
+// SetReadIdleTimeout 超过 d 没有收到任何数据就关闭连接，需要在 Start 之前调用
+func (this_ *Session) SetReadIdleTimeout(d time.Duration) {
+	this_.readIdleTimeout = d
+}
+
+// RTT 最近一次 Ping/Pong 测得的往返延迟，还没测到时返回0
+func (this_ *Session) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this_.rtt))
+}
+
+// LastPongTime 最近一次收到对端 Ping 或者 Pong 的时间
+func (this_ *Session) LastPongTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&this_.lastPongTime))
+}
+
+func (this_ *Session) sendPing() error {
+	atomic.CompareAndSwapInt64(&this_.pingSentAt, 0, time.Now().UnixNano())
+	return this_.Send(&basepb.Base_Ping{})
+}
+
+func (this_ *Session) onPingPong(isPong bool) {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&this_.lastPongTime, now)
+	if isPong {
+		if sent := atomic.SwapInt64(&this_.pingSentAt, 0); sent != 0 {
+			atomic.StoreInt64(&this_.rtt, now-sent)
+		}
+	}
+}
+
+func (this_ *Session) heartbeatTimeout(now time.Time, timeout time.Duration) bool {
+	return now.Sub(this_.LastPongTime()) > timeout
+}
+
+// 设置读超时，超时之后 Read 返回错误
+func (this_ *Session) setReadDeadline(conn net.Conn) {
+	if this_.readIdleTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(this_.readIdleTimeout))
+	}
+}
+
+func (this_ *Session) readError(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && this_.readIdleTimeout > 0 {
+		return ErrReadIdleTimeout
+	}
+	return err
+}
+
+// SetHeartbeat 设置心跳，对之后接入的连接生效
+// 服务器每隔 Interval 主动发送一次 Ping，超过 Timeout 没有收到客户端的 Ping 或者 Pong 就关闭连接
+func (this_ *Acceptor) SetHeartbeat(cfg HeartbeatConfig) {
+	this_.heartbeat = cfg.withDefault()
+}
+
+func (this_ *Acceptor) startHeartbeat(sess *Session) {
+	cfg := this_.heartbeat
+	gen := atomic.LoadUint32(&sess.gen)
+	this_.queue.Tick(cfg.Interval, func(i time.Time) bool {
+		if sess.loopDone(gen) {
+			return false
+		}
+		if sess.heartbeatTimeout(i, cfg.Timeout) {
+			sess.Close(ErrHeartbeatTimeout)
+			return false
+		}
+		if err := sess.sendPing(); err != nil {
+			sess.Close(err)
+			return false
+		}
+		return true
+	})
+}
+
+// WithHeartbeat 设置心跳，默认每10秒发送一次 Ping，30秒没有收到 Pong 断开
+func WithHeartbeat(cfg HeartbeatConfig) Option {
+	return func(c *Connector) {
+		c.heartbeat = cfg.withDefault()
+	}
+}
@@ -0,0 +1,60 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestHeartbeat(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := newRunningQueue(log)
+	acceptor := &Acceptor{queue: queue, logger: log}
+	acceptor.SetHeartbeat(HeartbeatConfig{Interval: time.Millisecond * 20, Timeout: time.Millisecond * 150})
+
+	sess, peer := newTestSessionPair(r, log, queue)
+	defer peer.Close()
+	closed := make(chan error, 1)
+	sess.onClose = func(err error) {
+		closed <- err
+	}
+	sess.Start()
+	acceptor.startHeartbeat(sess)
+
+	// 对端回复 Pong 之后可以拿到 rtt
+	p := readTestPacket(r, peer)
+	r.Equal(messagePingName, p.MsgID)
+	writeTestPacket(r, peer, packetProtocolNormal, 0, &basepb.Base_Pong{})
+	r.Eventually(func() bool { return sess.RTT() > 0 }, time.Second, time.Millisecond*5)
+	r.WithinDuration(time.Now(), sess.LastPongTime(), time.Second)
+
+	// 之后不再回复，超时关闭
+	select {
+	case err := <-closed:
+		r.Equal(ErrHeartbeatTimeout, err)
+	case <-time.After(time.Second * 3):
+		r.FailNow("heartbeat not timeout")
+	}
+
+	// 一直没有收到数据
+	sess, peer = newTestSessionPair(r, log, queue)
+	defer peer.Close()
+	sess.onClose = func(err error) {
+		closed <- err
+	}
+	sess.SetReadIdleTimeout(time.Millisecond * 50)
+	sess.Start()
+	select {
+	case err := <-closed:
+		r.Equal(ErrReadIdleTimeout, err)
+	case <-time.After(time.Second * 3):
+		r.FailNow("read idle not timeout")
+	}
+
+	cfg := HeartbeatConfig{Interval: time.Second}.withDefault()
+	r.Equal(time.Second*3, cfg.Timeout)
+}
@@ -0,0 +1,92 @@
+package kcp
+
+import (
+	"net"
+	"time"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// Transport 基于 UDP 的可靠传输，延迟比 tcp 低，适用于实时性要求高的游戏消息
+// 使用 stream 模式，tcp.Session 的包协议、rpc 和分发不需要任何改动
+//
+//	acceptor, err := tcp.NewAcceptorWithTransport(":9999", kcp.NewTransport(), queue, &tcp.ProtoCodeC{}, log, false)
+//	connector := tcp.NewConnector("127.0.0.1:9999", queue, log, "game", tcp.WithTransport(kcp.NewTransport()))
+type Transport struct {
+	// 加密方式，nil 表示不加密，两端必须一致
+	Block kcp.BlockCrypt
+	// FEC 前向纠错，都为0时不开启，两端必须一致
+	DataShards   int
+	ParityShards int
+	// 见 kcp.UDPSession.SetNoDelay
+	NoDelay      int
+	Interval     int
+	Resend       int
+	NoCongestion int
+	// 发送和接收窗口大小，单位是包
+	SendWindow int
+	RecvWindow int
+	Mtu        int
+}
+
+// NewTransport 快速模式的默认配置
+func NewTransport() *Transport {
+	return &Transport{
+		NoDelay:      1,
+		Interval:     10,
+		Resend:       2,
+		NoCongestion: 1,
+		SendWindow:   128,
+		RecvWindow:   512,
+		Mtu:          1400,
+	}
+}
+
+func (this_ *Transport) String() string {
+	return "kcp"
+}
+
+func (this_ *Transport) Listen(addr string) (net.Listener, error) {
+	l, err := kcp.ListenWithOptions(addr, this_.Block, this_.DataShards, this_.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{Listener: l, t: this_}, nil
+}
+
+// udp 没有建立连接的过程，timeout 不起作用，连不上的时候由心跳超时断开
+func (this_ *Transport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	sess, err := kcp.DialWithOptions(addr, this_.Block, this_.DataShards, this_.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	this_.setup(sess)
+	return sess, nil
+}
+
+func (this_ *Transport) setup(sess *kcp.UDPSession) {
+	sess.SetStreamMode(true)
+	sess.SetWriteDelay(false)
+	sess.SetACKNoDelay(true)
+	sess.SetNoDelay(this_.NoDelay, this_.Interval, this_.Resend, this_.NoCongestion)
+	if this_.SendWindow > 0 && this_.RecvWindow > 0 {
+		sess.SetWindowSize(this_.SendWindow, this_.RecvWindow)
+	}
+	if this_.Mtu > 0 {
+		sess.SetMtu(this_.Mtu)
+	}
+}
+
+type listener struct {
+	*kcp.Listener
+	t *Transport
+}
+
+func (this_ *listener) Accept() (net.Conn, error) {
+	sess, err := this_.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	this_.t.setup(sess)
+	return sess, nil
+}
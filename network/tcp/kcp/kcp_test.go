@@ -0,0 +1,63 @@
+package kcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+	"github.com/njmdk/common/network/tcp"
+)
+
+type successDispatch struct{}
+
+func (this_ *successDispatch) OnSessionConnected(*tcp.Session)           {}
+func (this_ *successDispatch) OnSessionDisConnected(*tcp.Session, error) {}
+func (this_ *successDispatch) OnNormalMsg(*tcp.Session, proto.Message)   {}
+func (this_ *successDispatch) OnRPCRequest(*tcp.Session, proto.Message) proto.Message {
+	return &basepb.Base_Success{}
+}
+
+func TestTransport(t *testing.T) {
+	r := require.New(t)
+	log, err := logger.New("test", t.TempDir(), zapcore.DebugLevel, true)
+	r.NoError(err)
+	queue := eventqueue.NewEventQueue(100, log)
+	queue.Run(nil, tcp.DispatchMsg(func(interface{}) {}, nil))
+
+	tr := NewTransport()
+	ln, err := tr.Listen("127.0.0.1:0")
+	r.NoError(err)
+	defer ln.Close()
+	c, err := tr.Dial(ln.Addr().String(), time.Second)
+	r.NoError(err)
+	client := tcp.NewSession(c, queue, true, &tcp.ProtoCodeC{}, log, time.Second*2, false)
+	client.Start()
+	defer client.Close(tcp.ErrSessionClosed)
+
+	// udp 没有建立连接的过程，服务器收到第一个包之后才能 Accept
+	resp := make(chan error, 1)
+	go func() {
+		_, err := client.RequestContext(context.Background(), &basepb.Base_Ping{})
+		resp <- err
+	}()
+	s, err := ln.Accept()
+	r.NoError(err)
+	server := tcp.NewSession(s, queue, true, &tcp.ProtoCodeC{}, log, time.Second*2, false)
+	server.Dispatch = &successDispatch{}
+	server.Start()
+	defer server.Close(tcp.ErrSessionClosed)
+
+	select {
+	case err = <-resp:
+		r.NoError(err)
+	case <-time.After(time.Second * 3):
+		r.FailNow("rpc over kcp not returned")
+	}
+}
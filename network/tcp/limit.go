@@ -0,0 +1,188 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AcceptLimit Acceptor 的连接限制，为0的项不限制
+type AcceptLimit struct {
+	// 最大连接数
+	MaxSessions int
+	// 单个ip的最大连接数
+	MaxSessionsPerIP int
+	// 每秒最多接收多少个新连接，AcceptBurst 为允许的突发数量
+	AcceptRate  float64
+	AcceptBurst int
+	// 单个连接每秒最多收多少个消息，超过之后关闭连接，MsgBurst 为允许的突发数量
+	MsgRate  float64
+	MsgBurst int
+}
+
+type LimitReason string
+
+const (
+	LimitReasonMaxSessions      LimitReason = "max sessions"
+	LimitReasonMaxSessionsPerIP LimitReason = "max sessions per ip"
+	LimitReasonAcceptRate       LimitReason = "accept rate"
+	LimitReasonMsgRate          LimitReason = "message rate"
+)
+
+// LimitError 连接被拒绝或者因为超过限制被关闭时，OnSessionDisConnected 收到的错误
+// 被拒绝的连接不会调用 OnSessionConnected
+type LimitError struct {
+	Reason LimitReason
+}
+
+func (this_ *LimitError) Error() string {
+	return "limit exceeded:" + string(this_.Reason)
+}
+
+func IsLimitError(err error) bool {
+	_, ok := err.(*LimitError)
+	return ok
+}
+
+// 令牌桶
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (this_ *tokenBucket) allow() bool {
+	if this_ == nil {
+		return true
+	}
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	now := time.Now()
+	this_.tokens += now.Sub(this_.last).Seconds() * this_.rate
+	if this_.tokens > this_.burst {
+		this_.tokens = this_.burst
+	}
+	this_.last = now
+	if this_.tokens < 1 {
+		return false
+	}
+	this_.tokens--
+	return true
+}
+
+type connLimiter struct {
+	limit        AcceptLimit
+	acceptBucket *tokenBucket
+	count        int32
+	mu           sync.Mutex
+	ipCount      map[string]int
+}
+
+func newConnLimiter(limit AcceptLimit) *connLimiter {
+	return &connLimiter{
+		limit:        limit,
+		acceptBucket: newTokenBucket(limit.AcceptRate, limit.AcceptBurst),
+		ipCount:      map[string]int{},
+	}
+}
+
+func connIP(conn net.Conn) string {
+	h, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return h
+}
+
+// 检查是否可以接收这个连接，可以接收时会占用一个名额，连接关闭时需要调用 release
+func (this_ *connLimiter) acquire(ip string) (LimitReason, bool) {
+	if !this_.acceptBucket.allow() {
+		return LimitReasonAcceptRate, false
+	}
+	if this_.limit.MaxSessions > 0 && int(atomic.LoadInt32(&this_.count)) >= this_.limit.MaxSessions {
+		return LimitReasonMaxSessions, false
+	}
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.limit.MaxSessionsPerIP > 0 && this_.ipCount[ip] >= this_.limit.MaxSessionsPerIP {
+		return LimitReasonMaxSessionsPerIP, false
+	}
+	this_.ipCount[ip]++
+	atomic.AddInt32(&this_.count, 1)
+	return "", true
+}
+
+func (this_ *connLimiter) release(ip string) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if n := this_.ipCount[ip]; n > 1 {
+		this_.ipCount[ip] = n - 1
+	} else {
+		delete(this_.ipCount, ip)
+	}
+	atomic.AddInt32(&this_.count, -1)
+}
+
+// SetLimit 设置连接限制，需要在 StartAccept 之前调用
+func (this_ *Acceptor) SetLimit(limit AcceptLimit) {
+	this_.limiter = newConnLimiter(limit)
+}
+
+// SessionCount 当前的连接数，只有设置了 SetLimit 才会统计
+func (this_ *Acceptor) SessionCount() int {
+	if this_.limiter == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&this_.limiter.count))
+}
+
+func (this_ *Acceptor) acquireConn(conn net.Conn) bool {
+	if this_.limiter == nil {
+		return true
+	}
+	reason, ok := this_.limiter.acquire(connIP(conn))
+	if !ok {
+		this_.rejectConn(conn, reason)
+	}
+	return ok
+}
+
+func (this_ *Acceptor) releaseConn(conn net.Conn) {
+	if this_.limiter != nil {
+		this_.limiter.release(connIP(conn))
+	}
+}
+
+// 被拒绝的连接直接关闭，通过 OnSessionDisConnected 通知
+func (this_ *Acceptor) rejectConn(conn net.Conn, reason LimitReason) {
+	sess := NewSession(conn, this_.queue, true, this_.codeC, this_.logger, time.Second*2, this_.isDebugLog)
+	sess.Dispatch = this_.ai
+	sess.onClose = func(e error) {
+		this_.queue.Post(&SessionClosed{
+			Err:  e,
+			Sess: sess,
+		})
+	}
+	sess.Close(&LimitError{Reason: reason})
+}
@@ -0,0 +1,34 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiter(t *testing.T) {
+	r := require.New(t)
+	l := newConnLimiter(AcceptLimit{MaxSessions: 3, MaxSessionsPerIP: 2})
+	_, ok := l.acquire("1.1.1.1")
+	r.True(ok)
+	_, ok = l.acquire("1.1.1.1")
+	r.True(ok)
+	reason, ok := l.acquire("1.1.1.1")
+	r.False(ok)
+	r.Equal(LimitReasonMaxSessionsPerIP, reason)
+	_, ok = l.acquire("2.2.2.2")
+	r.True(ok)
+	reason, ok = l.acquire("3.3.3.3")
+	r.False(ok)
+	r.Equal(LimitReasonMaxSessions, reason)
+
+	l.release("1.1.1.1")
+	_, ok = l.acquire("3.3.3.3")
+	r.True(ok)
+
+	b := newTokenBucket(1, 2)
+	r.True(b.allow())
+	r.True(b.allow())
+	r.False(b.allow())
+	r.True(newTokenBucket(0, 0).allow())
+}
@@ -0,0 +1,164 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// 数字消息ID，两端都开启并且注册表一致时，msgID 字段用2个字节的数字代替消息名字
+// 数字ID的包 packetProtocol 带上 packetProtocolNumericFlag，msgID = 小端 uint16
+// 握手之后双方都开启时交换注册表的 hash，见 handshake.go，hash 不一致时继续使用消息名字，没有注册的消息也使用名字
+
+type registeredMsg struct {
+	name string
+	t    protoreflect.MessageType
+}
+
+var msgRegistry = struct {
+	sync.RWMutex
+	byID   map[uint16]registeredMsg
+	byName map[string]uint16
+	hash   uint32
+}{
+	byID:   map[uint16]registeredMsg{},
+	byName: map[string]uint16{},
+}
+
+// RegisterMessage 给消息注册一个数字ID，两端注册的内容必须一样，一般在 init 中调用
+// ID 或者消息重复注册会 panic
+func RegisterMessage(id uint16, msg proto.Message) {
+	name := messageName(msg)
+	if name == "" {
+		panic(fmt.Sprintf("register message %d: unknown message name", id))
+	}
+	msgRegistry.Lock()
+	defer msgRegistry.Unlock()
+	if old, ok := msgRegistry.byID[id]; ok {
+		panic(fmt.Sprintf("register message %s: id %d already registered by %s", name, id, old.name))
+	}
+	if old, ok := msgRegistry.byName[name]; ok {
+		panic(fmt.Sprintf("register message %s: already registered with id %d", name, old))
+	}
+	msgRegistry.byID[id] = registeredMsg{name: name, t: msg.ProtoReflect().Type()}
+	msgRegistry.byName[name] = id
+	rehashRegistry()
+}
+
+// 删除 id 的注册，只在测试中使用，避免注册表影响其它测试
+func unregisterMessage(id uint16) {
+	msgRegistry.Lock()
+	defer msgRegistry.Unlock()
+	if m, ok := msgRegistry.byID[id]; ok {
+		delete(msgRegistry.byID, id)
+		delete(msgRegistry.byName, m.name)
+		rehashRegistry()
+	}
+}
+
+// 在 msgRegistry 的写锁中调用
+func rehashRegistry() {
+	ids := make([]int, 0, len(msgRegistry.byID))
+	for k := range msgRegistry.byID {
+		ids = append(ids, int(k))
+	}
+	sort.Ints(ids)
+	h := crc32.NewIEEE()
+	for _, k := range ids {
+		_, _ = h.Write([]byte(strconv.Itoa(k) + "=" + msgRegistry.byID[uint16(k)].name + ";"))
+	}
+	msgRegistry.hash = h.Sum32()
+}
+
+// MessageID 返回消息注册的数字ID
+func MessageID(msg proto.Message) (uint16, bool) {
+	return lookupMsgID(messageName(msg))
+}
+
+func lookupMsgID(name string) (uint16, bool) {
+	msgRegistry.RLock()
+	defer msgRegistry.RUnlock()
+	id, ok := msgRegistry.byName[name]
+	return id, ok
+}
+
+func lookupMsg(id uint16) (registeredMsg, bool) {
+	msgRegistry.RLock()
+	defer msgRegistry.RUnlock()
+	m, ok := msgRegistry.byID[id]
+	return m, ok
+}
+
+func lookupMsgType(name string) protoreflect.MessageType {
+	id, ok := lookupMsgID(name)
+	if !ok {
+		return nil
+	}
+	m, _ := lookupMsg(id)
+	return m.t
+}
+
+func registryHash() uint32 {
+	msgRegistry.RLock()
+	defer msgRegistry.RUnlock()
+	return msgRegistry.hash
+}
+
+// EnableNumericID 开启数字消息ID，只有对端也开启并且注册表一致才会生效，需要在 Start 之前调用
+func (this_ *Session) EnableNumericID() {
+	this_.localCaps |= capNumericID
+}
+
+// NumericIDEnabled 双方是否都使用数字消息ID
+func (this_ *Session) NumericIDEnabled() bool {
+	return this_.localCaps&this_.getPeerCaps()&capNumericID != 0 && atomic.LoadUint32(&this_.peerRegistryHash) == registryHash()
+}
+
+// 有注册ID的消息换成数字ID
+func (this_ *Session) numericMsgID(pp packetProtocol, msgID string) (packetProtocol, string) {
+	if !(pp &^ packetProtocolCompressFlag).compressible() || !this_.NumericIDEnabled() {
+		return pp, msgID
+	}
+	id, ok := lookupMsgID(msgID)
+	if !ok {
+		return pp, msgID
+	}
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, id)
+	return pp | packetProtocolNumericFlag, string(b)
+}
+
+// 数字ID换回消息名字
+func (this_ *Session) resolveMsgID(pp packetProtocol, msgID string) (packetProtocol, string, error) {
+	if pp&packetProtocolNumericFlag == 0 {
+		return pp, msgID, nil
+	}
+	if len(msgID) != 2 {
+		return pp, msgID, fmt.Errorf("invalid numeric msgID len %d", len(msgID))
+	}
+	id := binary.LittleEndian.Uint16([]byte(msgID))
+	m, ok := lookupMsg(id)
+	if !ok {
+		return pp, msgID, fmt.Errorf("unknown numeric msgID %d", id)
+	}
+	return pp &^ packetProtocolNumericFlag, m.name, nil
+}
+
+// SetNumericID 开启数字消息ID，对之后接入的连接生效
+func (this_ *Acceptor) SetNumericID() {
+	this_.isNumericID = true
+}
+
+// WithNumericID 开启数字消息ID，服务器不支持或者注册表不一致时使用消息名字
+func WithNumericID() Option {
+	return func(c *Connector) {
+		c.isNumericID = true
+	}
+}
@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestNumericMsgID(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	RegisterMessage(1001, &basepb.Base_Error{})
+	t.Cleanup(func() {
+		unregisterMessage(1001)
+	})
+	r.Panics(func() { RegisterMessage(1001, &basepb.Base_Success{}) })
+	id, ok := MessageID(&basepb.Base_Error{})
+	r.True(ok)
+	r.Equal(uint16(1001), id)
+
+	send := newQueuedSession(r, log, SendQueueConfig{})
+	recv := newQueuedSession(r, log, SendQueueConfig{})
+	for _, s := range []*Session{send, recv} {
+		s.EnableNumericID()
+		s.peerCaps = capNumericID
+	}
+	name := messageName(&basepb.Base_Error{})
+
+	// 注册表不一致时使用消息名字
+	send.peerRegistryHash = registryHash() + 1
+	r.False(send.NumericIDEnabled())
+	r.NoError(send.Send(&basepb.Base_Error{ErrorCode: 3}))
+	r.Equal(headerLen+len(name)+2, len(send.sendBuf[0]))
+
+	send.peerRegistryHash = registryHash()
+	recv.peerRegistryHash = registryHash()
+	r.NoError(send.Send(&basepb.Base_Error{ErrorCode: 3}))
+	frame := send.sendBuf[1]
+	r.Equal(headerLen+2+2, len(frame))
+	r.Equal(packetProtocolNormal|packetProtocolNumericFlag, packetProtocol(frame[totalLenLen+msgIDLenLen]))
+
+	p, l, err := recv.recvOne(frame)
+	r.NoError(err)
+	r.Equal(len(frame), l)
+	r.Equal(packetProtocolNormal, p.Protocol)
+	r.Equal(name, p.MsgID)
+	r.EqualValues(3, p.Msg.(*basepb.Base_Error).ErrorCode)
+
+	// 没有注册的消息继续使用名字
+	r.NoError(send.Send(&basepb.Base_Success{}))
+	r.Equal(packetProtocolNormal, packetProtocol(send.sendBuf[2][totalLenLen+msgIDLenLen]))
+
+	unregisterMessage(1001)
+	_, ok = MessageID(&basepb.Base_Error{})
+	r.False(ok)
+	r.Zero(registryHash())
+}
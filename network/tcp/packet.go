@@ -1,7 +1,7 @@
 package tcp
 
 import (
-	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
 )
 
 type Packet struct {
@@ -10,6 +10,8 @@ type Packet struct {
 	MsgID    string
 	Msg      proto.Message
 	Sess     *Session
+	// 控制包的 body，例如流控的 credit
+	data []byte
 }
 
 const (
@@ -20,6 +22,11 @@ const (
 	rpcIndexLen       = 4
 	headerLen         = totalLenLen + msgIDLenLen + packetProtocolLen + rpcIndexLen
 	//packet = totalLenLen +msgIDLenLen+ packetProtocolLen + rpcIndexLen + msgID + body
+
+	// 分片重组后单个消息默认的最大长度
+	defaultMaxMessageLen = 1024 * 1024 * 16
+	// 分片包 body 前面的头: 原始 packetProtocol + 是否最后一片
+	fragmentHeaderLen = 2
 )
 
 type MsgIDType string
@@ -35,8 +42,51 @@ const (
 	packetProtocolNormal      packetProtocol = 0
 	packetProtocolRPCRequest  packetProtocol = 1
 	packetProtocolRPCResponse packetProtocol = 2
+	packetProtocolFragment    packetProtocol = 3
+	packetProtocolHandshake   packetProtocol = 4
+	// 流，见 stream.go
+	packetProtocolStreamOpen   packetProtocol = 5
+	packetProtocolStreamData   packetProtocol = 6
+	packetProtocolStreamClose  packetProtocol = 7
+	packetProtocolStreamCancel packetProtocol = 8
+	packetProtocolStreamCredit packetProtocol = 9
+	// 服务器即将关闭，对端不要再发送新的请求
+	packetProtocolGoAway packetProtocol = 10
+	// 断线恢复，见 resume.go
+	packetProtocolResumeToken  packetProtocol = 11
+	packetProtocolResume       packetProtocol = 12
+	packetProtocolResumeAck    packetProtocol = 13
+	packetProtocolResumeResult packetProtocol = 14
+	// 切换编码，之后的消息使用 body 中名字对应的 CodeC，见 codec_switch.go
+	packetProtocolCodeC packetProtocol = 15
+	// 认证 token，见 auth.go
+	packetProtocolAuth packetProtocol = 16
+	// 数字消息ID的注册表 hash，见 handshake.go
+	packetProtocolRegistryHash packetProtocol = 17
+
+	// 最高位表示 body 是压缩过的
+	packetProtocolCompressFlag packetProtocol = 0x80
+	// msgID 是2个字节的数字ID，见 msgid.go
+	packetProtocolNumericFlag packetProtocol = 0x40
 )
 
+func (this_ packetProtocol) compressible() bool {
+	switch this_ {
+	case packetProtocolNormal, packetProtocolRPCRequest, packetProtocolRPCResponse, packetProtocolStreamOpen, packetProtocolStreamData:
+		return true
+	}
+	return false
+}
+
+// 没有消息体的控制包，body 不是 proto 消息
+func (this_ packetProtocol) isControl() bool {
+	switch this_ {
+	case packetProtocolStreamClose, packetProtocolStreamCancel, packetProtocolStreamCredit, packetProtocolGoAway, packetProtocolResumeResult, packetProtocolAuth:
+		return true
+	}
+	return false
+}
+
 func (this_ *Packet) GetSession() *Session {
 	return this_.Sess
 }
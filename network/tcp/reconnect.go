@@ -0,0 +1,128 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrReconnectGiveUp 重连次数达到上限，放弃重连，通过 SetOnConnectFailed 设置的回调通知
+var ErrReconnectGiveUp = errors.New("reconnect give up")
+
+// ReconnectPolicy 重连策略
+type ReconnectPolicy interface {
+	// attempt 为连续第几次重连，从1开始，连接成功后重新计数
+	// 返回等待多久之后重连，返回 false 表示放弃重连
+	NextDelay(attempt int) (time.Duration, bool)
+}
+
+// ExponentialBackoff 指数退避重连，第 n 次等待 Initial*Multiplier^(n-1)，最多等待 Max
+// Jitter 为随机抖动的比例(0-1)，避免大量客户端同时重连，MaxAttempts 为0时不限次数
+type ExponentialBackoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy WithReconnect 使用的重连策略
+var DefaultReconnectPolicy ReconnectPolicy = &ExponentialBackoff{
+	Initial:    time.Millisecond * 100,
+	Max:        time.Second * 10,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+func (this_ *ExponentialBackoff) NextDelay(attempt int) (time.Duration, bool) {
+	if this_.MaxAttempts > 0 && attempt > this_.MaxAttempts {
+		return 0, false
+	}
+	multiplier := this_.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(this_.Initial)
+	for i := 1; i < attempt && (this_.Max <= 0 || d < float64(this_.Max)); i++ {
+		d *= multiplier
+	}
+	if this_.Max > 0 && d > float64(this_.Max) {
+		d = float64(this_.Max)
+	}
+	if this_.Jitter > 0 {
+		d += d * this_.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d), true
+}
+
+// WithReconnectPolicy 断开或者连接失败后按照 p 重连
+func WithReconnectPolicy(p ReconnectPolicy) Option {
+	return func(c *Connector) {
+		c.isReconnect = true
+		c.policy = p
+	}
+}
+
+// 在 queue 中调用
+func (this_ *Connector) reconnect() {
+	this_.reconnectAttempt++
+	policy := this_.policy
+	if policy == nil {
+		policy = DefaultReconnectPolicy
+	}
+	d, ok := policy.NextDelay(this_.reconnectAttempt)
+	if !ok {
+		this_.logger.WarnFormat("connector %s reconnect %s give up after %d attempts", this_.ConnectorName, this_.addr, this_.reconnectAttempt-1)
+		if this_.cf != nil {
+			this_.cf(this_, ErrReconnectGiveUp)
+		}
+		return
+	}
+	if d <= 0 {
+		this_.Connect()
+		return
+	}
+	this_.queue.AfterFunc(d, func(time.Time) {
+		this_.Connect()
+	})
+}
+
+// State 当前的连接状态 ConnectStateInvalid/ConnectStateConnecting/ConnectStateConnected/ConnectStateDisconnected
+func (this_ *Connector) State() int64 {
+	return this_.getConnectState()
+}
+
+// SetOnStateChange 连接状态变化时在 queue 中调用 f
+func (this_ *Connector) SetOnStateChange(f func(c *Connector, old int64, new int64)) {
+	this_.sc = f
+}
+
+// WaitConnected 阻塞直到连接成功或者 ctx 结束，别在 queue 中调用
+func (this_ *Connector) WaitConnected(ctx context.Context) error {
+	for {
+		this_.stateMu.Lock()
+		ch := this_.stateCh
+		this_.stateMu.Unlock()
+		if this_.State() == ConnectStateConnected {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+func (this_ *Connector) notifyState(old int64, new int64) {
+	this_.stateMu.Lock()
+	close(this_.stateCh)
+	this_.stateCh = make(chan struct{})
+	this_.stateMu.Unlock()
+	if sc := this_.sc; sc != nil {
+		this_.queue.Post(func() {
+			sc(this_, old, new)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	r := require.New(t)
+	p := &ExponentialBackoff{Initial: time.Second, Max: time.Second * 5, Multiplier: 2, MaxAttempts: 5}
+	for i, v := range []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5} {
+		d, ok := p.NextDelay(i + 1)
+		r.True(ok)
+		r.Equal(v, d)
+	}
+	_, ok := p.NextDelay(6)
+	r.False(ok)
+
+	p.Jitter = 0.5
+	d, _ := p.NextDelay(1)
+	r.True(d >= time.Millisecond*500 && d <= time.Millisecond*1500)
+}
+
+type attrDispatch struct {
+	*chanDispatch
+	values chan interface{}
+}
+
+func (this_ *attrDispatch) OnSessionDisConnected(s *Session, err error) {
+	v, _ := s.Get("k")
+	this_.values <- v
+	this_.chanDispatch.OnSessionDisConnected(s, err)
+}
+
+func TestConnectorClearAttributes(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	acceptor, err := NewAcceptorWithTransport("127.0.0.1:0", &loopbackTransport{}, newRunningQueue(log), &ProtoCodeC{}, log, false)
+	r.NoError(err)
+	server := newChanDispatch()
+	acceptor.SetCallback(server)
+	acceptor.StartAccept()
+	defer acceptor.Close()
+
+	client := &attrDispatch{chanDispatch: newChanDispatch(), values: make(chan interface{}, 1)}
+	c := NewConnector(net.JoinHostPort(acceptor.IP, acceptor.Port), newRunningQueue(log), log, "attr")
+	c.SetCallback(client)
+	c.Connect()
+	defer c.Close(ErrSessionClosed)
+	sess := client.waitConnected(r)
+	sess.Set("k", 1)
+	server.waitConnected(r).Close(ErrServerShutdown)
+
+	// OnSessionDisConnected 中还可以读取，之后清空
+	client.waitDisconnected(r)
+	r.Equal(1, <-client.values)
+	_, ok := sess.Get("k")
+	r.False(ok)
+}
@@ -0,0 +1,496 @@
+package tcp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 断线恢复，给网络不稳定的手机客户端用
+// 服务器发给客户端的普通消息在 rpcIndex 中带上序号，并且保存在重发缓存里，客户端定期确认收到的最大序号
+// 客户端断线重连之后第一个包发送 token 和收到的最大序号，服务器把原来的会话换到新连接上，补发没有收到的消息
+// 新连接第一个包不是恢复请求，或者等待超时，当作新会话，这样不支持恢复的客户端可以继续正常连接
+// 只有发送了恢复请求的客户端才会收到 token，其它客户端的会话断线之后直接关闭
+//
+// 恢复请求 = header(packetProtocolResume, rpcIndex=收到的最大序号) + msgID(Ping) + token，token 为空表示新会话
+// 服务器发送 token = header(packetProtocolResumeToken) + msgID(Ping) + token
+// 确认 = header(packetProtocolResumeAck, rpcIndex=收到的最大序号) + msgID(Ping)
+// 恢复结果 = header(packetProtocolResumeResult, rpcIndex=1 成功 0 失败) + msgID(Ping)
+
+var (
+	// 断线之后在 ResumeConfig.Expire 内客户端没有回来，会话关闭
+	ErrSessionExpired  = errors.New("session resume expired")
+	errSessionReplaced = errors.New("session replaced by new connection")
+)
+
+// ResumeConfig 断线恢复配置
+type ResumeConfig struct {
+	// 断线之后会话保留多久
+	Expire time.Duration
+	// 最多缓存多少个客户端还没有确认的消息，超过之后最早的消息被丢弃，客户端需要这些消息时不能恢复
+	ReplayBuffer int
+	// 新连接等待恢复请求的时间，超时当作新会话
+	WaitFirstFrame time.Duration
+}
+
+var DefaultResumeConfig = ResumeConfig{
+	Expire:         time.Minute,
+	ReplayBuffer:   1024,
+	WaitFirstFrame: time.Second * 2,
+}
+
+func (this_ ResumeConfig) withDefault() ResumeConfig {
+	if this_.Expire <= 0 {
+		this_.Expire = DefaultResumeConfig.Expire
+	}
+	if this_.ReplayBuffer <= 0 {
+		this_.ReplayBuffer = DefaultResumeConfig.ReplayBuffer
+	}
+	if this_.WaitFirstFrame <= 0 {
+		this_.WaitFirstFrame = DefaultResumeConfig.WaitFirstFrame
+	}
+	return this_
+}
+
+// ResumeInterface 由 Session.Dispatch 实现
+type ResumeInterface interface {
+	// 断线之后恢复了原来的会话，服务器端 s 还是原来的会话，客户端 s 是新连接的会话
+	OnSessionResumed(s *Session)
+	// 会话已经过期不能恢复
+	// 服务器端之后还会调用 OnSessionDisConnected(s, ErrSessionExpired)，客户端需要重新登录
+	OnSessionExpired(s *Session)
+}
+
+type SessionResumed struct {
+	Sess *Session
+}
+
+func (this_ *SessionResumed) GetSession() *Session {
+	return this_.Sess
+}
+
+const (
+	// 新连接还不知道是新会话还是恢复
+	resumePending uint32 = iota
+	// 已经通知过上层的会话
+	resumeAnnounced
+	// 连接已经交给原来的会话
+	resumeMoved
+	resumeExpired
+)
+
+// 客户端每收到多少个消息确认一次，心跳的时候也会确认
+const resumeAckEvery = 32
+
+type replayFrame struct {
+	seq uint32
+	// 编码这个消息时使用的 CodeC，恢复时对端从默认编码开始，需要重新告诉它
+	codec string
+	data  []byte
+}
+
+// 服务器端会话的恢复状态
+type resumeState struct {
+	acceptor *Acceptor
+	phase    uint32
+	token    string
+	limit    int
+
+	// 以下字段由 Session.sendCond.L 保护
+	seq uint32
+	// 这个序号和之前的消息已经不在缓存里了
+	dropped uint32
+	replay  []replayFrame
+}
+
+func (this_ *resumeState) push(seq uint32, codec string, data []byte) {
+	this_.replay = append(this_.replay, replayFrame{seq: seq, codec: codec, data: data})
+	if n := len(this_.replay) - this_.limit; n > 0 {
+		this_.dropped = this_.replay[n-1].seq
+		this_.replay = this_.replay[n:]
+	}
+}
+
+func (this_ *resumeState) trim(ack uint32) {
+	i := 0
+	for i < len(this_.replay) && this_.replay[i].seq <= ack {
+		i++
+	}
+	this_.replay = this_.replay[i:]
+	if ack > this_.dropped {
+		this_.dropped = ack
+	}
+}
+
+// 客户端的恢复状态，同一个 Connector 的所有连接共用
+type resumeClient struct {
+	mu      sync.Mutex
+	token   string
+	lastSeq uint32
+	acked   uint32
+}
+
+// 新会话的 token，序号重新开始
+func (this_ *resumeClient) setToken(token string) {
+	this_.mu.Lock()
+	this_.token = token
+	this_.lastSeq = 0
+	this_.acked = 0
+	this_.mu.Unlock()
+}
+
+func (this_ *resumeClient) get() (string, uint32) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	return this_.token, this_.lastSeq
+}
+
+// 返回需要确认的序号，0 表示不需要确认
+func (this_ *resumeClient) needAck(force bool) uint32 {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.lastSeq == this_.acked || (!force && this_.lastSeq-this_.acked < resumeAckEvery) {
+		return 0
+	}
+	this_.acked = this_.lastSeq
+	return this_.lastSeq
+}
+
+func (this_ *resumeClient) received(s *Session, seq uint32) {
+	this_.mu.Lock()
+	if seq > this_.lastSeq {
+		this_.lastSeq = seq
+	}
+	this_.mu.Unlock()
+	if ack := this_.needAck(false); ack != 0 {
+		_ = s.sendRawByte(packetProtocolResumeAck, ack, messagePingName, nil)
+	}
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 连接断开之后可以等待恢复的错误
+func isLinkError(err error) bool {
+	switch err {
+	case io.EOF, ErrHeartbeatTimeout, ErrReadIdleTimeout, errSessionReplaced:
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// IsResumable 是否开启了断线恢复
+func (this_ *Session) IsResumable() bool {
+	return this_.resume != nil || this_.resumeClient != nil
+}
+
+// IsDetached 服务器端会话已经断线，正在等待客户端恢复
+func (this_ *Session) IsDetached() bool {
+	return this_.isDetached()
+}
+
+func (this_ *Session) isDetached() bool {
+	return atomic.LoadUint32(&this_.detached) == 1
+}
+
+func (this_ *Session) isExpired() bool {
+	return this_.resume != nil && atomic.LoadUint32(&this_.resume.phase) == resumeExpired
+}
+
+// 需要带序号并且保存到重发缓存的消息，心跳不需要
+func (this_ *Session) replayable(pp packetProtocol, msgID string) bool {
+	return this_.resume != nil && pp == packetProtocolNormal && msgID != messagePingName && msgID != messagePongName
+}
+
+// token 在 phase 变为 resumeAnnounced 之前设置，没有 token 的是不支持恢复的客户端
+func (this_ *Session) canDetach(err error) bool {
+	return this_.resume != nil && atomic.LoadUint32(&this_.resume.phase) == resumeAnnounced && this_.resume.token != "" &&
+		this_.authPassed() && isLinkError(err)
+}
+
+// 序号和组包都在锁里面做，保证发送的顺序和序号一致
+func (this_ *Session) sendReplay(pp packetProtocol, msgID string, bodyData []byte) error {
+	r := this_.resume
+	this_.sendCond.L.Lock()
+	if this_.isClose() && !this_.isDetached() {
+		this_.sendCond.L.Unlock()
+		return ErrSessionClosed
+	}
+	// 断线期间只放进重发缓存
+	if !this_.isClose() {
+		if err := this_.waitSendQueue(len(bodyData) + headerLen + len(msgID)); err != nil {
+			this_.sendCond.L.Unlock()
+			return this_.onSendQueueFull(err)
+		}
+	}
+	data, err := this_.frame(pp, r.seq+1, msgID, bodyData)
+	if err != nil {
+		this_.sendCond.L.Unlock()
+		return err
+	}
+	r.seq++
+	r.push(r.seq, this_.CodeC.String(), data)
+	if !this_.isClose() {
+		this_.pushSendBuf(data)
+	}
+	this_.sendCond.L.Unlock()
+	this_.sendCond.Signal()
+	return nil
+}
+
+// 在 recvLoop 中处理，恢复请求返回 Packet 交给 recvLoop 换连接
+func (this_ *Session) onResumePacket(pp packetProtocol, rpcIndex uint32, msgID string, body []byte) *Packet {
+	switch pp {
+	case packetProtocolResume:
+		if this_.resume != nil && atomic.LoadUint32(&this_.resume.phase) == resumePending {
+			return &Packet{MsgID: msgID, Sess: this_, Protocol: pp, RPCIndex: rpcIndex, data: append([]byte(nil), body...)}
+		}
+	case packetProtocolResumeAck:
+		if this_.resume != nil {
+			this_.sendCond.L.Lock()
+			this_.resume.trim(rpcIndex)
+			this_.sendCond.L.Unlock()
+		}
+	case packetProtocolResumeToken:
+		if this_.resumeClient != nil {
+			this_.resumeClient.setToken(string(body))
+		}
+	}
+	return nil
+}
+
+// 把会话换到 t 的连接上，补发 lastSeq 之后的消息，rest 是 t 已经读到还没处理的数据
+func (this_ *Session) rebind(t *Session, lastSeq uint32, rest []byte) bool {
+	// 旧连接可能还没有发现已经断开
+	this_.Close(errSessionReplaced)
+
+	r := this_.resume
+	this_.sendCond.L.Lock()
+	if !this_.isDetached() || lastSeq < r.dropped || lastSeq > r.seq {
+		this_.sendCond.L.Unlock()
+		return false
+	}
+	r.trim(lastSeq)
+	// 旧连接没发出去的其他包不再发送，补发的消息不受发送缓存限制
+	this_.sendBuf = make([][]byte, 0, len(r.replay)+1)
+	this_.sendBufBytes = 0
+	this_.pushSendBuf(appendFrame(nil, packetProtocolResumeResult, 1, messagePingName, nil, nil))
+	codec := this_.baseCodeC.String()
+	for _, f := range r.replay {
+		if f.codec != codec {
+			codec = f.codec
+			this_.pushSendBuf(codecFrame(codec))
+		}
+		this_.pushSendBuf(f.data)
+	}
+	if cur := this_.CodeC.String(); cur != codec {
+		this_.pushSendBuf(codecFrame(cur))
+	}
+	// 新连接上对端从默认编码开始发送
+	this_.recvCodeC = this_.baseCodeC
+
+	this_.linkMu.Lock()
+	this_.conn = t.conn
+	this_.remoteAddr = t.remoteAddr
+	this_.localAddr = t.localAddr
+	this_.remoteIP = t.remoteIP
+	this_.tlsState = t.tlsState
+	this_.closeChan = make(chan struct{})
+	this_.linkMu.Unlock()
+	this_.msgLimiter = t.msgLimiter
+	this_.fragReader = fragmentReader{}
+	atomic.StoreUint32(&this_.handshakeSent, 0)
+	atomic.StoreInt64(&this_.pingSentAt, 0)
+	atomic.StoreInt64(&this_.lastPongTime, time.Now().UnixNano())
+	atomic.AddUint32(&this_.gen, 1)
+	atomic.StoreUint32(&this_.detached, 0)
+	atomic.StoreUint32(&this_.closed, 0)
+	this_.sendCond.L.Unlock()
+
+	t.detachConn()
+	this_.start(rest)
+	return true
+}
+
+// 连接已经交给别的会话，只停止自己的协程，不关闭连接
+func (this_ *Session) detachConn() {
+	this_.sendCond.L.Lock()
+	atomic.StoreUint32(&this_.closed, 1)
+	this_.sendCond.L.Unlock()
+	close(this_.closeChan)
+	this_.sendCond.Broadcast()
+	this_.spaceCond.Broadcast()
+}
+
+func (this_ *Session) expire(err error) {
+	this_.sendCond.L.Lock()
+	if !atomic.CompareAndSwapUint32(&this_.detached, 1, 0) {
+		this_.sendCond.L.Unlock()
+		return
+	}
+	atomic.StoreUint32(&this_.resume.phase, resumeExpired)
+	this_.resume.replay = nil
+	this_.sendCond.L.Unlock()
+	this_.finalClose(err)
+}
+
+// 客户端连接建立之后第一个包
+func (this_ *Session) sendResume() error {
+	token, seq := this_.resumeClient.get()
+	return this_.sendRawByte(packetProtocolResume, seq, messagePingName, []byte(token))
+}
+
+func (this_ *Session) sendResumeAck() error {
+	if this_.resumeClient == nil {
+		return nil
+	}
+	if ack := this_.resumeClient.needAck(true); ack != 0 {
+		return this_.sendRawByte(packetProtocolResumeAck, ack, messagePingName, nil)
+	}
+	return nil
+}
+
+func (this_ *Session) onResumeResult(ok bool) {
+	if this_.isDebugLog {
+		this_.logger.Debug("session resume result", zap.Bool("ok", ok), zap.String("session name", this_.SessionName), zap.String("remote", this_.RemoteAddr()))
+	}
+	r, isResume := this_.Dispatch.(ResumeInterface)
+	if !isResume {
+		return
+	}
+	if ok {
+		r.OnSessionResumed(this_)
+	} else {
+		r.OnSessionExpired(this_)
+	}
+}
+
+// SetResume 开启断线恢复，对之后接入的连接生效
+// 客户端需要使用 WithResume，断线期间发送的普通消息会在客户端恢复之后补发
+// 只有网络错误、心跳超时断开的会话才会保留，主动 Close 的会话直接关闭
+func (this_ *Acceptor) SetResume(cfg ResumeConfig) {
+	this_.resumeCfg = cfg.withDefault()
+	this_.isResume = true
+}
+
+// 第一个包不是恢复请求或者等待超时，是不支持恢复的客户端，不发送 token
+func (this_ *Acceptor) announceOnce(sess *Session) {
+	if atomic.CompareAndSwapUint32(&sess.resume.phase, resumePending, resumeAnnounced) {
+		this_.postAccept(sess)
+		this_.startHeartbeat(sess)
+	}
+}
+
+// 发送了恢复请求的新会话，发送 token 并通知上层
+func (this_ *Acceptor) announce(sess *Session, resumeFailed bool) {
+	token := newResumeToken()
+	sess.resume.token = token
+	this_.resumable.Store(token, sess)
+	atomic.StoreUint32(&sess.resume.phase, resumeAnnounced)
+	if resumeFailed {
+		_ = sess.sendRawByte(packetProtocolResumeResult, 0, messagePingName, nil)
+	}
+	_ = sess.sendRawByte(packetProtocolResumeToken, 0, messagePingName, []byte(token))
+	this_.postAccept(sess)
+	this_.startHeartbeat(sess)
+}
+
+// 在 t 的 recvLoop 中调用，返回 true 表示连接已经交给原来的会话
+func (this_ *Acceptor) resumeSession(t *Session, p *Packet, rest []byte) bool {
+	if !atomic.CompareAndSwapUint32(&t.resume.phase, resumePending, resumeMoved) {
+		return false
+	}
+	token := string(p.data)
+	if token != "" {
+		if v, ok := this_.resumable.Load(token); ok {
+			sess := v.(*Session)
+			if sess.rebind(t, p.RPCIndex, rest) {
+				this_.sessions.Delete(t)
+				this_.sessions.Store(sess, struct{}{})
+				if this_.isDebugLog {
+					this_.logger.Debug("session resumed", zap.String("remote", sess.RemoteAddr()), zap.Uint32("last seq", p.RPCIndex))
+				}
+				this_.queue.Post(&SessionResumed{Sess: sess})
+				this_.startHeartbeat(sess)
+				return true
+			}
+			// 客户端需要的消息已经不在缓存里了
+			sess.expire(ErrSessionExpired)
+		}
+	}
+	this_.announce(t, token != "")
+	return false
+}
+
+func (this_ *Acceptor) onDetach(sess *Session, err error) {
+	this_.sessions.Delete(sess)
+	this_.releaseConn(sess.getConn())
+	if this_.isDebugLog {
+		this_.logger.Debug("session detached", zap.Error(err), zap.String("remote", sess.RemoteAddr()))
+	}
+	gen := atomic.LoadUint32(&sess.gen)
+	this_.queue.AfterFunc(this_.resumeCfg.Expire, func(time.Time) {
+		if atomic.LoadUint32(&sess.gen) == gen {
+			sess.expire(ErrSessionExpired)
+		}
+	})
+}
+
+// 会话关闭时删除 token，返回 false 表示没有通知过上层，不需要发送关闭事件
+func (this_ *Acceptor) forgetResumable(sess *Session) bool {
+	if atomic.CompareAndSwapUint32(&sess.resume.phase, resumePending, resumeMoved) {
+		return false
+	}
+	if atomic.LoadUint32(&sess.resume.phase) == resumeMoved {
+		return false
+	}
+	if sess.resume.token != "" {
+		this_.resumable.Delete(sess.resume.token)
+	}
+	return true
+}
+
+// 关闭所有等待恢复的会话
+func (this_ *Acceptor) expireDetached(err error) {
+	this_.resumable.Range(func(key, value interface{}) bool {
+		value.(*Session).expire(err)
+		return true
+	})
+}
+
+// WithResume 开启断线恢复，服务器需要调用 Acceptor.SetResume
+// 重连之后服务器补发断线期间的消息，结果通过 ResumeInterface 通知
+func WithResume() Option {
+	return func(c *Connector) {
+		c.resume = &resumeClient{}
+	}
+}
+
+func (this_ *Connector) OnSessionResumed(s *Session) {
+	if this_.isDebugLog {
+		this_.logger.Debug("session resumed", zap.String("addr", this_.addr))
+	}
+	if r, ok := this_.ci.(ResumeInterface); ok && this_.ci != DispatchInterface(this_) {
+		r.OnSessionResumed(s)
+	}
+}
+
+func (this_ *Connector) OnSessionExpired(s *Session) {
+	if this_.isDebugLog {
+		this_.logger.Debug("session resume expired", zap.String("addr", this_.addr))
+	}
+	if r, ok := this_.ci.(ResumeInterface); ok && this_.ci != DispatchInterface(this_) {
+		r.OnSessionExpired(s)
+	}
+}
@@ -0,0 +1,175 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestResumeReplayBuffer(t *testing.T) {
+	r := require.New(t)
+	s := &resumeState{limit: 3}
+	for i := uint32(1); i <= 5; i++ {
+		s.push(i, "", nil)
+	}
+	r.Len(s.replay, 3)
+	r.Equal(uint32(2), s.dropped)
+	r.Equal(uint32(3), s.replay[0].seq)
+
+	s.trim(4)
+	r.Len(s.replay, 1)
+	r.Equal(uint32(5), s.replay[0].seq)
+	r.Equal(uint32(4), s.dropped)
+}
+
+func TestIsLinkError(t *testing.T) {
+	r := require.New(t)
+	r.True(isLinkError(io.EOF))
+	r.True(isLinkError(ErrHeartbeatTimeout))
+	r.True(isLinkError(&net.OpError{Op: "read", Err: io.ErrUnexpectedEOF}))
+	r.False(isLinkError(ErrServerShutdown))
+	r.False(isLinkError(&LimitError{Reason: LimitReasonMsgRate}))
+}
+
+func readTestFrame(r *require.Assertions, conn net.Conn) (packetProtocol, uint32) {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	head := make([]byte, headerLen)
+	_, err := io.ReadFull(conn, head)
+	r.NoError(err)
+	body := make([]byte, int(binary.LittleEndian.Uint32(head))-headerLen)
+	_, err = io.ReadFull(conn, body)
+	r.NoError(err)
+	return packetProtocol(head[totalLenLen+msgIDLenLen]), binary.LittleEndian.Uint32(head[totalLenLen+msgIDLenLen+packetProtocolLen:])
+}
+
+func TestSessionResumeReplay(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	r.NoError(err)
+	defer ln.Close()
+	queue := eventqueue.NewEventQueue(0, log)
+	acceptor := &Acceptor{queue: queue, logger: log, resumeCfg: DefaultResumeConfig}
+
+	pair := func() (net.Conn, *Session) {
+		c, err := net.Dial("tcp4", ln.Addr().String())
+		r.NoError(err)
+		s, err := ln.Accept()
+		r.NoError(err)
+		return c, NewSession(s, queue, true, &ProtoCodeC{}, log, time.Second, false)
+	}
+
+	c1, sess := pair()
+	defer c1.Close()
+	sess.resume = &resumeState{acceptor: acceptor, phase: resumeAnnounced, token: "t1", limit: 16}
+	sess.Start()
+	for i := 0; i < 3; i++ {
+		r.NoError(sess.Send(&basepb.Base_Success{}))
+	}
+	for i := uint32(1); i <= 3; i++ {
+		pp, seq := readTestFrame(r, c1)
+		r.Equal(packetProtocolNormal, pp)
+		r.Equal(i, seq)
+	}
+
+	// 断线期间发送的消息进入重发缓存
+	sess.Close(io.EOF)
+	r.True(sess.IsDetached())
+	r.NoError(sess.Send(&basepb.Base_Success{}))
+	r.NoError(sess.Send(&basepb.Base_Success{}))
+	r.Error(sess.sendGoAway())
+
+	c2, t2 := pair()
+	defer c2.Close()
+	r.False(sess.rebind(t2, 6, nil))
+	r.True(sess.rebind(t2, 2, nil))
+	r.False(sess.IsDetached())
+	pp, ok := readTestFrame(r, c2)
+	r.Equal(packetProtocolResumeResult, pp)
+	r.Equal(uint32(1), ok)
+	for i := uint32(3); i <= 5; i++ {
+		pp, seq := readTestFrame(r, c2)
+		r.Equal(packetProtocolNormal, pp)
+		r.Equal(i, seq)
+	}
+	sess.Close(ErrServerShutdown)
+	r.False(sess.IsDetached())
+}
+
+func TestResumeDetachedKick(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := newRunningQueue(log)
+	acceptor := &Acceptor{queue: queue, logger: log, resumeCfg: DefaultResumeConfig}
+	sess, peer := newTestSessionPair(r, log, queue)
+	defer peer.Close()
+	closed := make(chan error, 1)
+	sess.onClose = func(err error) {
+		closed <- err
+	}
+	sess.resume = &resumeState{acceptor: acceptor, phase: resumeAnnounced, token: "t1", limit: 16}
+	acceptor.resumable.Store("t1", sess)
+	sess.Start()
+
+	sess.Close(io.EOF)
+	r.True(sess.IsDetached())
+	// 断线之后再次断线的错误不影响等待恢复
+	sess.Close(ErrHeartbeatTimeout)
+	r.True(sess.IsDetached())
+	select {
+	case <-closed:
+		r.FailNow("detached session closed")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// 踢下线直接过期，不再等客户端回来
+	sess.Close(ErrServerShutdown)
+	r.Equal(ErrServerShutdown, <-closed)
+	r.False(sess.IsDetached())
+	r.True(sess.isExpired())
+	t2, c2 := newTestSessionPair(r, log, queue)
+	defer c2.Close()
+	r.False(sess.rebind(t2, 0, nil))
+}
+
+func TestResumeTokenOnlyForResumeClient(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	acceptor, err := NewAcceptorWithTransport("127.0.0.1:0", &loopbackTransport{}, newRunningQueue(log), &ProtoCodeC{}, log, false)
+	r.NoError(err)
+	acceptor.SetResume(ResumeConfig{WaitFirstFrame: time.Millisecond * 50})
+	server := newChanDispatch()
+	acceptor.SetCallback(server)
+	acceptor.StartAccept()
+	defer acceptor.Close()
+	addr := net.JoinHostPort(acceptor.IP, acceptor.Port)
+
+	// 不支持恢复的客户端收不到 token，断线之后直接关闭
+	c, err := net.Dial("tcp4", addr)
+	r.NoError(err)
+	server.waitConnected(r)
+	_ = c.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	_, err = c.Read(make([]byte, 1))
+	var ne net.Error
+	r.ErrorAs(err, &ne)
+	r.True(ne.Timeout())
+	_ = c.Close()
+	r.Equal(io.EOF, server.waitDisconnected(r))
+
+	// 发送了恢复请求的客户端收到 token
+	c, err = net.Dial("tcp4", addr)
+	r.NoError(err)
+	defer c.Close()
+	_, err = c.Write(appendFrame(nil, packetProtocolResume, 0, messagePingName, nil, nil))
+	r.NoError(err)
+	pp, _ := readTestFrame(r, c)
+	r.Equal(packetProtocolResumeToken, pp)
+	server.waitConnected(r)
+}
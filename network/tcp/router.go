@@ -0,0 +1,269 @@
+package tcp
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+)
+
+// HandlerFunc 普通消息的返回值会被忽略，rpc 请求返回 nil 时回复 basepb.Base_Success
+type HandlerFunc func(s *Session, msg proto.Message) proto.Message
+
+// Middleware 中间件，按 Use 的顺序从外到内执行
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type routerHandler struct {
+	h HandlerFunc
+	// 包上了中间件的 h，注册或者 Use 的时候生成
+	wrapped    HandlerFunc
+	concurrent bool
+}
+
+var (
+	sessionType = reflect.TypeOf((*Session)(nil))
+	messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+// Router 按消息类型注册处理函数，实现了 DispatchInterface 和 DoConcurrentInterface
+//
+//	router := tcp.NewRouter(log)
+//	router.Use(tcp.RecoverMiddleware(log))
+//	router.Handle(&pb.Login{}, func(s *tcp.Session, m *pb.Login) proto.Message { ... })
+//	acceptor.SetCallback(router)
+//	acceptor.SetDoConcurrent(router)
+//
+// 注册必须在 Acceptor 开始接收连接或者 Connector 连接之前完成
+type Router struct {
+	handlers       map[string]*routerHandler
+	middlewares    []Middleware
+	fallback       *routerHandler
+	onConnected    func(s *Session)
+	onDisConnected func(s *Session, err error)
+	log            *logger.Logger
+}
+
+func NewRouter(log *logger.Logger) *Router {
+	return &Router{
+		handlers: map[string]*routerHandler{},
+		log:      log,
+	}
+}
+
+// Handle 注册 msg 类型的处理函数，在逻辑线程中调用
+// handler 的类型必须是 func(*Session, *T) proto.Message 或者 func(*Session, *T)，*T 为 msg 的类型，否则会 panic
+func (this_ *Router) Handle(msg proto.Message, handler interface{}) {
+	this_.handle(msg, handler, false)
+}
+
+// HandleConcurrent 同 Handle，但是收到消息时会在协程池中调用，需要 DispatchMsg 传入了协程池
+func (this_ *Router) HandleConcurrent(msg proto.Message, handler interface{}) {
+	this_.handle(msg, handler, true)
+}
+
+func (this_ *Router) handle(msg proto.Message, handler interface{}, concurrent bool) {
+	msgID := messageName(msg)
+	if msgID == "" {
+		panic(fmt.Sprintf("router handle unknown msg %s", reflect.TypeOf(msg).String()))
+	}
+	if _, ok := this_.handlers[msgID]; ok {
+		panic(fmt.Sprintf("router handle duplicate msg %s", msgID))
+	}
+	h, err := wrapHandler(msg, handler)
+	if err != nil {
+		panic(fmt.Sprintf("router handle msg %s:%s", msgID, err.Error()))
+	}
+	this_.handlers[msgID] = this_.newHandler(h, concurrent)
+}
+
+func (this_ *Router) newHandler(h HandlerFunc, concurrent bool) *routerHandler {
+	v := &routerHandler{h: h, concurrent: concurrent}
+	this_.wrap(v)
+	return v
+}
+
+// 按 Use 的顺序从外到内包上中间件
+func (this_ *Router) wrap(v *routerHandler) {
+	h := v.h
+	for i := len(this_.middlewares) - 1; i >= 0; i-- {
+		h = this_.middlewares[i](h)
+	}
+	v.wrapped = h
+}
+
+func wrapHandler(msg proto.Message, handler interface{}) (HandlerFunc, error) {
+	switch h := handler.(type) {
+	case HandlerFunc:
+		return h, nil
+	case func(*Session, proto.Message) proto.Message:
+		return h, nil
+	case func(*Session, proto.Message):
+		return func(s *Session, m proto.Message) proto.Message {
+			h(s, m)
+			return nil
+		}, nil
+	}
+
+	v := reflect.ValueOf(handler)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("handler must be func,got %T", handler)
+	}
+	t := v.Type()
+	if t.NumIn() != 2 || t.In(0) != sessionType || t.In(1) != reflect.TypeOf(msg) {
+		return nil, fmt.Errorf("handler must be func(*tcp.Session, %T),got %s", msg, t.String())
+	}
+	if t.NumOut() > 1 || (t.NumOut() == 1 && !t.Out(0).Implements(messageType)) {
+		return nil, fmt.Errorf("handler must return nothing or proto.Message,got %s", t.String())
+	}
+	hasOut := t.NumOut() == 1
+	return func(s *Session, m proto.Message) proto.Message {
+		out := v.Call([]reflect.Value{reflect.ValueOf(s), reflect.ValueOf(m)})
+		if !hasOut || out[0].IsNil() {
+			return nil
+		}
+		return out[0].Interface().(proto.Message)
+	}, nil
+}
+
+// Use 添加中间件，对所有的处理函数包括 fallback 生效
+func (this_ *Router) Use(m ...Middleware) {
+	this_.middlewares = append(this_.middlewares, m...)
+	for _, v := range this_.handlers {
+		this_.wrap(v)
+	}
+	if this_.fallback != nil {
+		this_.wrap(this_.fallback)
+	}
+}
+
+// SetFallback 没有注册处理函数的消息会交给 fallback，不设置时记录日志，rpc 请求回复 basepb.Base_Error
+func (this_ *Router) SetFallback(h HandlerFunc) {
+	if h == nil {
+		this_.fallback = nil
+		return
+	}
+	this_.fallback = this_.newHandler(h, false)
+}
+
+func (this_ *Router) SetOnConnected(f func(s *Session)) {
+	this_.onConnected = f
+}
+
+func (this_ *Router) SetOnDisConnected(f func(s *Session, err error)) {
+	this_.onDisConnected = f
+}
+
+func (this_ *Router) DoConcurrent(msgID string) bool {
+	h, ok := this_.handlers[msgID]
+	return ok && h.concurrent
+}
+
+func (this_ *Router) OnSessionConnected(s *Session) {
+	if this_.onConnected != nil {
+		this_.onConnected(s)
+	}
+}
+
+func (this_ *Router) OnSessionDisConnected(s *Session, err error) {
+	if this_.onDisConnected != nil {
+		this_.onDisConnected(s, err)
+	}
+}
+
+func (this_ *Router) OnRPCRequest(s *Session, msg proto.Message) proto.Message {
+	resp := this_.dispatch(s, msg, true)
+	if resp == nil {
+		return &basepb.Base_Success{}
+	}
+	return resp
+}
+
+func (this_ *Router) OnNormalMsg(s *Session, msg proto.Message) {
+	this_.dispatch(s, msg, false)
+}
+
+func (this_ *Router) dispatch(s *Session, msg proto.Message, isRPC bool) proto.Message {
+	v, ok := this_.handlers[messageName(msg)]
+	if !ok {
+		v = this_.fallback
+	}
+	if v == nil {
+		this_.log.Warn("router recv unknown msg", zap.String("msg name", messageName(msg)), zap.Any("msg", msg), zap.Bool("rpc", isRPC))
+		return &basepb.Base_Error{
+			ErrorCode:    1,
+			ErrorMessage: "unknown message " + messageName(msg),
+		}
+	}
+	return v.wrapped(s, msg)
+}
+
+// RecoverMiddleware 处理函数 panic 时记录日志，rpc 请求回复 basepb.Base_Error
+func RecoverMiddleware(log *logger.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg proto.Message) (resp proto.Message) {
+			defer func() {
+				if e := recover(); e != nil {
+					log.Error("router handler panic", zap.Any("panic info", e), zap.String("msg name", messageName(msg)), zap.Any("msg", msg))
+					resp = &basepb.Base_Error{
+						ErrorCode:    1,
+						ErrorMessage: "internal error",
+					}
+				}
+			}()
+			return next(s, msg)
+		}
+	}
+}
+
+// LogMiddleware 记录收到的消息、返回值和耗时
+func LogMiddleware(log *logger.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg proto.Message) proto.Message {
+			now := time.Now()
+			resp := next(s, msg)
+			fields := []zap.Field{zap.String("msg name", messageName(msg)), zap.Any("msg", msg), zap.Any("resp", resp), zap.Duration("cost", time.Since(now))}
+			if s != nil {
+				fields = append(fields, zap.String("session name", s.SessionName), zap.String("session id", s.SessionID), zap.String("remote", s.RemoteAddr()))
+			}
+			log.Debug("router handle msg", fields...)
+			return resp
+		}
+	}
+}
+
+// MetricsMiddleware 每处理完一个消息调用 report 上报耗时
+func MetricsMiddleware(report func(msgID string, cost time.Duration)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg proto.Message) proto.Message {
+			now := time.Now()
+			resp := next(s, msg)
+			report(messageName(msg), time.Since(now))
+			return resp
+		}
+	}
+}
+
+// AuthMiddleware check 返回 false 的消息不会交给处理函数，rpc 请求回复 basepb.Base_Error
+// allow 中的消息(比如登录)不做检查
+func AuthMiddleware(check func(s *Session, msg proto.Message) bool, allow ...proto.Message) Middleware {
+	allowed := map[string]struct{}{}
+	for _, v := range allow {
+		allowed[messageName(v)] = struct{}{}
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg proto.Message) proto.Message {
+			if _, ok := allowed[messageName(msg)]; !ok && !check(s, msg) {
+				return &basepb.Base_Error{
+					ErrorCode:    1,
+					ErrorMessage: "unauthorized",
+				}
+			}
+			return next(s, msg)
+		}
+	}
+}
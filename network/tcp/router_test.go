@@ -0,0 +1,68 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestRouter(t *testing.T) {
+	r := require.New(t)
+	router := NewRouter(newTestLogger(t))
+	var order []string
+	router.Use(func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg proto.Message) proto.Message {
+			order = append(order, "mw")
+			return next(s, msg)
+		}
+	})
+	router.Handle(&basepb.Base_Ping{}, func(s *Session, m *basepb.Base_Ping) proto.Message {
+		order = append(order, "ping")
+		return &basepb.Base_Pong{Now: "now"}
+	})
+	router.HandleConcurrent(&basepb.Base_Pong{}, func(s *Session, m *basepb.Base_Pong) {
+		order = append(order, "pong")
+	})
+	router.SetFallback(func(s *Session, msg proto.Message) proto.Message {
+		return &basepb.Base_Error{ErrorCode: 2}
+	})
+
+	resp := router.OnRPCRequest(nil, &basepb.Base_Ping{})
+	r.Equal("now", resp.(*basepb.Base_Pong).Now)
+	_, ok := router.OnRPCRequest(nil, &basepb.Base_Pong{}).(*basepb.Base_Success)
+	r.True(ok)
+	r.Equal([]string{"mw", "ping", "mw", "pong"}, order)
+
+	e, ok := router.OnRPCRequest(nil, &basepb.Base_Success{}).(*basepb.Base_Error)
+	r.True(ok)
+	r.Equal(int64(2), e.ErrorCode)
+
+	// 中间件在注册的时候包好，之后 Use 的中间件对已经注册的处理函数也生效
+	wraps := 0
+	router.Use(func(next HandlerFunc) HandlerFunc {
+		wraps++
+		return func(s *Session, msg proto.Message) proto.Message {
+			order = append(order, "mw2")
+			return next(s, msg)
+		}
+	})
+	r.Equal(3, wraps)
+	order = nil
+	router.OnNormalMsg(nil, &basepb.Base_Ping{})
+	router.OnNormalMsg(nil, &basepb.Base_Ping{})
+	r.Equal([]string{"mw", "mw2", "ping", "mw", "mw2", "ping"}, order)
+	r.Equal(3, wraps)
+
+	r.True(router.DoConcurrent(messageName(&basepb.Base_Pong{})))
+	r.False(router.DoConcurrent(messageName(&basepb.Base_Ping{})))
+
+	r.Panics(func() {
+		router.Handle(&basepb.Base_Success{}, func(s *Session, m *basepb.Base_Ping) {})
+	})
+	r.Panics(func() {
+		router.Handle(&basepb.Base_Ping{}, func(s *Session, m *basepb.Base_Ping) {})
+	})
+}
@@ -0,0 +1,104 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+var (
+	ErrSessionClosed = errors.New("session closed")
+	ErrRPCTimeout    = errors.New("rpc response timeout")
+)
+
+// RemoteError 对端返回的 basepb.Base_Error
+type RemoteError struct {
+	Code    int64
+	Message string
+	Fields  []string
+}
+
+func newRemoteError(e *basepb.Base_Error) *RemoteError {
+	return &RemoteError{
+		Code:    e.ErrorCode,
+		Message: e.ErrorMessage,
+		Fields:  e.Fields,
+	}
+}
+
+func (this_ *RemoteError) Error() string {
+	return this_.Message
+}
+
+// RequestContext 发送RPC请求并阻塞等待返回，会阻塞，别在queue或者逻辑线程里面用
+// ctx 没有设置超时时间时使用 session 的 rpcTimeout
+// 超时返回 ErrRPCTimeout，ctx 被取消返回 ctx.Err()，连接断开返回 ErrSessionClosed，对端返回 basepb.Base_Error 时返回 *RemoteError
+func (this_ *Session) RequestContext(ctx context.Context, msg proto.Message) (proto.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this_.rpcTimeout)
+		defer cancel()
+	}
+
+	c := make(chan proto.Message, 1)
+	index, err := this_.request(msg, func(m proto.Message) {
+		c <- m
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-c:
+		return rpcResult(resp)
+	case <-ctx.Done():
+		this_.deleteRPC(index)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrRPCTimeout
+		}
+		return nil, ctx.Err()
+	case <-this_.getCloseChan():
+		// 连接关闭之前已经收到的返回可能还在 queue 里，等 queue 处理到这里再判断
+		done := make(chan struct{})
+		this_.queue.Post(func() {
+			close(done)
+		})
+		select {
+		case resp := <-c:
+			return rpcResult(resp)
+		case <-done:
+		case <-ctx.Done():
+		}
+		if _, ok := this_.deleteRPC(index); !ok {
+			return rpcResult(<-c)
+		}
+		return nil, ErrSessionClosed
+	}
+}
+
+func rpcResult(resp proto.Message) (proto.Message, error) {
+	if e, ok := resp.(*basepb.Base_Error); ok {
+		return nil, newRemoteError(e)
+	}
+	return resp, nil
+}
+
+// SyncRequestContext 同 RequestContext，返回的消息会合并到 out 中，返回的消息类型和 out 不一致时返回错误
+func (this_ *Session) SyncRequestContext(ctx context.Context, msg proto.Message, out proto.Message) error {
+	resp, err := this_.RequestContext(ctx, msg)
+	if err != nil {
+		return err
+	}
+	outName := messageName(out)
+	name := messageName(resp)
+	if name != outName {
+		return fmt.Errorf("recv unknown message:%s,expected:%s", name, outName)
+	}
+	proto.Reset(out)
+	proto.Merge(out, resp)
+	return nil
+}
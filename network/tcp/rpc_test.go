@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+)
+
+// 已经启动的会话和直接读写的对端连接
+func newTestSessionPair(r *require.Assertions, log *logger.Logger, queue *eventqueue.EventQueue) (*Session, net.Conn) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	r.NoError(err)
+	defer ln.Close()
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	r.NoError(err)
+	s, err := ln.Accept()
+	r.NoError(err)
+	sess := NewSession(s, queue, true, &ProtoCodeC{}, log, time.Second, false)
+	sess.Dispatch = newChanDispatch()
+	return sess, c
+}
+
+func readTestPacket(r *require.Assertions, conn net.Conn) *Packet {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	head := make([]byte, headerLen)
+	_, err := io.ReadFull(conn, head)
+	r.NoError(err)
+	data := make([]byte, binary.LittleEndian.Uint32(head))
+	copy(data, head)
+	_, err = io.ReadFull(conn, data[headerLen:])
+	r.NoError(err)
+	p, _, err := (&Session{CodeC: &ProtoCodeC{}, maxMessageLen: defaultMaxMessageLen}).recvOne(data)
+	r.NoError(err)
+	return p
+}
+
+func writeTestPacket(r *require.Assertions, conn net.Conn, pp packetProtocol, rpcIndex uint32, msg proto.Message) {
+	body, err := proto.Marshal(msg)
+	r.NoError(err)
+	_, err = conn.Write(appendFrame(nil, pp, rpcIndex, messageName(msg), nil, body))
+	r.NoError(err)
+}
+
+func TestRequestContext(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := newRunningQueue(log)
+	sess, peer := newTestSessionPair(r, log, queue)
+	defer peer.Close()
+	sess.Start()
+	ctx := context.Background()
+
+	// 正常返回和对端返回的 Base_Error
+	go func() {
+		p := readTestPacket(r, peer)
+		writeTestPacket(r, peer, packetProtocolRPCResponse, p.RPCIndex, &basepb.Base_Success{})
+		p = readTestPacket(r, peer)
+		writeTestPacket(r, peer, packetProtocolRPCResponse, p.RPCIndex, &basepb.Base_Error{ErrorCode: 5, ErrorMessage: "bad"})
+	}()
+	out := &basepb.Base_Success{}
+	r.NoError(sess.SyncRequestContext(ctx, &basepb.Base_Ping{}, out))
+	_, err := sess.RequestContext(ctx, &basepb.Base_Ping{})
+	r.Equal(&RemoteError{Code: 5, Message: "bad"}, err)
+
+	// 对端不返回
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	_, err = sess.RequestContext(timeoutCtx, &basepb.Base_Ping{})
+	r.Equal(ErrRPCTimeout, err)
+	readTestPacket(r, peer)
+	r.Equal(0, sess.PendingRPC())
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(time.Millisecond*20, cancel)
+	_, err = sess.RequestContext(cancelCtx, &basepb.Base_Ping{})
+	r.Equal(context.Canceled, err)
+	readTestPacket(r, peer)
+
+	// 没有设置超时时使用 rpcTimeout
+	sess.rpcTimeout = time.Millisecond * 50
+	_, err = sess.RequestContext(ctx, &basepb.Base_Ping{})
+	r.Equal(ErrRPCTimeout, err)
+	readTestPacket(r, peer)
+
+	// 等待中连接断开
+	time.AfterFunc(time.Millisecond*20, func() {
+		sess.Close(io.EOF)
+	})
+	sess.rpcTimeout = time.Second * 3
+	_, err = sess.RequestContext(ctx, &basepb.Base_Ping{})
+	r.Equal(ErrSessionClosed, err)
+	r.Equal(0, sess.PendingRPC())
+	_, err = sess.RequestContext(ctx, &basepb.Base_Ping{})
+	r.Equal(ErrSessionClosed, err)
+}
+
+func TestRequestTimer(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := newRunningQueue(log)
+	sess, peer := newTestSessionPair(r, log, queue)
+	defer peer.Close()
+	sess.Start()
+	defer sess.Close(ErrSessionClosed)
+
+	// 收到返回之后停止超时定时器
+	c := make(chan proto.Message, 1)
+	index, err := sess.request(&basepb.Base_Ping{}, func(m proto.Message) { c <- m }, time.Second*3)
+	r.NoError(err)
+	v, ok := sess.rpcFunc.Load(index)
+	r.True(ok)
+	call := v.(*rpcCall)
+	r.True(call.timer.Active())
+	p := readTestPacket(r, peer)
+	writeTestPacket(r, peer, packetProtocolRPCResponse, p.RPCIndex, &basepb.Base_Success{})
+	r.IsType(&basepb.Base_Success{}, <-c)
+	r.False(call.timer.Active())
+
+	// 没有返回时回调 Base_Error
+	_, err = sess.request(&basepb.Base_Ping{}, func(m proto.Message) { c <- m }, time.Millisecond*50)
+	r.NoError(err)
+	readTestPacket(r, peer)
+	r.IsType(&basepb.Base_Error{}, <-c)
+	r.Equal(0, sess.PendingRPC())
+}
@@ -0,0 +1,155 @@
+package tcp
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSendQueueFull 发送缓存已满，消息没有发送
+var ErrSendQueueFull = errors.New("send queue full")
+
+// OverflowPolicy 发送缓存满了之后怎么处理新消息
+type OverflowPolicy int
+
+const (
+	// 丢弃新消息，Send 返回 ErrSendQueueFull
+	OverflowDropNewest OverflowPolicy = iota
+	// 丢弃缓存中最早的普通消息，握手、rpc、流、分片这些包不会丢弃，没有可以丢弃的消息时返回 ErrSendQueueFull
+	OverflowDropOldest
+	// 关闭连接，Send 返回 ErrSendQueueFull
+	OverflowClose
+	// 等待缓存有空间，超过 BlockTimeout 返回 ErrSendQueueFull，不要在 queue 中使用
+	OverflowBlock
+)
+
+// 默认等待1秒
+const defaultSendBlockTimeout = time.Second
+
+// SendQueueConfig 每个连接发送缓存的限制，都为0时不限制
+// 缓存为空时不管多大的消息都可以放进去
+type SendQueueConfig struct {
+	// 最多缓存多少字节
+	MaxBytes int
+	// 最多缓存多少个消息
+	MaxMessages int
+	Policy      OverflowPolicy
+	// OverflowBlock 等待的时间，为0时使用1秒
+	BlockTimeout time.Duration
+}
+
+func (this_ SendQueueConfig) limited() bool {
+	return this_.MaxBytes > 0 || this_.MaxMessages > 0
+}
+
+// SetSendQueue 设置发送缓存的限制，需要在 Start 之前调用
+func (this_ *Session) SetSendQueue(cfg SendQueueConfig) {
+	this_.sendQueue = cfg
+}
+
+// Pending 发送缓存中还没有写到连接上的消息数和字节数，可以用来跳过拥塞连接的不重要的广播
+func (this_ *Session) Pending() (messages int, bytes int) {
+	this_.sendCond.L.Lock()
+	defer this_.sendCond.L.Unlock()
+	return len(this_.sendBuf), this_.sendBufBytes
+}
+
+// 在 sendCond.L 中调用
+func (this_ *Session) sendQueueFits(n int) bool {
+	if len(this_.sendBuf) == 0 {
+		return true
+	}
+	cfg := this_.sendQueue
+	if cfg.MaxMessages > 0 && len(this_.sendBuf)+1 > cfg.MaxMessages {
+		return false
+	}
+	if cfg.MaxBytes > 0 && this_.sendBufBytes+n > cfg.MaxBytes {
+		return false
+	}
+	return true
+}
+
+// 在 sendCond.L 中调用，按照 OverflowPolicy 给 n 字节的消息腾出空间
+func (this_ *Session) waitSendQueue(n int) error {
+	if !this_.sendQueue.limited() || this_.sendQueueFits(n) {
+		return nil
+	}
+	switch this_.sendQueue.Policy {
+	case OverflowDropOldest:
+		for !this_.sendQueueFits(n) {
+			if !this_.dropOldest() {
+				return ErrSendQueueFull
+			}
+		}
+		return nil
+	case OverflowBlock:
+		timeout := this_.sendQueue.BlockTimeout
+		if timeout <= 0 {
+			timeout = defaultSendBlockTimeout
+		}
+		deadline := time.Now().Add(timeout)
+		timer := time.AfterFunc(timeout, func() {
+			this_.sendCond.L.Lock()
+			this_.spaceCond.Broadcast()
+			this_.sendCond.L.Unlock()
+		})
+		defer timer.Stop()
+		for !this_.sendQueueFits(n) {
+			if this_.isClose() && !this_.isDetached() {
+				return ErrSessionClosed
+			}
+			if !time.Now().Before(deadline) {
+				return ErrSendQueueFull
+			}
+			this_.spaceCond.Wait()
+		}
+		return nil
+	}
+	return ErrSendQueueFull
+}
+
+// 在 sendCond.L 中调用，丢弃最早的一个普通消息，没有可以丢弃的返回 false
+func (this_ *Session) dropOldest() bool {
+	for i, data := range this_.sendBuf {
+		if !droppable(data) {
+			continue
+		}
+		this_.sendBufBytes -= len(data)
+		copy(this_.sendBuf[i:], this_.sendBuf[i+1:])
+		this_.sendBuf[len(this_.sendBuf)-1] = nil
+		this_.sendBuf = this_.sendBuf[:len(this_.sendBuf)-1]
+		return true
+	}
+	return false
+}
+
+// 只有完整的普通消息可以丢弃，丢掉控制包对端的状态会出错
+func droppable(data []byte) bool {
+	pp := packetProtocol(data[totalLenLen+msgIDLenLen]) &^ (packetProtocolCompressFlag | packetProtocolNumericFlag)
+	return pp == packetProtocolNormal
+}
+
+// 在 sendCond.L 中调用
+func (this_ *Session) pushSendBuf(data []byte) {
+	this_.sendBuf = append(this_.sendBuf, data)
+	this_.sendBufBytes += len(data)
+}
+
+// 发送缓存已满时按照 OverflowClose 关闭连接，在 sendCond.L 之外调用
+func (this_ *Session) onSendQueueFull(err error) error {
+	if err == ErrSendQueueFull && this_.sendQueue.Policy == OverflowClose {
+		this_.Close(err)
+	}
+	return err
+}
+
+// SetSendQueue 设置每个连接发送缓存的限制，对之后接入的连接生效
+func (this_ *Acceptor) SetSendQueue(cfg SendQueueConfig) {
+	this_.sendQueue = cfg
+}
+
+// WithSendQueue 设置发送缓存的限制
+func WithSendQueue(cfg SendQueueConfig) Option {
+	return func(c *Connector) {
+		c.sendQueue = cfg
+	}
+}
@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+)
+
+// 没有启动收发协程的会话，发送的消息都留在发送缓存里
+func newQueuedSession(r *require.Assertions, log *logger.Logger, cfg SendQueueConfig) *Session {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	r.NoError(err)
+	defer ln.Close()
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	r.NoError(err)
+	s, err := ln.Accept()
+	r.NoError(err)
+	_ = c.Close()
+	sess := NewSession(s, eventqueue.NewEventQueue(0, log), true, &ProtoCodeC{}, log, time.Second, false)
+	sess.SetSendQueue(cfg)
+	return sess
+}
+
+func TestSendQueuePolicy(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+
+	sess := newQueuedSession(r, log, SendQueueConfig{MaxMessages: 2, Policy: OverflowDropNewest})
+	r.NoError(sess.SendBytes("a", []byte("1")))
+	r.NoError(sess.SendBytes("a", []byte("2")))
+	r.Equal(ErrSendQueueFull, sess.SendBytes("a", []byte("3")))
+	n, bytes := sess.Pending()
+	r.Equal(2, n)
+	r.Equal(2*(headerLen+2), bytes)
+
+	sess = newQueuedSession(r, log, SendQueueConfig{MaxMessages: 2, Policy: OverflowDropOldest})
+	for _, v := range []string{"1", "2", "3"} {
+		r.NoError(sess.SendBytes("a", []byte(v)))
+	}
+	n, _ = sess.Pending()
+	r.Equal(2, n)
+	r.Equal(byte('2'), sess.sendBuf[0][headerLen+1])
+
+	// 控制包不会被丢弃，跳过它们丢弃最早的普通消息
+	sess = newQueuedSession(r, log, SendQueueConfig{MaxMessages: 2, Policy: OverflowDropOldest})
+	r.NoError(sess.sendRawByte(packetProtocolHandshake, 0, messagePingName, nil))
+	r.NoError(sess.SendBytes("a", []byte("1")))
+	r.NoError(sess.SendBytes("a", []byte("2")))
+	r.Len(sess.sendBuf, 2)
+	r.False(droppable(sess.sendBuf[0]))
+	r.Equal(byte('2'), sess.sendBuf[1][headerLen+1])
+	r.NoError(sess.sendRawByte(packetProtocolRPCResponse, 1, "a", nil))
+	r.Equal(ErrSendQueueFull, sess.SendBytes("a", []byte("3")))
+	n, bytes = sess.Pending()
+	r.Equal(2, n)
+	r.Equal(2*headerLen+len(messagePingName)+1, bytes)
+
+	sess = newQueuedSession(r, log, SendQueueConfig{MaxBytes: 1, Policy: OverflowClose})
+	r.NoError(sess.SendBytes("a", []byte("1")))
+	r.Equal(ErrSendQueueFull, sess.SendBytes("a", []byte("2")))
+	r.True(sess.isClose())
+
+	sess = newQueuedSession(r, log, SendQueueConfig{MaxMessages: 1, Policy: OverflowBlock, BlockTimeout: time.Millisecond * 50})
+	r.NoError(sess.SendBytes("a", []byte("1")))
+	start := time.Now()
+	r.Equal(ErrSendQueueFull, sess.SendBytes("a", []byte("2")))
+	r.True(time.Since(start) >= time.Millisecond*50)
+
+	// 发送协程取走数据之后等待的消息可以放进去
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_, _ = sess.waitSendBuf(sess.gen)
+	}()
+	sess.sendQueue.BlockTimeout = time.Second
+	r.NoError(sess.SendBytes("a", []byte("3")))
+	n, _ = sess.Pending()
+	r.Equal(1, n)
+}
@@ -1,24 +1,29 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
-	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/attr"
 	"github.com/njmdk/common/network/basepb"
 	"github.com/njmdk/common/utils"
 )
 
+var _ attr.Holder = (*Session)(nil)
+
 type SessionInterface interface {
 	GetSession() *Session
 }
@@ -36,12 +41,22 @@ type Session struct {
 	closed   uint32
 	CodeC
 	sync.Once
-	conn         net.Conn
-	remoteAddr   string
-	localAddr    string
-	remoteIP     string
-	sendBuf      [][]byte
-	sendCond     *sync.Cond
+	// 连接上附带的数据，关闭事件分发之后清空
+	attr.Attributes
+	// 断线恢复时会换成新连接的，由 linkMu 保护，见 resume.go
+	linkMu     sync.RWMutex
+	conn       net.Conn
+	remoteAddr string
+	localAddr  string
+	remoteIP   string
+	tlsState   *tls.ConnectionState
+	closeChan  chan struct{}
+	sendBuf    [][]byte
+	sendCond   *sync.Cond
+	// 发送缓存的限制，见 sendqueue.go
+	sendBufBytes int
+	sendQueue    SendQueueConfig
+	spaceCond    *sync.Cond
 	queue        *eventqueue.EventQueue
 	onClose      func(error)
 	SessionName  string
@@ -50,13 +65,67 @@ type Session struct {
 	DoConcurrent DoConcurrentInterface
 	rpcTimeout   time.Duration
 	rpcFunc      *sync.Map
+	pendingRPC   int32
+	hookMu       sync.Mutex
+	closeHooks   []func(s *Session, err error)
 
 	logger *logger.Logger
 	// 采用哪种发送模式，0 默认模式，一个消息一个消息的发送出去，适用于客户端和服务器的连接
 	// 1 批量发送出去，但是每次最多发送65535个字节，适用于服务器和服务器之间的连接
-	sendFlag     int
+	sendFlag int
+	// 最近一次收到 Ping 或者 Pong 的时间，UnixNano
 	lastPongTime int64
 	isDebugLog   bool
+
+	// 分片重组后单个消息的最大长度，发送和接收都会检查
+	maxMessageLen int
+	// 只在 recvLoop 中使用
+	fragReader fragmentReader
+
+	// 本端和对端支持的能力，见 handshake.go
+	localCaps         uint32
+	peerCaps          uint32
+	peerRegistryHash  uint32
+	handshakeSent     uint32
+	compressThreshold int
+
+	// 正在发送中的数据和还没处理完的 rpc 请求，用于 Acceptor.Shutdown 判断连接是否可以关闭
+	sending   int32
+	inflight  int32
+	goingAway uint32
+	// 不为 nil 时发送缓存写完之后用它关闭连接，由 sendCond.L 保护，见 closeAfterFlush
+	flushCloseErr error
+
+	// 收消息的频率限制，见 limit.go
+	msgLimiter *tokenBucket
+
+	// 心跳，见 heartbeat.go
+	readIdleTimeout time.Duration
+	pingSentAt      int64
+	rtt             int64
+
+	// 流，见 stream.go
+	streamIndex   uint32
+	localStreams  sync.Map
+	remoteStreams sync.Map
+
+	// 断线恢复，见 resume.go
+	// gen 每次换连接加1，旧连接的收发协程发现不一致就退出
+	gen          uint32
+	detached     uint32
+	resume       *resumeState
+	resumeClient *resumeClient
+
+	// 连接认证，见 auth.go
+	auth *authState
+
+	// 编码切换，见 codec_switch.go
+	// 发送时持有 codecMu 的读锁，保证编码和放进发送缓存之间 CodeC 不会被切换
+	codecMu     sync.RWMutex
+	baseCodeC   CodeC
+	recvCodeC   CodeC
+	wantCodeC   string
+	allowCodeCs []string
 }
 
 func NewSession(conn net.Conn, queue *eventqueue.EventQueue, batchSend bool, codec CodeC, logger *logger.Logger, rpcTimeout time.Duration, isDebugLog bool) *Session {
@@ -71,18 +140,26 @@ func NewSession(conn net.Conn, queue *eventqueue.EventQueue, batchSend bool, cod
 		CodeC:        codec,
 		rpcTimeout:   rpcTimeout,
 		rpcFunc:      &sync.Map{},
+		closeChan:    make(chan struct{}),
 		logger:       logger,
-		lastPongTime: time.Now().Unix(),
+		lastPongTime: time.Now().UnixNano(),
 		isDebugLog:   isDebugLog,
+
+		maxMessageLen: defaultMaxMessageLen,
 	}
 
+	s.spaceCond = sync.NewCond(s.sendCond.L)
 	if batchSend {
 		s.sendFlag = 1
 	}
 
 	s.remoteAddr = conn.RemoteAddr().String()
 	s.localAddr = conn.LocalAddr().String()
-	s.remoteIP = conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	s.remoteIP = connIP(conn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		s.tlsState = &state
+	}
 	s.Dispatch = &DefaultLogDispatch{logger}
 	s.DoConcurrent = &DefaultDoConcurrent{}
 
@@ -98,7 +175,7 @@ func (this_ *Session) GetLogger() *logger.Logger {
 }
 
 func (this_ *Session) RemoteTcp4IP() string {
-	h, _, err := net.SplitHostPort(this_.remoteAddr)
+	h, _, err := net.SplitHostPort(this_.RemoteAddr())
 	if err != nil {
 		return ""
 	}
@@ -106,46 +183,111 @@ func (this_ *Session) RemoteTcp4IP() string {
 }
 
 func (this_ *Session) RemoteAddr() string {
+	this_.linkMu.RLock()
+	defer this_.linkMu.RUnlock()
 	return this_.remoteAddr
 }
 
 func (this_ *Session) LocalAddr() string {
+	this_.linkMu.RLock()
+	defer this_.linkMu.RUnlock()
 	return this_.localAddr
 }
 
+func (this_ *Session) getConn() net.Conn {
+	this_.linkMu.RLock()
+	defer this_.linkMu.RUnlock()
+	return this_.conn
+}
+
+func (this_ *Session) getCloseChan() chan struct{} {
+	this_.linkMu.RLock()
+	defer this_.linkMu.RUnlock()
+	return this_.closeChan
+}
+
+func (this_ *Session) getTLSState() *tls.ConnectionState {
+	this_.linkMu.RLock()
+	defer this_.linkMu.RUnlock()
+	return this_.tlsState
+}
+
+func (this_ *Session) IsTLS() bool {
+	return this_.getTLSState() != nil
+}
+
+// TLSConnectionState 返回 tls 连接状态，非 tls 连接返回 false
+func (this_ *Session) TLSConnectionState() (tls.ConnectionState, bool) {
+	state := this_.getTLSState()
+	if state == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *state, true
+}
+
+// PeerCertificates 对端出示的证书链，第一个为对端自身的证书
+// 服务端只有在开启了客户端证书校验时才能拿到
+func (this_ *Session) PeerCertificates() []*x509.Certificate {
+	state := this_.getTLSState()
+	if state == nil {
+		return nil
+	}
+	return state.PeerCertificates
+}
+
+// PeerCommonName 对端证书的 Subject.CommonName，没有证书时返回空字符串
+// 可以在 DispatchInterface 中根据它做鉴权
+func (this_ *Session) PeerCommonName() string {
+	certs := this_.PeerCertificates()
+	if len(certs) == 0 {
+		return ""
+	}
+	return certs[0].Subject.CommonName
+}
+
 func (this_ *Session) Start() {
-	this_.recvLoop()
+	this_.baseCodeC = this_.CodeC
+	this_.recvCodeC = this_.CodeC
+	this_.start(nil)
+}
+
+// pending 为换连接之前已经从新连接读到但是还没处理的数据
+func (this_ *Session) start(pending []byte) {
+	gen := atomic.LoadUint32(&this_.gen)
+	conn := this_.getConn()
+	this_.recvLoop(gen, conn, pending)
 
 	if this_.sendFlag == 0 {
-		this_.sendLoop()
+		this_.sendLoop(gen, conn)
 	} else {
-		this_.sendLoopBatch()
+		this_.sendLoopBatch(gen, conn)
 	}
 }
 
-func (this_ *Session) response(rpcIndex uint32, msg proto.Message) {
+// 收发协程是否应该退出
+func (this_ *Session) loopDone(gen uint32) bool {
+	return this_.isClose() || atomic.LoadUint32(&this_.gen) != gen
+}
 
-	v, ok := this_.rpcFunc.Load(rpcIndex)
-	if ok {
-		this_.rpcFunc.Delete(rpcIndex)
+// 只关闭当前连接，已经换了连接的旧协程不能关闭会话
+func (this_ *Session) closeGen(gen uint32, err error) {
+	if atomic.LoadUint32(&this_.gen) == gen {
+		this_.Close(err)
+	}
+}
 
-		if f, ok := v.(RPCResponse); ok {
-			if this_.isDebugLog {
-				this_.logger.Debug("session recv response", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-					zap.Uint32("rpcIndex", rpcIndex), zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg))
-			}
-			f(msg)
-		} else {
-			if this_.isDebugLog {
-				this_.logger.Debug("session recv response,but callback type is not RPCResponse", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-					zap.Uint32("rpcIndex", rpcIndex), zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg),
-					zap.String("callback type", reflect.TypeOf(f).Name()))
-			}
+func (this_ *Session) response(rpcIndex uint32, msg proto.Message) {
+	call, ok := this_.deleteRPC(rpcIndex)
+	if ok {
+		if this_.isDebugLog {
+			this_.logger.Debug("session recv response", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
+				zap.Uint32("rpcIndex", rpcIndex), zap.String("msg name", messageName(msg)), zap.Any("msg", msg))
 		}
+		call.resp(msg)
 	} else {
 		if this_.isDebugLog {
 			this_.logger.Debug("session recv response,but not found callback", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-				zap.Uint32("rpcIndex", rpcIndex), zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg))
+				zap.Uint32("rpcIndex", rpcIndex), zap.String("msg name", messageName(msg)), zap.Any("msg", msg))
 		}
 	}
 }
@@ -169,13 +311,13 @@ func (this_ *Session) SendBytesNoError(msgID string, msg []byte) {
 }
 
 func (this_ *Session) Send(msg proto.Message) error {
-	if this_.isClose() {
-		return errors.New("session closed")
+	if !this_.canSend() {
+		return ErrSessionClosed
 	}
 	err := this_.sendRaw(packetProtocolNormal, 0, msg)
 	if err == nil && this_.isDebugLog {
-		this_.logger.Debug("send normal msg success", zap.String("remote", this_.remoteAddr), zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-			zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg))
+		this_.logger.Debug("send normal msg success", zap.String("remote", this_.RemoteAddr()), zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
+			zap.String("msg name", messageName(msg)), zap.Any("msg", msg))
 	}
 	return err
 }
@@ -183,36 +325,67 @@ func (this_ *Session) Send(msg proto.Message) error {
 type RPCResponse func(m proto.Message)
 
 func (this_ *Session) Request(msg proto.Message, resp RPCResponse) error {
+	_, err := this_.request(msg, resp, this_.rpcTimeout)
+	return err
+}
+
+// 等待返回的 rpc 回调和它的超时定时器
+type rpcCall struct {
+	resp  RPCResponse
+	timer eventqueue.TimerID
+}
+
+// timeout <= 0 时不设置超时，由调用者自己负责清理 rpcFunc
+func (this_ *Session) request(msg proto.Message, resp RPCResponse, timeout time.Duration) (uint32, error) {
 	index := this_.getRPCRequestIndex()
 
-	err := this_.sendRaw(packetProtocolRPCRequest, index, msg)
-	if err != nil {
-		return err
-	}
-	if this_.isDebugLog {
-		this_.logger.Debug("send request msg success", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-			zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg), zap.Uint32("index", index))
-	}
-	this_.rpcFunc.Store(index, resp)
-	this_.queue.AfterFunc(this_.rpcTimeout, func(_ time.Time) {
-		value, ok := this_.rpcFunc.Load(index)
-		if ok {
-			this_.rpcFunc.Delete(index)
-			switch f := value.(type) {
-			case RPCResponse:
+	call := &rpcCall{resp: resp}
+	if timeout > 0 {
+		call.timer = this_.queue.AfterFunc(timeout, func(_ time.Time) {
+			if call, ok := this_.deleteRPC(index); ok {
 				this_.logger.Error("recv response msg timeout", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-					zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg), zap.Uint32("index", index))
-				f(&basepb.Base_Error{
+					zap.String("msg name", messageName(msg)), zap.Any("msg", msg), zap.Uint32("index", index))
+				call.resp(&basepb.Base_Error{
 					ErrorCode:    1,
 					ErrorMessage: "RPCResponse Timeout",
 				})
-			default:
-				this_.logger.Warn("unknown type", zap.Any("response timeout", value))
 			}
-		}
-	})
+		})
+	}
+	// 先保存回调，避免发送之后返回的太快找不到回调
+	this_.storeRPC(index, call)
+	err := this_.sendRaw(packetProtocolRPCRequest, index, msg)
+	if err != nil {
+		this_.deleteRPC(index)
+		return 0, err
+	}
+	if this_.isDebugLog {
+		this_.logger.Debug("send request msg success", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
+			zap.String("msg name", messageName(msg)), zap.Any("msg", msg), zap.Uint32("index", index))
+	}
+	return index, nil
+}
 
-	return nil
+func (this_ *Session) storeRPC(index uint32, call *rpcCall) {
+	atomic.AddInt32(&this_.pendingRPC, 1)
+	this_.rpcFunc.Store(index, call)
+}
+
+// 删除回调的同时停止超时定时器
+func (this_ *Session) deleteRPC(index uint32) (*rpcCall, bool) {
+	v, ok := this_.rpcFunc.LoadAndDelete(index)
+	if !ok {
+		return nil, false
+	}
+	atomic.AddInt32(&this_.pendingRPC, -1)
+	call := v.(*rpcCall)
+	call.timer.Cancel()
+	return call, true
+}
+
+// PendingRPC 已经发出还没有收到返回的 rpc 请求数
+func (this_ *Session) PendingRPC() int {
+	return int(atomic.LoadInt32(&this_.pendingRPC))
 }
 
 func (this_ *Session) RequestNoError(msg proto.Message, resp RPCResponse) {
@@ -224,7 +397,7 @@ func (this_ *Session) RequestNoError(msg proto.Message, resp RPCResponse) {
 		})
 		this_.logger.Error("request msg error", zap.Error(err), zap.String("session name", this_.SessionName),
 			zap.String("session id", this_.SessionID),
-			zap.Any("msg name", proto.MessageName(msg)), zap.Any("msg", msg))
+			zap.Any("msg name", messageName(msg)), zap.Any("msg", msg))
 		this_.Close(err)
 	}
 }
@@ -233,11 +406,11 @@ func (this_ *Session) sendResponse(rpcIndex uint32, msg proto.Message) error {
 	err := this_.sendRaw(packetProtocolRPCResponse, rpcIndex, msg)
 	if err != nil {
 		this_.logger.Error("send response msg failed", zap.Error(err), zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-			zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg), zap.Uint32("rpcIndex", rpcIndex))
+			zap.String("msg name", messageName(msg)), zap.Any("msg", msg), zap.Uint32("rpcIndex", rpcIndex))
 	} else {
 		if this_.isDebugLog {
 			this_.logger.Debug("send response msg success", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
-				zap.String("msg name", proto.MessageName(msg)), zap.Any("msg", msg), zap.Uint32("rpcIndex", rpcIndex))
+				zap.String("msg name", messageName(msg)), zap.Any("msg", msg), zap.Uint32("rpcIndex", rpcIndex))
 		}
 	}
 	return err
@@ -247,16 +420,23 @@ func (this_ *Session) isClose() bool {
 	return atomic.LoadUint32(&this_.closed) == 1
 }
 
+// 断线等待恢复期间普通消息还可以发送，会先放进重发缓存
+func (this_ *Session) canSend() bool {
+	return !this_.isClose() || this_.isDetached()
+}
+
 func (this_ *Session) sendRaw(pp packetProtocol, rpcIndex uint32, msg proto.Message) error {
-	if this_.isClose() {
-		return errors.New("session closed")
+	if !this_.canSend() {
+		return ErrSessionClosed
 	}
 
-	msgID := proto.MessageName(msg)
+	msgID := messageName(msg)
 	if msgID == "" {
 		return errors.New("unknown msg,because MessageName is '' ")
 	}
 
+	this_.codecMu.RLock()
+	defer this_.codecMu.RUnlock()
 	bodyData, err := this_.CodeC.Encode(msg)
 	if err != nil {
 		return err
@@ -266,80 +446,123 @@ func (this_ *Session) sendRaw(pp packetProtocol, rpcIndex uint32, msg proto.Mess
 }
 
 func (this_ *Session) sendRawByte(pp packetProtocol, rpcIndex uint32, msgID string, bodyData []byte) error {
-	if this_.isClose() {
-		return errors.New("session closed")
+	replay := this_.replayable(pp, msgID)
+	if this_.isClose() && !(replay && this_.isDetached()) {
+		return ErrSessionClosed
 	}
 
-	var data []byte
-
-	msgIDLen := len(msgID)
-	bodyLen := len(bodyData)
+	pp, bodyData = this_.compress(pp, bodyData)
+	pp, msgID = this_.numericMsgID(pp, msgID)
 
-	totalLen := bodyLen + headerLen + msgIDLen
-	if totalLen >= maxPacketLen {
-		return errors.New("package too large")
+	if replay {
+		return this_.sendReplay(pp, msgID, bodyData)
 	}
 
-	data = make([]byte, totalLen)
-	binary.LittleEndian.PutUint32(data[:totalLenLen], uint32(totalLen))
-	data[totalLenLen] = uint8(msgIDLen)
-	data[totalLenLen+msgIDLenLen] = uint8(pp)
-	binary.LittleEndian.PutUint32(data[totalLenLen+msgIDLenLen+packetProtocolLen:], rpcIndex)
-	copy(data[headerLen:headerLen+msgIDLen], msgID)
-	copy(data[headerLen+msgIDLen:], bodyData)
+	data, err := this_.frame(pp, rpcIndex, msgID, bodyData)
+	if err != nil {
+		return err
+	}
 
 	this_.sendCond.L.Lock()
-	this_.sendBuf = append(this_.sendBuf, data)
+	err = this_.waitSendQueue(len(data))
+	if err == nil {
+		this_.pushSendBuf(data)
+	}
 	this_.sendCond.L.Unlock()
+	if err != nil {
+		return this_.onSendQueueFull(err)
+	}
 	this_.sendCond.Signal()
 
 	return nil
 }
 
-func (this_ *Session) writeTimeout(duration time.Duration, data []byte) (int, error) {
-	_ = this_.conn.SetWriteDeadline(time.Now().Add(duration))
-	n, err := this_.conn.Write(data)
-	_ = this_.conn.SetWriteDeadline(time.Time{})
+// 组包，超过 maxPacketLen 的消息分片
+func (this_ *Session) frame(pp packetProtocol, rpcIndex uint32, msgID string, bodyData []byte) ([]byte, error) {
+	totalLen := len(bodyData) + headerLen + len(msgID)
+	if totalLen >= maxPacketLen {
+		return this_.fragment(pp, rpcIndex, msgID, bodyData)
+	}
+	return appendFrame(make([]byte, 0, totalLen), pp, rpcIndex, msgID, nil, bodyData), nil
+}
+
+func appendFrame(dst []byte, pp packetProtocol, rpcIndex uint32, msgID string, head []byte, body []byte) []byte {
+	var header [headerLen]byte
+	totalLen := headerLen + len(msgID) + len(head) + len(body)
+	binary.LittleEndian.PutUint32(header[:totalLenLen], uint32(totalLen))
+	header[totalLenLen] = uint8(len(msgID))
+	header[totalLenLen+msgIDLenLen] = uint8(pp)
+	binary.LittleEndian.PutUint32(header[totalLenLen+msgIDLenLen+packetProtocolLen:], rpcIndex)
+	dst = append(dst, header[:]...)
+	dst = append(dst, msgID...)
+	dst = append(dst, head...)
+	return append(dst, body...)
+}
+
+func writeTimeout(conn net.Conn, duration time.Duration, data []byte) (int, error) {
+	_ = conn.SetWriteDeadline(time.Now().Add(duration))
+	n, err := conn.Write(data)
+	_ = conn.SetWriteDeadline(time.Time{})
 
 	return n, err
 }
 
-func (this_ *Session) recvLoop() {
+func (this_ *Session) recvLoop(gen uint32, conn net.Conn, pending []byte) {
 	utils.SafeGO(func(e interface{}) {
-		this_.Close(fmt.Errorf("%+v", e))
+		this_.closeGen(gen, fmt.Errorf("%+v", e))
 	}, func() {
 		if this_.isDebugLog {
 			defer this_.logger.Debug("recvLoop closed", zap.String("RemoteAddr", this_.RemoteAddr()), zap.String("LocalAddr", this_.LocalAddr()))
 		}
 		readData := make([]byte, maxPacketLen)
-		readStart := 0
-		for !this_.isClose() {
-			n, err := this_.conn.Read(readData[readStart:])
-			if err != nil {
-				this_.Close(err)
-				return
-			}
-			readStart += n
+		readStart := copy(readData, pending)
+		for {
 			gotData := readData[:readStart]
 			pkgCount := 0
 			for {
 				p, l, err := this_.recvOne(gotData)
 				if err != nil {
-					this_.Close(err)
+					this_.closeGen(gen, err)
 					return
 				}
+				if l == 0 {
+					break
+				}
+				pkgCount++
+				gotData = gotData[l:]
 				if p != nil {
-					pkgCount++
+					if p.Protocol == packetProtocolResume {
+						// 恢复成功之后这个连接交给原来的会话，剩下的数据也由它处理
+						if this_.resume.acceptor.resumeSession(this_, p, gotData) {
+							return
+						}
+						continue
+					}
+					if !this_.msgLimiter.allow() {
+						this_.closeGen(gen, &LimitError{Reason: LimitReasonMsgRate})
+						return
+					}
+					if p.Protocol == packetProtocolRPCRequest {
+						atomic.AddInt32(&this_.inflight, 1)
+					}
 					this_.queue.Post(p)
-					gotData = gotData[l:]
-				} else {
-					break
 				}
 			}
 			if pkgCount > 0 {
 				copy(readData, gotData)
 			}
 			readStart = len(gotData)
+
+			if this_.loopDone(gen) {
+				return
+			}
+			this_.setReadDeadline(conn)
+			n, err := conn.Read(readData[readStart:])
+			if err != nil {
+				this_.closeGen(gen, this_.readError(err))
+				return
+			}
+			readStart += n
 		}
 	})
 }
@@ -379,56 +602,121 @@ func (this_ *Session) recvOne(gotData []byte) (*Packet, int, error) {
 		return nil, 0, fmt.Errorf("invalid msgID %s", msgID)
 	}
 
-	t := proto.MessageType(msgID)
+	body := gotData[msgIDLen+headerLen : msgLen]
+	switch pp {
+	case packetProtocolHandshake:
+		return nil, msgLen, this_.onHandshake(rpcIndex)
+	case packetProtocolRegistryHash:
+		this_.onRegistryHash(rpcIndex)
+		return nil, msgLen, nil
+	case packetProtocolCodeC:
+		return nil, msgLen, this_.onCodeC(rpcIndex, string(body))
+	case packetProtocolResume, packetProtocolResumeAck, packetProtocolResumeToken:
+		return this_.onResumePacket(pp, rpcIndex, msgID, body), msgLen, nil
+	}
+	if this_.resume != nil {
+		this_.resume.acceptor.announceOnce(this_)
+	}
+	if pp.isControl() {
+		data := make([]byte, len(body))
+		copy(data, body)
+		return &Packet{MsgID: msgID, Sess: this_, Protocol: pp, RPCIndex: rpcIndex, data: data}, msgLen, nil
+	}
+	if pp == packetProtocolFragment {
+		var done bool
+		var err error
+		pp, body, done, err = this_.onFragment(msgID, rpcIndex, body)
+		if err != nil {
+			return nil, 0, err
+		}
+		// 分片还没收齐，只消耗掉这个包
+		if !done {
+			return nil, msgLen, nil
+		}
+	}
+
+	pp, body, err := this_.decompress(pp, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	pp, msgID, err = this_.resolveMsgID(pp, msgID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msg, err := this_.decodeMsg(msgID, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = this_.checkStreamPacket(pp, rpcIndex); err != nil {
+		return nil, 0, err
+	}
+	if pp == packetProtocolNormal && rpcIndex != 0 && this_.resumeClient != nil {
+		this_.resumeClient.received(this_, rpcIndex)
+	}
+	return &Packet{MsgID: msgID, Sess: this_, Msg: msg, Protocol: pp, RPCIndex: rpcIndex}, msgLen, nil
+}
+
+func (this_ *Session) decodeMsg(msgID string, body []byte) (proto.Message, error) {
+	t := lookupMsgType(msgID)
 	if t == nil {
-		return nil, 0, fmt.Errorf("invalid msgID %s,can`t found type", msgID)
+		t = findMessageType(msgID)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("invalid msgID %s,can`t found type", msgID)
 	}
 
-	msg := reflect.New(t.Elem()).Interface().(proto.Message)
+	msg := t.New().Interface()
 
-	if bodyLen > msgIDLen {
-		//body := make([]byte, bodyLen-(msgIDLen+headerLen))
-		body := gotData[msgIDLen+headerLen : msgLen]
-		err := this_.CodeC.Decode(body, msg)
+	if len(body) > 0 {
+		err := this_.decodeC().Decode(body, msg)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 	}
-	return &Packet{MsgID: msgID, Sess: this_, Msg: msg, Protocol: pp, RPCIndex: rpcIndex}, msgLen, nil
+	return msg, nil
 }
 
-func (this_ *Session) sendLoopBatch() {
+func (this_ *Session) sendLoopBatch(gen uint32, conn net.Conn) {
 	utils.SafeGO(func(e interface{}) {
-		this_.Close(fmt.Errorf("%+v", e))
+		this_.closeGen(gen, fmt.Errorf("%+v", e))
 	}, func() {
 		sendCacheBuf := make([]byte, maxPacketLen)
 		sendCacheBufLen := 0
 		if this_.isDebugLog {
 			defer this_.logger.Debug("sendLoop closed", zap.String("RemoteAddr", this_.RemoteAddr()), zap.String("LocalAddr", this_.LocalAddr()))
 		}
-		for !this_.isClose() {
-			this_.sendCond.L.Lock()
-			for len(this_.sendBuf) == 0 {
-				this_.sendCond.Wait()
-				if this_.isClose() {
-					return
-				}
+		for {
+			writeBuf, ok := this_.waitSendBuf(gen)
+			if !ok {
+				return
 			}
 
-			writeBuf := this_.sendBuf
-			this_.sendBuf = nil
-			this_.sendCond.L.Unlock()
-
 			lenWriteBuf := len(writeBuf)
 			if lenWriteBuf == 1 {
-				err := this_.sendOneBuf(writeBuf[0])
+				err := this_.sendOneBuf(gen, conn, writeBuf[0])
 				if err != nil {
 					return
 				}
 			} else {
 				for i := 0; i < lenWriteBuf; i++ {
+					// 分片之后的大消息放不进缓存，先把缓存发出去，再直接发送
+					if len(writeBuf[i]) > maxPacketLen {
+						if sendCacheBufLen > 0 {
+							err := this_.sendOneBuf(gen, conn, sendCacheBuf[:sendCacheBufLen])
+							if err != nil {
+								return
+							}
+							sendCacheBufLen = 0
+						}
+						err := this_.sendOneBuf(gen, conn, writeBuf[i])
+						if err != nil {
+							return
+						}
+						continue
+					}
 					if len(writeBuf[i])+sendCacheBufLen > maxPacketLen {
-						err := this_.sendOneBuf(sendCacheBuf[:sendCacheBufLen])
+						err := this_.sendOneBuf(gen, conn, sendCacheBuf[:sendCacheBufLen])
 						if err != nil {
 							return
 						}
@@ -443,7 +731,7 @@ func (this_ *Session) sendLoopBatch() {
 				}
 
 				if sendCacheBufLen > 0 {
-					err := this_.sendOneBuf(sendCacheBuf[:sendCacheBufLen])
+					err := this_.sendOneBuf(gen, conn, sendCacheBuf[:sendCacheBufLen])
 					if err != nil {
 						return
 					}
@@ -454,11 +742,53 @@ func (this_ *Session) sendLoopBatch() {
 	})
 }
 
-func (this_ *Session) sendOneBuf(b []byte) error {
+// 等待发送缓存里有数据，会话关闭或者换了连接时返回 false
+func (this_ *Session) waitSendBuf(gen uint32) ([][]byte, bool) {
+	atomic.StoreInt32(&this_.sending, 0)
+	// Close 也要拿 sendCond.L，解锁之后再关闭
+	var flushErr error
+	defer func() {
+		if flushErr != nil {
+			this_.closeGen(gen, flushErr)
+		}
+	}()
+	this_.sendCond.L.Lock()
+	defer this_.sendCond.L.Unlock()
+	for len(this_.sendBuf) == 0 && !this_.loopDone(gen) && this_.flushCloseErr == nil {
+		this_.sendCond.Wait()
+	}
+	if this_.loopDone(gen) {
+		return nil, false
+	}
+	if len(this_.sendBuf) == 0 {
+		flushErr = this_.flushCloseErr
+		return nil, false
+	}
+
+	writeBuf := this_.sendBuf
+	this_.sendBuf = nil
+	this_.sendBufBytes = 0
+	atomic.StoreInt32(&this_.sending, 1)
+	this_.spaceCond.Broadcast()
+	return writeBuf, true
+}
+
+// 发送协程写完发送缓存之后关闭连接，timeout 之后还没写完直接关闭
+func (this_ *Session) closeAfterFlush(err error, timeout time.Duration) {
+	this_.sendCond.L.Lock()
+	this_.flushCloseErr = err
+	this_.sendCond.L.Unlock()
+	this_.sendCond.Broadcast()
+	this_.queue.AfterFunc(timeout, func(time.Time) {
+		this_.Close(err)
+	})
+}
+
+func (this_ *Session) sendOneBuf(gen uint32, conn net.Conn, b []byte) error {
 	for len(b) > 0 {
-		n, err := this_.writeTimeout(time.Second*2, b)
+		n, err := writeTimeout(conn, time.Second*2, b)
 		if err != nil {
-			this_.Close(err)
+			this_.closeGen(gen, err)
 			return err
 		}
 
@@ -472,36 +802,19 @@ func (this_ *Session) sendOneBuf(b []byte) error {
 	return nil
 }
 
-func (this_ *Session) sendLoop() {
+func (this_ *Session) sendLoop(gen uint32, conn net.Conn) {
 	utils.SafeGO(func(e interface{}) {
-		this_.Close(fmt.Errorf("%+v", e))
+		this_.closeGen(gen, fmt.Errorf("%+v", e))
 	}, func() {
 		defer this_.logger.Debug("sendLoop closed", zap.String("RemoteAddr", this_.RemoteAddr()), zap.String("LocalAddr", this_.LocalAddr()))
-		for !this_.isClose() {
-			this_.sendCond.L.Lock()
-			for len(this_.sendBuf) == 0 {
-				this_.sendCond.Wait()
-				if this_.isClose() {
-					return
-				}
+		for {
+			writeBuf, ok := this_.waitSendBuf(gen)
+			if !ok {
+				return
 			}
-
-			writeBuf := this_.sendBuf
-			this_.sendBuf = nil
-			this_.sendCond.L.Unlock()
-			lenWriteBuf := len(writeBuf)
-			for i := 0; i < lenWriteBuf; i++ {
-				item := writeBuf[i]
-				for len(item) > 0 {
-					n, err := this_.writeTimeout(time.Second*2, item)
-					if err != nil {
-						this_.Close(err)
-						return
-					}
-					if n == len(item) {
-						break
-					}
-					item = item[n:]
+			for _, item := range writeBuf {
+				if err := this_.sendOneBuf(gen, conn, item); err != nil {
+					return
 				}
 			}
 		}
@@ -509,49 +822,69 @@ func (this_ *Session) sendLoop() {
 }
 
 func (this_ *Session) Close(err error) {
-	this_.Do(func() {
-		atomic.StoreUint32(&this_.closed, 1)
-		_ = this_.conn.Close()
-		this_.sendCond.Signal()
-		if this_.onClose != nil {
-			this_.onClose(err)
-			this_.onClose = nil
+	this_.sendCond.L.Lock()
+	if this_.isClose() {
+		this_.sendCond.L.Unlock()
+		// 已经断线等待恢复的会话被主动关闭，比如踢下线，不再等客户端回来
+		if this_.isDetached() && !isLinkError(err) {
+			this_.expire(err)
 		}
-	})
+		return
+	}
+	// 可以恢复的会话断线之后先保留，等客户端重连
+	detach := this_.canDetach(err)
+	if detach {
+		atomic.StoreUint32(&this_.detached, 1)
+	}
+	atomic.StoreUint32(&this_.closed, 1)
+	// rebind 在 sendCond.L 里面换连接，这里拿到的是要关闭的连接
+	closeChan := this_.closeChan
+	conn := this_.conn
+	this_.sendCond.L.Unlock()
+
+	close(closeChan)
+	_ = conn.Close()
+	this_.sendCond.Broadcast()
+	this_.spaceCond.Broadcast()
+	if detach {
+		this_.resume.acceptor.onDetach(this_, err)
+		return
+	}
+	this_.finalClose(err)
 }
 
-var syncWaitRPCResponsePool = sync.Pool{
-	New: func() interface{} {
-		return make(chan proto.Message, 1)
-	},
+func (this_ *Session) finalClose(err error) {
+	if this_.onClose != nil {
+		this_.onClose(err)
+		this_.onClose = nil
+	}
+	this_.hookMu.Lock()
+	hooks := this_.closeHooks
+	this_.closeHooks = nil
+	this_.hookMu.Unlock()
+	for _, f := range hooks {
+		f(this_, err)
+	}
+}
+
+// AddCloseHook 连接关闭时调用 f，在关闭连接的协程中执行，已经关闭时返回 false
+func (this_ *Session) AddCloseHook(f func(s *Session, err error)) bool {
+	this_.hookMu.Lock()
+	defer this_.hookMu.Unlock()
+	if !this_.canSend() {
+		return false
+	}
+	this_.closeHooks = append(this_.closeHooks, f)
+	return true
 }
 
-var basepbErrorName = proto.MessageName(&basepb.Base_Error{})
+var basepbErrorName = messageName(&basepb.Base_Error{})
 
 // 用于异步RPC同步等待，别在queue或者逻辑线程里面用，只适合http逻辑里面调用，会阻塞
+// 等待时间为 session 的 rpcTimeout
 func SyncRequest(s *Session, msg proto.Message, out proto.Message) error {
 	if s == nil {
 		return errors.New("session is nil")
 	}
-	c := syncWaitRPCResponsePool.Get().(chan proto.Message)
-	defer syncWaitRPCResponsePool.Put(c)
-	err := s.Request(msg, func(m proto.Message) {
-		c <- m
-	})
-	if err != nil {
-		return err
-	}
-	resp := <-c
-	outName := proto.MessageName(out)
-	name := proto.MessageName(resp)
-	switch name {
-	case basepbErrorName:
-		o := resp.(*basepb.Base_Error)
-		return errors.New(o.ErrorMessage)
-	case outName:
-		out = resp
-		return nil
-	default:
-		return fmt.Errorf("recv unknown message:%s,expected:%s", name, outName)
-	}
+	return s.SyncRequestContext(context.Background(), msg, out)
 }
@@ -2,44 +2,84 @@ package tcp
 
 import (
 	"reflect"
+	"sync"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/logger"
 )
 
+// SessionMap 按 SessionID 保存连接，可以在任意协程中使用
+// 连接可以加入多个分组(房间、公会等)，连接关闭时会自动退出所有分组
 type SessionMap struct {
-	m   map[string]*Session
-	log *logger.Logger
+	mu     sync.RWMutex
+	m      map[string]*Session
+	groups map[string]map[*Session]struct{}
+	// 每个连接加入的分组
+	joined map[*Session]map[string]struct{}
+	log    *logger.Logger
 }
 
 func NewSessionMap(log *logger.Logger) *SessionMap {
 	return &SessionMap{
-		m:   map[string]*Session{},
-		log: log,
+		m:      map[string]*Session{},
+		groups: map[string]map[*Session]struct{}{},
+		joined: map[*Session]map[string]struct{}{},
+		log:    log,
 	}
 }
 
 func (this_ *SessionMap) GetSession(sessionID string) (*Session, bool) {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
 	v, ok := this_.m[sessionID]
 	return v, ok
 }
 
+func (this_ *SessionMap) Len() int {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	return len(this_.m)
+}
+
+// Range 遍历所有连接，f 返回 false 时停止，f 中不能再调用 SessionMap 的修改方法
+func (this_ *SessionMap) Range(f func(s *Session) bool) {
+	for _, v := range this_.all() {
+		if !f(v) {
+			return
+		}
+	}
+}
+
+func (this_ *SessionMap) all() []*Session {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	sessions := make([]*Session, 0, len(this_.m))
+	for _, v := range this_.m {
+		sessions = append(sessions, v)
+	}
+	return sessions
+}
+
 func (this_ *SessionMap) SendBySessionID(sessionID string, msg proto.Message) {
-	if v, ok := this_.m[sessionID]; ok {
+	if v, ok := this_.GetSession(sessionID); ok {
 		v.SendNoError(msg)
 	} else {
-		this_.log.Error("no session found", zap.String("sessionID", sessionID), zap.String("msg name", proto.MessageName(msg)),
+		this_.log.Error("no session found", zap.String("sessionID", sessionID), zap.String("msg name", messageName(msg)),
 			zap.Any("msg", msg))
 	}
 }
 
 func (this_ *SessionMap) AddSession(s *Session) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
 	this_.m[s.SessionID] = s
 }
 
 func (this_ *SessionMap) DelSession(s *Session) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
 	if v, ok := this_.m[s.SessionID]; ok {
 		if v.RemoteAddr() == s.RemoteAddr() {
 			delete(this_.m, s.SessionID)
@@ -49,29 +89,162 @@ func (this_ *SessionMap) DelSession(s *Session) {
 	}
 }
 
+// 发送失败关闭的连接，需要持有锁
+func (this_ *SessionMap) delClosed(s *Session) {
+	if v, ok := this_.m[s.SessionID]; ok && v == s {
+		delete(this_.m, s.SessionID)
+	}
+}
+
+// Join 加入分组，连接已经关闭时返回 false
+func (this_ *SessionMap) Join(group string, s *Session) bool {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	joined, ok := this_.joined[s]
+	if !ok {
+		if !s.AddCloseHook(this_.onSessionClose) {
+			return false
+		}
+		joined = map[string]struct{}{}
+		this_.joined[s] = joined
+	}
+	joined[group] = struct{}{}
+	members, ok := this_.groups[group]
+	if !ok {
+		members = map[*Session]struct{}{}
+		this_.groups[group] = members
+	}
+	members[s] = struct{}{}
+	return true
+}
+
+// Leave 退出分组
+func (this_ *SessionMap) Leave(group string, s *Session) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	this_.leave(group, s)
+}
+
+func (this_ *SessionMap) leave(group string, s *Session) {
+	if members, ok := this_.groups[group]; ok {
+		delete(members, s)
+		if len(members) == 0 {
+			delete(this_.groups, group)
+		}
+	}
+	if joined, ok := this_.joined[s]; ok {
+		delete(joined, group)
+	}
+}
+
+// LeaveAll 退出所有分组
+func (this_ *SessionMap) LeaveAll(s *Session) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	for group := range this_.joined[s] {
+		this_.leave(group, s)
+	}
+}
+
+func (this_ *SessionMap) onSessionClose(s *Session, _ error) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	for group := range this_.joined[s] {
+		this_.leave(group, s)
+	}
+	delete(this_.joined, s)
+}
+
+// Groups 连接加入的所有分组
+func (this_ *SessionMap) Groups(s *Session) []string {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	groups := make([]string, 0, len(this_.joined[s]))
+	for group := range this_.joined[s] {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// GroupMembers 分组中的所有连接
+func (this_ *SessionMap) GroupMembers(group string) []*Session {
+	return this_.groupMembers(group, nil)
+}
+
+func (this_ *SessionMap) groupMembers(group string, except []*Session) []*Session {
+	this_.mu.RLock()
+	defer this_.mu.RUnlock()
+	members := this_.groups[group]
+	sessions := make([]*Session, 0, len(members))
+	for s := range members {
+		excepted := false
+		for _, e := range except {
+			if e == s {
+				excepted = true
+				break
+			}
+		}
+		if !excepted {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
 func (this_ *SessionMap) SendToAll(msg proto.Message) {
-	msgID := proto.MessageName(msg)
-	if msgID == "" {
-		this_.log.Error("SessionMap SendToAll unknown msg,because MessageName is '' ", zap.String("msg type", reflect.TypeOf(msg).String()))
+	this_.delFailed(this_.sendToSessions(this_.all(), msg, "SendToAll"))
+}
+
+// SendToGroup 发送给分组中的所有连接
+func (this_ *SessionMap) SendToGroup(group string, msg proto.Message) {
+	this_.delFailed(this_.sendToSessions(this_.groupMembers(group, nil), msg, "SendToGroup"))
+}
+
+// SendToGroupExcept 发送给分组中除了 except 之外的所有连接
+func (this_ *SessionMap) SendToGroupExcept(group string, msg proto.Message, except ...*Session) {
+	this_.delFailed(this_.sendToSessions(this_.groupMembers(group, except), msg, "SendToGroupExcept"))
+}
+
+// 发送失败被关闭的连接从 m 中删除，分组由关闭回调删除
+func (this_ *SessionMap) delFailed(failed []*Session) {
+	if len(failed) == 0 {
 		return
 	}
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	for _, v := range failed {
+		this_.delClosed(v)
+	}
+}
+
+// 相同 CodeC 的连接只编码一次，返回发送失败被关闭的连接
+func (this_ *SessionMap) sendToSessions(sessions []*Session, msg proto.Message, caller string) []*Session {
+	msgID := messageName(msg)
+	if msgID == "" {
+		this_.log.Error("SessionMap "+caller+" unknown msg,because MessageName is '' ", zap.String("msg type", reflect.TypeOf(msg).String()))
+		return nil
+	}
+	var failed []*Session
 	d := map[string][]byte{}
-	for _, v := range this_.m {
-		bodyData, ok := d[v.CodeC.String()]
+	for _, v := range sessions {
+		codec := v.GetCodeC()
+		bodyData, ok := d[codec.String()]
 		if !ok {
 			var err error
-			bodyData, err = v.CodeC.Encode(msg)
+			bodyData, err = codec.Encode(msg)
 			if err != nil {
-				this_.log.Error("SessionMap SendToAll error msg,because CodeC.Encode(msg) failed", zap.String("msg type", reflect.TypeOf(msg).String()))
-				return
+				this_.log.Error("SessionMap "+caller+" error msg,because CodeC.Encode(msg) failed", zap.String("msg type", reflect.TypeOf(msg).String()))
+				return failed
 			}
+			d[codec.String()] = bodyData
 		}
 		//v.SendBytesNoError(msgID, bodyData)
-		err := v.SendBytes(msgID, bodyData)
+		err := v.sendEncoded(codec, msgID, bodyData, msg)
 		if err != nil {
 			v.Close(err)
-			delete(this_.m, v.SessionID)
+			failed = append(failed, v)
 			this_.log.Error("SendBytesNoError failed", zap.Error(err), zap.String("SessionName", v.SessionName), zap.String("msgID", msgID), zap.Any("msg", msg))
 		}
 	}
+	return failed
 }
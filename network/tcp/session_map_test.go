@@ -0,0 +1,63 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestSessionMapGroup(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	m := NewSessionMap(log)
+	s1 := &Session{SessionID: "1"}
+	s2 := &Session{SessionID: "2"}
+	m.AddSession(s1)
+	m.AddSession(s2)
+	r.Equal(2, m.Len())
+
+	r.True(m.Join("room", s1))
+	r.True(m.Join("room", s2))
+	r.True(m.Join("guild", s1))
+	r.ElementsMatch([]*Session{s1, s2}, m.GroupMembers("room"))
+	r.Equal([]*Session{s2}, m.groupMembers("room", []*Session{s1}))
+	r.ElementsMatch([]string{"room", "guild"}, m.Groups(s1))
+
+	m.Leave("room", s2)
+	r.Equal([]*Session{s1}, m.GroupMembers("room"))
+
+	// 连接关闭时自动退出所有分组
+	for _, f := range s1.closeHooks {
+		f(s1, nil)
+	}
+	r.Empty(m.GroupMembers("room"))
+	r.Empty(m.GroupMembers("guild"))
+	r.Empty(m.Groups(s1))
+
+	// 分组发送失败关闭的连接也从 m 中删除
+	full := newQueuedSession(r, log, SendQueueConfig{MaxMessages: 1})
+	full.SessionID = "3"
+	m.AddSession(full)
+	r.True(m.Join("room", full))
+	r.True(m.Join("room", s2))
+	r.NoError(full.Send(&basepb.Base_Success{}))
+	m.SendToGroupExcept("room", &basepb.Base_Success{}, s2)
+	_, ok := m.GetSession("3")
+	r.False(ok)
+	r.Empty(m.Groups(full))
+	r.Equal([]*Session{s2}, m.GroupMembers("room"))
+
+	m.Leave("room", s2)
+	full = newQueuedSession(r, log, SendQueueConfig{MaxMessages: 1})
+	full.SessionID = "4"
+	m.AddSession(full)
+	r.True(m.Join("room", full))
+	r.NoError(full.Send(&basepb.Base_Success{}))
+	m.SendToGroup("room", &basepb.Base_Success{})
+	_, ok = m.GetSession("4")
+	r.False(ok)
+	_, ok = m.GetSession("2")
+	r.True(ok)
+}
@@ -0,0 +1,34 @@
+package tcp
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/handler"
+)
+
+var _ handler.Session = (*Session)(nil)
+
+type sharedDispatch struct {
+	d handler.DispatchInterface
+}
+
+// WrapDispatch 把和 ws 共用的 handler.DispatchInterface 转成 DispatchInterface
+func WrapDispatch(d handler.DispatchInterface) DispatchInterface {
+	return &sharedDispatch{d: d}
+}
+
+func (this_ *sharedDispatch) OnSessionConnected(s *Session) {
+	this_.d.OnSessionConnected(s)
+}
+
+func (this_ *sharedDispatch) OnSessionDisConnected(s *Session, err error) {
+	this_.d.OnSessionDisConnected(s, err)
+}
+
+func (this_ *sharedDispatch) OnRPCRequest(s *Session, msg proto.Message) proto.Message {
+	return this_.d.OnRPCRequest(s, msg)
+}
+
+func (this_ *sharedDispatch) OnNormalMsg(s *Session, msg proto.Message) {
+	this_.d.OnNormalMsg(s, msg)
+}
@@ -0,0 +1,102 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrServerShutdown = errors.New("server shutdown")
+	// 关闭服务器时超时，连接被强制关闭
+	ErrServerShutdownAborted = errors.New("server shutdown aborted")
+)
+
+// IsGoingAway 对端是否已经通知即将关闭
+func (this_ *Session) IsGoingAway() bool {
+	return atomic.LoadUint32(&this_.goingAway) == 1
+}
+
+func (this_ *Session) sendGoAway() error {
+	return this_.sendRawByte(packetProtocolGoAway, 0, messagePingName, nil)
+}
+
+func (this_ *Session) onGoAway() {
+	atomic.StoreUint32(&this_.goingAway, 1)
+	if this_.isDebugLog {
+		this_.logger.Debug("session recv go away", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID), zap.String("remote", this_.RemoteAddr()))
+	}
+	if g, ok := this_.Dispatch.(GoAwayInterface); ok {
+		g.OnGoAway(this_)
+	}
+}
+
+func (this_ *Session) rpcDone() {
+	atomic.AddInt32(&this_.inflight, -1)
+}
+
+// 没有未处理完的 rpc 请求，发送缓存也已经写完
+func (this_ *Session) drained() bool {
+	if atomic.LoadInt32(&this_.inflight) > 0 {
+		return false
+	}
+	this_.sendCond.L.Lock()
+	defer this_.sendCond.L.Unlock()
+	return len(this_.sendBuf) == 0 && atomic.LoadInt32(&this_.sending) == 0
+}
+
+// Shutdown 优雅关闭: 停止接收新连接，通知所有连接服务器即将关闭，
+// 等待正在处理的 rpc 请求处理完、发送缓存写完之后关闭连接，ctx 结束时强制关闭剩下的连接
+// 返回正常关闭和强制关闭的连接数，不能在 queue 中调用
+func (this_ *Acceptor) Shutdown(ctx context.Context) (drained int, aborted int) {
+	this_.Close()
+	this_.expireDetached(ErrServerShutdown)
+
+	var sessions []*Session
+	this_.sessions.Range(func(key, value interface{}) bool {
+		s := key.(*Session)
+		if err := s.sendGoAway(); err == nil {
+			sessions = append(sessions, s)
+		}
+		return true
+	})
+
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for {
+		remaining := sessions[:0]
+		for _, s := range sessions {
+			if s.isClose() {
+				drained++
+				continue
+			}
+			if s.drained() {
+				s.Close(ErrServerShutdown)
+				drained++
+				continue
+			}
+			remaining = append(remaining, s)
+		}
+		sessions = remaining
+		if len(sessions) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			for _, s := range sessions {
+				s.Close(ErrServerShutdownAborted)
+				aborted++
+			}
+			sessions = nil
+		case <-ticker.C:
+		}
+		if len(sessions) == 0 {
+			break
+		}
+	}
+	this_.logger.Info("acceptor shutdown", zap.String("addr", this_.addr), zap.Int("drained", drained), zap.Int("aborted", aborted))
+	return
+}
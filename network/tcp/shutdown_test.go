@@ -0,0 +1,101 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+type goAwayDispatch struct {
+	*chanDispatch
+	goAway chan struct{}
+}
+
+func (this_ *goAwayDispatch) OnGoAway(*Session) { this_.goAway <- struct{}{} }
+
+func TestAcceptorShutdown(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	// rpc 处理的时候会阻塞服务器的 queue，客户端用单独的 queue
+	clientQueue := newRunningQueue(log)
+
+	// 返回服务器的回调和已经连上的客户端，rpc 请求要等 release 关闭之后才返回
+	start := func(release chan struct{}) (*Acceptor, *chanDispatch, *Connector, *goAwayDispatch) {
+		acceptor, err := NewAcceptorWithTransport("127.0.0.1:0", &loopbackTransport{}, newRunningQueue(log), &ProtoCodeC{}, log, false)
+		r.NoError(err)
+		server := newChanDispatch()
+		server.onRequest = func(s *Session, m proto.Message) proto.Message {
+			server.msgs <- m
+			<-release
+			return &basepb.Base_Success{}
+		}
+		acceptor.SetCallback(server)
+		acceptor.StartAccept()
+
+		client := &goAwayDispatch{chanDispatch: newChanDispatch(), goAway: make(chan struct{}, 1)}
+		c := NewConnector(net.JoinHostPort(acceptor.IP, acceptor.Port), clientQueue, log, "shutdown")
+		c.SetCallback(client)
+		c.Connect()
+		client.waitConnected(r)
+		server.waitConnected(r)
+		return acceptor, server, c, client
+	}
+
+	release := make(chan struct{})
+	acceptor, server, c, client := start(release)
+	resp := make(chan error, 1)
+	go func() {
+		_, err := c.GetSession().RequestContext(context.Background(), &basepb.Base_Ping{})
+		resp <- err
+	}()
+	<-server.msgs
+
+	type result struct{ drained, aborted int }
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		d, a := acceptor.Shutdown(ctx)
+		done <- result{d, a}
+	}()
+	select {
+	case <-client.goAway:
+	case <-time.After(time.Second * 3):
+		r.FailNow("no go away")
+	}
+	r.True(c.GetSession().IsGoingAway())
+	// 还有没处理完的 rpc 请求，连接保持
+	select {
+	case <-done:
+		r.FailNow("shutdown before rpc finished")
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(release)
+	r.Equal(result{drained: 1}, <-done)
+	r.NoError(<-resp)
+	r.Equal(ErrServerShutdown, server.waitDisconnected(r))
+	_, err := net.Dial("tcp4", net.JoinHostPort(acceptor.IP, acceptor.Port))
+	r.Error(err)
+
+	// 超时之后强制关闭
+	release = make(chan struct{})
+	defer close(release)
+	acceptor, server, c, _ = start(release)
+	go func() {
+		_, err := c.GetSession().RequestContext(context.Background(), &basepb.Base_Ping{})
+		resp <- err
+	}()
+	<-server.msgs
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	drained, aborted := acceptor.Shutdown(ctx)
+	r.Equal(0, drained)
+	r.Equal(1, aborted)
+	r.Equal(ErrSessionClosed, <-resp)
+}
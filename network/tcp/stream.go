@@ -0,0 +1,337 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// 流: 一端用 OpenStream 打开，之后两端都可以发送任意多个消息，直到双方都调用 CloseSend 或者任意一端调用 Cancel
+// 流的 id 放在包头的 rpcIndex 中，打开流的一端发送时为原始 id，接受流的一端发送时最高位置1
+// 流控: 每端最多可以发送 streamWindow 个对方还没处理的消息，对端每处理完一半会回复 packetProtocolStreamCredit
+// 收到的时候检查，对端打开重复的 id 或者超过窗口发送时关闭连接
+// 所有的 StreamHandler 回调都在 queue 中执行
+
+const (
+	streamIDResponderFlag uint32 = 1 << 31
+	streamWindow                 = 64
+)
+
+var (
+	ErrStreamClosed     = errors.New("stream closed")
+	ErrStreamBufferFull = errors.New("stream send buffer full")
+)
+
+type StreamHandler interface {
+	// 收到对端发来的消息
+	OnStreamMsg(st *Stream, msg proto.Message)
+	// 对端关闭了发送(err == nil)，或者流被取消、连接断开(err != nil)
+	OnStreamClosed(st *Stream, err error)
+}
+
+// StreamAcceptInterface 由 Session.Dispatch 实现，用于接受对端打开的流，没有实现时对端打开的流会被取消
+type StreamAcceptInterface interface {
+	// msg 为打开流时带的第一个消息，返回 nil 拒绝这个流
+	OnStreamOpen(st *Stream, msg proto.Message) StreamHandler
+}
+
+// StreamCanceled 流被对端取消
+type StreamCanceled struct {
+	Reason string
+}
+
+func (this_ *StreamCanceled) Error() string {
+	return "stream canceled:" + this_.Reason
+}
+
+type Stream struct {
+	id       uint32
+	isOpener bool
+	sess     *Session
+	handler  StreamHandler
+
+	mu           sync.Mutex
+	credits      int
+	pending      []proto.Message
+	sendClosed   bool
+	remoteClosed bool
+	canceled     bool
+	// 已经处理但还没有回复 credit 的消息数，只在 queue 中使用
+	consumed int
+	// 对端还可以发送多少个消息，收到时在 recvLoop 中减，回复 credit 时加
+	recvWindow int32
+}
+
+func (this_ *Stream) ID() uint32 {
+	return this_.id
+}
+
+func (this_ *Stream) GetSession() *Session {
+	return this_.sess
+}
+
+// IsOpener 是否是本端打开的流
+func (this_ *Stream) IsOpener() bool {
+	return this_.isOpener
+}
+
+// 发送时包头中的 id
+func (this_ *Stream) wireID() uint32 {
+	if this_.isOpener {
+		return this_.id
+	}
+	return this_.id | streamIDResponderFlag
+}
+
+// Send 发送一个消息，对端的处理速度跟不上时会先缓存 streamWindow 个，再多返回 ErrStreamBufferFull
+func (this_ *Stream) Send(msg proto.Message) error {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.sendClosed || this_.canceled {
+		return ErrStreamClosed
+	}
+	if this_.credits > 0 && len(this_.pending) == 0 {
+		this_.credits--
+		return this_.sess.sendRaw(packetProtocolStreamData, this_.wireID(), msg)
+	}
+	if len(this_.pending) >= streamWindow {
+		return ErrStreamBufferFull
+	}
+	this_.pending = append(this_.pending, msg)
+	return nil
+}
+
+// CloseSend 本端不再发送消息，还可以继续接收对端的消息，缓存中还没发送的消息会在发送完之后再关闭
+func (this_ *Stream) CloseSend() error {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.sendClosed || this_.canceled {
+		return nil
+	}
+	this_.sendClosed = true
+	if len(this_.pending) > 0 {
+		return nil
+	}
+	err := this_.sess.sendRawByte(packetProtocolStreamClose, this_.wireID(), messagePingName, nil)
+	this_.removeIfDone()
+	return err
+}
+
+// Cancel 取消流，两端都不再收发消息，本端不会再收到 OnStreamClosed
+func (this_ *Stream) Cancel(reason string) error {
+	this_.mu.Lock()
+	if this_.canceled {
+		this_.mu.Unlock()
+		return nil
+	}
+	this_.canceled = true
+	this_.pending = nil
+	this_.mu.Unlock()
+	this_.sess.removeStream(this_)
+	return this_.sess.sendRawByte(packetProtocolStreamCancel, this_.wireID(), messagePingName, []byte(reason))
+}
+
+func (this_ *Stream) addCredits(n int) {
+	this_.mu.Lock()
+	defer this_.mu.Unlock()
+	if this_.canceled {
+		return
+	}
+	this_.credits += n
+	for this_.credits > 0 && len(this_.pending) > 0 {
+		msg := this_.pending[0]
+		this_.pending[0] = nil
+		this_.pending = this_.pending[1:]
+		this_.credits--
+		if err := this_.sess.sendRaw(packetProtocolStreamData, this_.wireID(), msg); err != nil {
+			return
+		}
+	}
+	if len(this_.pending) == 0 && this_.sendClosed {
+		_ = this_.sess.sendRawByte(packetProtocolStreamClose, this_.wireID(), messagePingName, nil)
+		this_.removeIfDone()
+	}
+}
+
+// 需要持有 mu
+func (this_ *Stream) removeIfDone() {
+	if this_.sendClosed && len(this_.pending) == 0 && this_.remoteClosed {
+		this_.sess.removeStream(this_)
+	}
+}
+
+// 处理完一个消息，处理了半个窗口之后通知对端可以继续发送
+func (this_ *Stream) consume() {
+	this_.consumed++
+	if this_.consumed >= streamWindow/2 {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(this_.consumed))
+		atomic.AddInt32(&this_.recvWindow, int32(this_.consumed))
+		this_.consumed = 0
+		_ = this_.sess.sendRawByte(packetProtocolStreamCredit, this_.wireID(), messagePingName, b[:])
+	}
+}
+
+func (this_ *Stream) closed(err error) {
+	this_.mu.Lock()
+	if this_.canceled {
+		this_.mu.Unlock()
+		return
+	}
+	if err != nil {
+		this_.canceled = true
+		this_.pending = nil
+	} else {
+		this_.remoteClosed = true
+		this_.removeIfDone()
+	}
+	this_.mu.Unlock()
+	if err != nil {
+		this_.sess.removeStream(this_)
+	}
+	if this_.handler != nil {
+		this_.handler.OnStreamClosed(this_, err)
+	}
+}
+
+// OpenStream 打开一个流，msg 为第一个消息，对端的消息和关闭事件通过 h 回调
+func (this_ *Session) OpenStream(msg proto.Message, h StreamHandler) (*Stream, error) {
+	if h == nil {
+		return nil, errors.New("stream handler is nil")
+	}
+	st := &Stream{
+		isOpener:   true,
+		sess:       this_,
+		handler:    h,
+		credits:    streamWindow,
+		recvWindow: streamWindow,
+	}
+	// id 用完一圈之后跳过 0 和还没结束的流
+	for {
+		st.id = atomic.AddUint32(&this_.streamIndex, 1) &^ streamIDResponderFlag
+		if st.id == 0 {
+			continue
+		}
+		if _, loaded := this_.localStreams.LoadOrStore(st.id, st); !loaded {
+			break
+		}
+	}
+	err := this_.sendRaw(packetProtocolStreamOpen, st.id, msg)
+	if err != nil {
+		this_.localStreams.Delete(st.id)
+		return nil, err
+	}
+	return st, nil
+}
+
+func (this_ *Session) removeStream(st *Stream) {
+	if st.isOpener {
+		this_.localStreams.Delete(st.id)
+	} else {
+		this_.remoteStreams.Delete(st.id)
+	}
+}
+
+func (this_ *Session) findStream(wireID uint32) (*Stream, bool) {
+	var v interface{}
+	var ok bool
+	if wireID&streamIDResponderFlag != 0 {
+		v, ok = this_.localStreams.Load(wireID &^ streamIDResponderFlag)
+	} else {
+		v, ok = this_.remoteStreams.Load(wireID)
+	}
+	if !ok {
+		return nil, false
+	}
+	return v.(*Stream), true
+}
+
+// 在 queue 中调用
+func (this_ *Session) onStreamPacket(p *Packet) {
+	if p.Protocol == packetProtocolStreamOpen {
+		this_.onStreamOpen(p)
+		return
+	}
+	st, ok := this_.findStream(p.RPCIndex)
+	if !ok {
+		if this_.isDebugLog {
+			this_.logger.Debug("stream not found", zap.String("session name", this_.SessionName), zap.String("session id", this_.SessionID),
+				zap.Uint32("stream id", p.RPCIndex), zap.Uint8("protocol", uint8(p.Protocol)))
+		}
+		return
+	}
+	switch p.Protocol {
+	case packetProtocolStreamData:
+		if st.handler != nil {
+			st.handler.OnStreamMsg(st, p.Msg)
+		}
+		st.consume()
+	case packetProtocolStreamClose:
+		st.closed(nil)
+	case packetProtocolStreamCancel:
+		st.closed(&StreamCanceled{Reason: string(p.data)})
+	case packetProtocolStreamCredit:
+		if len(p.data) >= 4 {
+			st.addCredits(int(binary.LittleEndian.Uint32(p.data)))
+		}
+	}
+}
+
+// 在 recvLoop 中调用，对端打开的流在这里登记，保证后面的数据包能找到
+func (this_ *Session) checkStreamPacket(pp packetProtocol, wireID uint32) error {
+	switch pp {
+	case packetProtocolStreamOpen:
+		if wireID == 0 || wireID&streamIDResponderFlag != 0 {
+			return fmt.Errorf("invalid stream id %d", wireID)
+		}
+		st := &Stream{
+			id:         wireID,
+			sess:       this_,
+			credits:    streamWindow,
+			recvWindow: streamWindow,
+		}
+		if _, loaded := this_.remoteStreams.LoadOrStore(wireID, st); loaded {
+			return fmt.Errorf("stream %d already open", wireID)
+		}
+	case packetProtocolStreamData:
+		if st, ok := this_.findStream(wireID); ok && atomic.AddInt32(&st.recvWindow, -1) < 0 {
+			return fmt.Errorf("stream %d exceeded flow control window", wireID)
+		}
+	}
+	return nil
+}
+
+func (this_ *Session) onStreamOpen(p *Packet) {
+	v, ok := this_.remoteStreams.Load(p.RPCIndex)
+	if !ok {
+		return
+	}
+	st := v.(*Stream)
+	sa, ok := this_.Dispatch.(StreamAcceptInterface)
+	if !ok {
+		_ = st.Cancel("stream not supported")
+		return
+	}
+	st.handler = sa.OnStreamOpen(st, p.Msg)
+	if st.handler == nil {
+		_ = st.Cancel("stream rejected")
+	}
+}
+
+// 连接断开时通知所有还没结束的流
+func (this_ *Session) closeStreams(err error) {
+	if err == nil {
+		err = ErrSessionClosed
+	}
+	f := func(key, value interface{}) bool {
+		value.(*Stream).closed(err)
+		return true
+	}
+	this_.localStreams.Range(f)
+	this_.remoteStreams.Range(f)
+}
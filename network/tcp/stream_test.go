@@ -0,0 +1,170 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+type chanStreamHandler struct {
+	msgs   chan proto.Message
+	closed chan error
+}
+
+func newChanStreamHandler() *chanStreamHandler {
+	return &chanStreamHandler{msgs: make(chan proto.Message, 256), closed: make(chan error, 1)}
+}
+
+func (this_ *chanStreamHandler) OnStreamMsg(_ *Stream, msg proto.Message) { this_.msgs <- msg }
+func (this_ *chanStreamHandler) OnStreamClosed(_ *Stream, err error)      { this_.closed <- err }
+
+func (this_ *chanStreamHandler) waitClosed(r *require.Assertions) error {
+	select {
+	case err := <-this_.closed:
+		return err
+	case <-time.After(time.Second * 3):
+		r.FailNow("stream not closed")
+	}
+	return nil
+}
+
+type streamAcceptDispatch struct {
+	*chanDispatch
+	streams chan *Stream
+	handler *chanStreamHandler
+}
+
+func (this_ *streamAcceptDispatch) OnStreamOpen(st *Stream, msg proto.Message) StreamHandler {
+	if msg.(*basepb.Base_Error).ErrorCode < 0 {
+		return nil
+	}
+	this_.streams <- st
+	return this_.handler
+}
+
+func TestStream(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := newRunningQueue(log)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	r.NoError(err)
+	defer ln.Close()
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	r.NoError(err)
+	s, err := ln.Accept()
+	r.NoError(err)
+	client := NewSession(c, queue, true, &ProtoCodeC{}, log, time.Second, false)
+	client.Dispatch = newChanDispatch()
+	server := NewSession(s, queue, true, &ProtoCodeC{}, log, time.Second, false)
+	accept := &streamAcceptDispatch{chanDispatch: newChanDispatch(), streams: make(chan *Stream, 2), handler: newChanStreamHandler()}
+	server.Dispatch = accept
+	client.Start()
+	server.Start()
+	defer client.Close(ErrSessionClosed)
+
+	h := newChanStreamHandler()
+	st, err := client.OpenStream(&basepb.Base_Error{ErrorCode: 1}, h)
+	r.NoError(err)
+	var remote *Stream
+	select {
+	case remote = <-accept.streams:
+	case <-time.After(time.Second * 3):
+		r.FailNow("stream not accepted")
+	}
+	r.Equal(st.ID(), remote.ID())
+	r.False(remote.IsOpener())
+
+	// 超过窗口的消息先缓存，对端处理之后通过 credit 继续发送
+	n := streamWindow + streamWindow/2
+	for i := 0; i < n; i++ {
+		r.NoError(st.Send(&basepb.Base_Error{ErrorCode: int64(i)}))
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case m := <-accept.handler.msgs:
+			r.EqualValues(i, m.(*basepb.Base_Error).ErrorCode)
+		case <-time.After(time.Second * 3):
+			r.FailNow("stream msg not received", "%d", i)
+		}
+	}
+
+	r.NoError(remote.Send(&basepb.Base_Success{}))
+	select {
+	case m := <-h.msgs:
+		r.IsType(&basepb.Base_Success{}, m)
+	case <-time.After(time.Second * 3):
+		r.FailNow("stream reply not received")
+	}
+
+	// 对端取消
+	r.NoError(remote.Cancel("done"))
+	r.Equal(&StreamCanceled{Reason: "done"}, h.waitClosed(r))
+	r.Equal(ErrStreamClosed, st.Send(&basepb.Base_Success{}))
+	_, ok := client.findStream(st.ID())
+	r.False(ok)
+
+	// 拒绝的流
+	h = newChanStreamHandler()
+	_, err = client.OpenStream(&basepb.Base_Error{ErrorCode: -1}, h)
+	r.NoError(err)
+	r.Equal(&StreamCanceled{Reason: "stream rejected"}, h.waitClosed(r))
+
+	// 双方都关闭发送之后结束
+	h = newChanStreamHandler()
+	st, err = client.OpenStream(&basepb.Base_Error{ErrorCode: 2}, h)
+	r.NoError(err)
+	remote = <-accept.streams
+	r.NoError(st.CloseSend())
+	r.NoError(accept.handler.waitClosed(r))
+	r.NoError(remote.CloseSend())
+	r.NoError(h.waitClosed(r))
+	r.Eventually(func() bool {
+		_, local := client.localStreams.Load(st.ID())
+		_, remote := server.remoteStreams.Load(st.ID())
+		return !local && !remote
+	}, time.Second, time.Millisecond*5)
+}
+
+func TestStreamProtocolError(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	sess := newQueuedSession(r, log, SendQueueConfig{})
+	r.Error(sess.checkStreamPacket(packetProtocolStreamOpen, 0))
+	r.Error(sess.checkStreamPacket(packetProtocolStreamOpen, 1|streamIDResponderFlag))
+	r.NoError(sess.checkStreamPacket(packetProtocolStreamOpen, 1))
+	// 重复的 id
+	r.Error(sess.checkStreamPacket(packetProtocolStreamOpen, 1))
+
+	// 对端不等 credit 继续发送
+	for i := 0; i < streamWindow; i++ {
+		r.NoError(sess.checkStreamPacket(packetProtocolStreamData, 1))
+	}
+	r.Error(sess.checkStreamPacket(packetProtocolStreamData, 1))
+
+	// 处理完之后回复的 credit 会加回窗口
+	st, _ := sess.findStream(1)
+	st.recvWindow = 0
+	for i := 0; i < streamWindow/2; i++ {
+		st.consume()
+	}
+	r.NoError(sess.checkStreamPacket(packetProtocolStreamData, 1))
+
+	// id 用完一圈之后跳过 0 和还没结束的流
+	sess.streamIndex = streamIDResponderFlag - 1
+	sess.localStreams.Store(uint32(1), &Stream{})
+	st, err := sess.OpenStream(&basepb.Base_Success{}, newChanStreamHandler())
+	r.NoError(err)
+	r.EqualValues(2, st.ID())
+
+	// 没有 credit 时最多缓存 streamWindow 个
+	st.credits = 0
+	for i := 0; i < streamWindow; i++ {
+		r.NoError(st.Send(&basepb.Base_Success{}))
+	}
+	r.Equal(ErrStreamBufferFull, st.Send(&basepb.Base_Success{}))
+}
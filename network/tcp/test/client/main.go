@@ -6,8 +6,8 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
@@ -36,7 +36,7 @@ func (this_ *Client) TestRequest() {
 	this_.Connector.RequestNoError(&basepb.Base{}, func(m proto.Message) {
 		msg, ok := m.(*basepb.Base_Success)
 		if ok {
-			this_.log.Info("recv response", zap.String("msgID", proto.MessageName(m)), zap.String("msg", msg.String()))
+			this_.log.Info("recv response", zap.String("msgID", string(proto.MessageName(m))), zap.String("msg", msg.String()))
 			this_.TestRequest()
 		}
 	})
@@ -5,8 +5,8 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
@@ -62,7 +62,7 @@ func (this_ *Server) TestRequest(s *tcp.Session) {
 	s.RequestNoError(&basepb.Base{}, func(m proto.Message) {
 		msg, ok := m.(*basepb.Base_Success)
 		if ok {
-			this_.log.Info("recv response", zap.String("msgID", proto.MessageName(m)), zap.String("msg", msg.String()))
+			this_.log.Info("recv response", zap.String("msgID", string(proto.MessageName(m))), zap.String("msg", msg.String()))
 			this_.TestRequest(s)
 		}
 	})
@@ -0,0 +1,195 @@
+package tcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+)
+
+// 通过 channel 通知的 DispatchInterface，用于 Acceptor 和 Connector 的测试
+type chanDispatch struct {
+	connected    chan *Session
+	disconnected chan error
+	msgs         chan proto.Message
+	onRequest    func(s *Session, m proto.Message) proto.Message
+}
+
+func newChanDispatch() *chanDispatch {
+	return &chanDispatch{
+		connected:    make(chan *Session, 8),
+		disconnected: make(chan error, 8),
+		msgs:         make(chan proto.Message, 8),
+	}
+}
+
+func (this_ *chanDispatch) OnSessionConnected(s *Session) { this_.connected <- s }
+func (this_ *chanDispatch) OnSessionDisConnected(_ *Session, err error) {
+	this_.disconnected <- err
+}
+func (this_ *chanDispatch) OnNormalMsg(_ *Session, m proto.Message) { this_.msgs <- m }
+func (this_ *chanDispatch) OnRPCRequest(s *Session, m proto.Message) proto.Message {
+	if this_.onRequest != nil {
+		return this_.onRequest(s, m)
+	}
+	return &basepb.Base_Success{}
+}
+
+func (this_ *chanDispatch) waitConnected(r *require.Assertions) *Session {
+	select {
+	case s := <-this_.connected:
+		return s
+	case <-time.After(time.Second * 3):
+		r.FailNow("not connected")
+	}
+	return nil
+}
+
+func (this_ *chanDispatch) waitDisconnected(r *require.Assertions) error {
+	select {
+	case err := <-this_.disconnected:
+		return err
+	case <-time.After(time.Second * 3):
+		r.FailNow("not disconnected")
+	}
+	return nil
+}
+
+// TCPTransport 监听时要调大文件描述符上限，普通用户没有权限，测试直接监听
+type loopbackTransport struct {
+	TCPTransport
+}
+
+func (this_ *loopbackTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp4", addr)
+}
+
+// 测试用的日志，写到测试的临时目录
+func newTestLogger(t *testing.T) *logger.Logger {
+	log, err := logger.New("test", t.TempDir(), zapcore.DebugLevel, true)
+	require.NoError(t, err)
+	return log
+}
+
+func newRunningQueue(log *logger.Logger) *eventqueue.EventQueue {
+	queue := eventqueue.NewEventQueue(100, log)
+	queue.Run(nil, DispatchMsg(func(interface{}) {}, nil))
+	return queue
+}
+
+func writeTestPEM(r *require.Assertions, path string, typ string, data []byte) {
+	r.NoError(ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: data}), 0600))
+}
+
+// 生成 parent 签发的证书，parent 为 nil 时生成自签名的 CA，返回证书和私钥文件
+func genTestCert(r *require.Assertions, dir string, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	r.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	r.NoError(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	r.NoError(err)
+	certFile, keyFile := filepath.Join(dir, cn+".pem"), filepath.Join(dir, cn+".key")
+	writeTestPEM(r, certFile, "CERTIFICATE", der)
+	writeTestPEM(r, keyFile, "EC PRIVATE KEY", keyDer)
+	return cert, key, certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := genTestCert(r, dir, "ca", nil, nil)
+	_, _, serverCert, serverKey := genTestCert(r, dir, "server", ca, caKey)
+	_, _, clientCert, clientKey := genTestCert(r, dir, "client", ca, caKey)
+
+	serverCfg, err := NewServerTLSConfig(serverCert, serverKey, caFile)
+	r.NoError(err)
+	clientCfg, err := NewClientTLSConfig(caFile, clientCert, clientKey)
+	r.NoError(err)
+	_, err = NewServerTLSConfig(serverCert, serverKey, serverKey)
+	r.Error(err)
+
+	queue := newRunningQueue(log)
+	acceptor, err := NewTLSAcceptorWithTransport("127.0.0.1:0", &loopbackTransport{}, serverCfg, queue, &ProtoCodeC{}, log, false)
+	r.NoError(err)
+	defer acceptor.Close()
+	r.True(acceptor.IsTLS())
+	server := newChanDispatch()
+	acceptor.SetCallback(server)
+	acceptor.StartAccept()
+	addr := net.JoinHostPort(acceptor.IP, acceptor.Port)
+
+	client := newChanDispatch()
+	c := NewConnector(addr, queue, log, "tls", WithTLS(clientCfg))
+	c.SetCallback(client)
+	c.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	r.NoError(c.WaitConnected(ctx))
+	client.waitConnected(r)
+
+	sess := server.waitConnected(r)
+	r.True(sess.IsTLS())
+	r.Equal("client", sess.PeerCommonName())
+	state, ok := sess.TLSConnectionState()
+	r.True(ok)
+	r.True(state.HandshakeComplete)
+	r.True(c.GetSession().IsTLS())
+	r.Equal("server", c.GetSession().PeerCommonName())
+
+	r.NoError(c.GetSession().Send(&basepb.Base_Error{ErrorCode: 3}))
+	select {
+	case m := <-server.msgs:
+		r.EqualValues(3, m.(*basepb.Base_Error).ErrorCode)
+	case <-time.After(time.Second * 3):
+		r.FailNow("no message")
+	}
+
+	// 不信任服务器证书的客户端连不上
+	untrusted := newChanDispatch()
+	c = NewConnector(addr, queue, log, "untrusted", WithTLS(clientCfg.Clone()))
+	c.tlsConfig.RootCAs = nil
+	c.SetCallback(untrusted)
+	c.Connect()
+	r.IsType(&ConnectError{}, untrusted.waitDisconnected(r))
+
+	// 没有 tls 的会话
+	plain := &Session{}
+	r.False(plain.IsTLS())
+	r.Empty(plain.PeerCommonName())
+	r.Nil(plain.PeerCertificates())
+}
@@ -0,0 +1,63 @@
+package tcp
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/njmdk/common/network/reuseport"
+)
+
+// Transport 连接的传输层，只需要提供可靠、有序的字节流，包协议、rpc 和分发都在上面一层，和传输层无关
+// Acceptor 和 Connector 默认使用 TCPTransport，KCP 见 kcp 子包
+type Transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+	String() string
+}
+
+// TCPTransport tcp4，监听使用 SO_REUSEPORT
+type TCPTransport struct{}
+
+func (this_ *TCPTransport) String() string {
+	return "tcp"
+}
+
+func (this_ *TCPTransport) Listen(addr string) (net.Listener, error) {
+	return reuseport.Listen("tcp4", addr)
+}
+
+func (this_ *TCPTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp4", addr, timeout)
+}
+
+// UnixTransport unix domain socket，addr 为 socket 文件路径，适用于同一台机器上的服务之间
+// 所有连接的 RemoteTcp4IP 都一样，AcceptLimit 的单个 ip 连接数限制对所有连接一起生效
+type UnixTransport struct{}
+
+func (this_ *UnixTransport) String() string {
+	return "unix"
+}
+
+// 进程异常退出时 socket 文件不会被删除，没有在监听的旧文件先删掉
+func (this_ *UnixTransport) Listen(addr string) (net.Listener, error) {
+	if _, err := os.Stat(addr); err == nil {
+		if conn, err := net.DialTimeout("unix", addr, time.Second); err == nil {
+			_ = conn.Close()
+		} else {
+			_ = os.Remove(addr)
+		}
+	}
+	return net.Listen("unix", addr)
+}
+
+func (this_ *UnixTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", addr, timeout)
+}
+
+// WithTransport 使用指定的 Transport 连接服务器，默认 TCPTransport
+func WithTransport(t Transport) Option {
+	return func(c *Connector) {
+		c.transport = t
+	}
+}
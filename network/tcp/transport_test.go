@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestUnixTransport(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	addr := filepath.Join(t.TempDir(), "tcp.sock")
+	tr := &UnixTransport{}
+	ln, err := tr.Listen(addr)
+	r.NoError(err)
+	c, err := tr.Dial(addr, time.Second)
+	r.NoError(err)
+	defer c.Close()
+	s, err := ln.Accept()
+	r.NoError(err)
+
+	sess := NewSession(s, eventqueue.NewEventQueue(0, log), true, &ProtoCodeC{}, log, time.Second, false)
+	sess.Start()
+	r.NoError(sess.Send(&basepb.Base_Success{}))
+	pp, _ := readTestFrame(r, c)
+	r.Equal(packetProtocolNormal, pp)
+	sess.Close(ErrServerShutdown)
+
+	// 进程异常退出留下的 socket 文件不影响重新监听
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	r.NoError(ln.Close())
+	ln, err = tr.Listen(addr)
+	r.NoError(err)
+	r.NoError(ln.Close())
+}
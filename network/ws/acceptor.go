@@ -0,0 +1,231 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+	"github.com/njmdk/common/network/tcp"
+)
+
+// DispatchInterface 和 tcp.DispatchInterface 一样，需要和 tcp 共用处理代码时使用 WrapDispatch
+type DispatchInterface interface {
+	OnSessionConnected(*Session)
+	OnSessionDisConnected(*Session, error)
+	OnRPCRequest(*Session, proto.Message) proto.Message
+	OnNormalMsg(*Session, proto.Message)
+}
+
+type AcceptSession struct {
+	*Session
+}
+
+func (this_ *AcceptSession) GetSession() *Session {
+	return this_.Session
+}
+
+type SessionClosed struct {
+	Err  error
+	Sess *Session
+}
+
+func (this_ *SessionClosed) GetSession() *Session {
+	return this_.Sess
+}
+
+// Packet 收到的消息，rpc 请求和返回的 Index 不为空
+type Packet struct {
+	Sess     *Session
+	Protocol packetProtocol
+	Index    string
+	Msg      proto.Message
+}
+
+func (this_ *Packet) IsRPCRequest() bool {
+	return this_.Protocol == packetProtocolRPCRequest
+}
+
+func (this_ *Packet) GetSession() *Session {
+	return this_.Sess
+}
+
+var ErrAcceptorClosed = errors.New("acceptor closed")
+
+// 默认60秒没有收到任何消息关闭连接，服务器定时发送 ping，浏览器会自动回复 pong，空闲的连接不会因此断开
+const defaultReadIdleTimeout = time.Second * 60
+
+// 默认单个消息最大1M
+const defaultMaxMessageLen = 1024 * 1024
+
+// Acceptor websocket 服务器，是一个 http.Handler，可以挂在任意路径上
+// 连接、断开和消息都投递到 EventQueue，用 DispatchMsg 分发给 DispatchInterface
+//
+//	acceptor := ws.NewAcceptor(queue, log, false)
+//	http.Handle("/ws", acceptor)
+//	queue.Run(nil, ws.DispatchMsg(tcp.DispatchMsg(f, pool)))
+type Acceptor struct {
+	ai         DispatchInterface
+	queue      *eventqueue.EventQueue
+	logger     *logger.Logger
+	upgrader   websocket.Upgrader
+	ids        WebSocket
+	sessions   sync.Map
+	count      int32
+	closed     int32
+	isDebugLog bool
+
+	contextType     string
+	maxMessageLen   int64
+	readIdleTimeout time.Duration
+	rpcTimeout      time.Duration
+	heartbeat       tcp.HeartbeatConfig
+}
+
+func NewAcceptor(queue *eventqueue.EventQueue, logger *logger.Logger, isDebugLog bool) *Acceptor {
+	acceptor := &Acceptor{
+		queue:           queue,
+		logger:          logger,
+		isDebugLog:      isDebugLog,
+		contextType:     ContextTypeJSON,
+		maxMessageLen:   defaultMaxMessageLen,
+		readIdleTimeout: defaultReadIdleTimeout,
+		rpcTimeout:      defaultRPCTimeout,
+		heartbeat:       tcp.DefaultHeartbeat,
+	}
+	acceptor.ai = acceptor
+	return acceptor
+}
+
+func (this_ *Acceptor) SetCallback(ai DispatchInterface) {
+	this_.ai = ai
+}
+
+// SetCheckOrigin 检查浏览器的 Origin，默认只允许和 Host 相同的 Origin
+func (this_ *Acceptor) SetCheckOrigin(f func(r *http.Request) bool) {
+	this_.upgrader.CheckOrigin = f
+}
+
+// SetMaxMessageLen 设置单个消息的最大长度，默认1M，对之后接入的连接生效
+func (this_ *Acceptor) SetMaxMessageLen(n int64) {
+	if n > 0 {
+		this_.maxMessageLen = n
+	}
+}
+
+// SetContextType 发送消息的格式，ContextTypeJSON(默认) 或者 ContextTypeProto，收到的两种格式都能解析，对之后接入的连接生效
+func (this_ *Acceptor) SetContextType(contextType string) {
+	if contextType == ContextTypeJSON || contextType == ContextTypeProto {
+		this_.contextType = contextType
+	}
+}
+
+// SetRPCTimeout 设置 Session.Request 等待返回的超时时间，默认2秒，对之后接入的连接生效
+func (this_ *Acceptor) SetRPCTimeout(timeout time.Duration) {
+	this_.rpcTimeout = timeout
+}
+
+// SetReadIdleTimeout 多久没有收到任何消息关闭连接，为0时不检查，对之后接入的连接生效
+func (this_ *Acceptor) SetReadIdleTimeout(d time.Duration) {
+	this_.readIdleTimeout = d
+}
+
+// SetHeartbeat 设置心跳，默认每10秒发送一次 ping，30秒没有收到 pong 断开，对之后接入的连接生效
+// 只使用 Interval 和 Timeout，读超时用 SetReadIdleTimeout 设置
+func (this_ *Acceptor) SetHeartbeat(cfg tcp.HeartbeatConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = tcp.DefaultHeartbeat.Interval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval * 3
+	}
+	this_.heartbeat = cfg
+}
+
+// SessionCount 当前的连接数
+func (this_ *Acceptor) SessionCount() int {
+	return int(atomic.LoadInt32(&this_.count))
+}
+
+func (this_ *Acceptor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&this_.closed) != 0 {
+		http.Error(w, ErrAcceptorClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := this_.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if this_.isDebugLog {
+			this_.logger.Warn("websocket upgrade failed", zap.Error(err), zap.String("remote", r.RemoteAddr))
+		}
+		return
+	}
+	conn.SetReadLimit(this_.maxMessageLen)
+	sess := NewClient(this_.ids.GenID(), conn, this_.onSessionClose, this_.onSessionMessage)
+	sess.log = this_.logger
+	sess.contextType = this_.contextType
+	sess.readIdleTimeout = this_.readIdleTimeout
+	sess.queue = this_.queue
+	sess.SetRPCTimeout(this_.rpcTimeout)
+	sess.Dispatch = this_.ai
+	atomic.AddInt32(&this_.count, 1)
+	this_.sessions.Store(sess, struct{}{})
+	this_.queue.Post(&AcceptSession{sess})
+	sess.Start()
+	startHeartbeat(this_.queue, sess, this_.heartbeat)
+	// 和 Close 同时发生时这个连接可能没有被关闭
+	if atomic.LoadInt32(&this_.closed) != 0 {
+		sess.Close(ErrAcceptorClosed)
+	}
+}
+
+func (this_ *Acceptor) onSessionClose(sess *Session, err error) {
+	this_.sessions.Delete(sess)
+	atomic.AddInt32(&this_.count, -1)
+	this_.queue.Post(&SessionClosed{Err: err, Sess: sess})
+}
+
+func (this_ *Acceptor) onSessionMessage(sess *Session, p *packet) {
+	this_.queue.Post(&Packet{Sess: sess, Protocol: p.Protocol, Index: p.Index, Msg: p.Msg})
+}
+
+// Close 不再接收新连接，关闭所有的连接
+func (this_ *Acceptor) Close() {
+	if !atomic.CompareAndSwapInt32(&this_.closed, 0, 1) {
+		return
+	}
+	this_.sessions.Range(func(key, value interface{}) bool {
+		key.(*Session).Close(ErrAcceptorClosed)
+		return true
+	})
+}
+
+func (this_ *Acceptor) OnSessionConnected(s *Session) {
+	this_.logger.Debug("websocket session connected", zap.String("id", s.ID()), zap.String("remote", s.RemoteAddr()))
+}
+
+func (this_ *Acceptor) OnSessionDisConnected(s *Session, err error) {
+	if this_.isDebugLog {
+		this_.logger.Warn("websocket session disconnected", zap.Error(err), zap.String("id", s.ID()), zap.String("remote", s.RemoteAddr()))
+	}
+}
+
+func (this_ *Acceptor) OnRPCRequest(s *Session, msg proto.Message) proto.Message {
+	if this_.isDebugLog {
+		this_.logger.Debug("websocket session recv rpc request", zap.String("msgID", string(proto.MessageName(msg))), zap.Any("request", msg), zap.String("remote", s.RemoteAddr()))
+	}
+	return &basepb.Base_Success{}
+}
+
+func (this_ *Acceptor) OnNormalMsg(s *Session, msg proto.Message) {
+	if this_.isDebugLog {
+		this_.logger.Debug("websocket session normal message", zap.String("msgID", string(proto.MessageName(msg))), zap.Any("msg", msg), zap.String("remote", s.RemoteAddr()))
+	}
+}
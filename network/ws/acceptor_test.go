@@ -0,0 +1,194 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+	"github.com/njmdk/common/network/tcp"
+)
+
+type recordDispatch struct {
+	connected    chan *Session
+	disconnected chan error
+	msgs         chan proto.Message
+}
+
+func (this_ *recordDispatch) OnSessionConnected(s *Session) { this_.connected <- s }
+func (this_ *recordDispatch) OnSessionDisConnected(_ *Session, err error) {
+	this_.disconnected <- err
+}
+func (this_ *recordDispatch) OnNormalMsg(_ *Session, m proto.Message) { this_.msgs <- m }
+func (this_ *recordDispatch) OnRPCRequest(_ *Session, m proto.Message) proto.Message {
+	return &basepb.Base_Error{ErrorCode: m.(*basepb.Base_Error).ErrorCode + 1}
+}
+
+// 测试用的日志，写到测试的临时目录
+func newTestLogger(t *testing.T) *logger.Logger {
+	log, err := logger.New("test", t.TempDir(), zapcore.DebugLevel, true)
+	require.NoError(t, err)
+	return log
+}
+
+func TestAcceptor(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := eventqueue.NewEventQueue(100, log)
+	queue.Run(nil, DispatchMsg(func(interface{}) {}))
+
+	d := &recordDispatch{connected: make(chan *Session, 1), disconnected: make(chan error, 1), msgs: make(chan proto.Message, 1)}
+	acceptor := NewAcceptor(queue, log, false)
+	acceptor.SetCallback(d)
+	server := httptest.NewServer(acceptor)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	r.NoError(err)
+	defer conn.Close()
+	var sess *Session
+	select {
+	case sess = <-d.connected:
+	case <-time.After(time.Second * 3):
+		r.FailNow("not connected")
+	}
+	r.Equal(1, acceptor.SessionCount())
+	sess.Set("k", 1)
+
+	name := string(proto.MessageName(&basepb.Base_Error{}))
+	r.NoError(conn.WriteJSON([]string{"", name, `{"error_code":1}`}))
+	select {
+	case m := <-d.msgs:
+		r.EqualValues(1, m.(*basepb.Base_Error).ErrorCode)
+	case <-time.After(time.Second * 3):
+		r.FailNow("no message")
+	}
+
+	// rpc 请求的回复带上相同的 Index
+	r.NoError(conn.WriteJSON([]string{"7", name, `{"error_code":1}`}))
+	var resp []string
+	r.NoError(conn.ReadJSON(&resp))
+	r.Equal([]string{"7", name, `{"error_code":"2"}`, "2"}, resp)
+
+	// 服务器发给客户端的 rpc 请求
+	done := make(chan error, 1)
+	go func() {
+		out := &basepb.Base_Success{}
+		done <- SyncRequest(sess, &basepb.Base_Ping{}, out)
+	}()
+	var req []string
+	r.NoError(conn.ReadJSON(&req))
+	r.Len(req, 4)
+	r.Equal("1", req[3])
+	r.NoError(conn.WriteJSON([]string{req[0], string(proto.MessageName(&basepb.Base_Success{})), `{}`, "2"}))
+	r.NoError(<-done)
+	r.Equal(0, sess.PendingRPC())
+
+	acceptor.Close()
+	select {
+	case err = <-d.disconnected:
+		r.Equal(ErrAcceptorClosed, err)
+	case <-time.After(time.Second * 3):
+		r.FailNow("not disconnected")
+	}
+	r.Equal(0, acceptor.SessionCount())
+	r.Nil(sess.Get("k"))
+}
+
+func TestAcceptorHeartbeat(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := eventqueue.NewEventQueue(100, log)
+	queue.Run(nil, DispatchMsg(func(interface{}) {}))
+
+	d := &recordDispatch{connected: make(chan *Session, 1), disconnected: make(chan error, 1), msgs: make(chan proto.Message, 1)}
+	acceptor := NewAcceptor(queue, log, false)
+	acceptor.SetCallback(d)
+	acceptor.SetReadIdleTimeout(time.Millisecond * 100)
+	acceptor.SetHeartbeat(tcp.HeartbeatConfig{Interval: time.Millisecond * 20, Timeout: time.Millisecond * 150})
+	server := httptest.NewServer(acceptor)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// 不发送消息的客户端，读的时候自动回复 pong，连接保持
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	r.NoError(err)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	var sess *Session
+	select {
+	case sess = <-d.connected:
+	case <-time.After(time.Second * 3):
+		r.FailNow("not connected")
+	}
+	select {
+	case err := <-d.disconnected:
+		r.FailNow("idle client disconnected", "%v", err)
+	case <-time.After(time.Millisecond * 300):
+	}
+	r.True(sess.RTT() > 0)
+	_ = conn.Close()
+	select {
+	case <-d.disconnected:
+	case <-time.After(time.Second * 3):
+		r.FailNow("not disconnected")
+	}
+
+	// 不回复 pong 的客户端心跳超时
+	acceptor.SetReadIdleTimeout(0)
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	r.NoError(err)
+	defer conn.Close()
+	<-d.connected
+	select {
+	case err := <-d.disconnected:
+		r.Equal(tcp.ErrHeartbeatTimeout, err)
+	case <-time.After(time.Second * 3):
+		r.FailNow("heartbeat not timeout")
+	}
+}
+
+func TestAcceptorProtoIndex(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := eventqueue.NewEventQueue(100, log)
+	queue.Run(nil, DispatchMsg(func(interface{}) {}))
+
+	d := &recordDispatch{connected: make(chan *Session, 1), disconnected: make(chan error, 1), msgs: make(chan proto.Message, 1)}
+	acceptor := NewAcceptor(queue, log, false)
+	acceptor.SetCallback(d)
+	acceptor.SetContextType(ContextTypeProto)
+	server := httptest.NewServer(acceptor)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	r.NoError(err)
+	defer conn.Close()
+	<-d.connected
+
+	// 二进制格式没法回复不是数字的 index，丢掉这个请求，连接保持
+	name := string(proto.MessageName(&basepb.Base_Error{}))
+	r.NoError(conn.WriteJSON([]string{"a", name, `{"error_code":1}`}))
+	r.NoError(conn.WriteJSON([]string{"7", name, `{"error_code":2}`}))
+	mt, data, err := conn.ReadMessage()
+	r.NoError(err)
+	p, err := decode(mt, data)
+	r.NoError(err)
+	r.Equal(packetProtocolRPCResponse, p.Protocol)
+	r.Equal("7", p.Index)
+	r.EqualValues(3, p.Msg.(*basepb.Base_Error).ErrorCode)
+	r.Equal(1, acceptor.SessionCount())
+}
@@ -0,0 +1,479 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/basepb"
+	"github.com/njmdk/common/network/tcp"
+	"github.com/njmdk/common/utils"
+)
+
+// 连接状态和 tcp.Connector 一样
+const (
+	ConnectStateInvalid      = tcp.ConnectStateInvalid
+	ConnectStateConnecting   = tcp.ConnectStateConnecting
+	ConnectStateConnected    = tcp.ConnectStateConnected
+	ConnectStateDisconnected = tcp.ConnectStateDisconnected
+)
+
+// ConnectorInfo 连接成功、连接失败和断开时投递到 EventQueue，Error 为 *tcp.ConnectError 或者 *tcp.CloseError
+type ConnectorInfo struct {
+	Connector *Connector
+	Error     error
+	sess      *Session
+}
+
+// GetSession 事件对应的连接，连接失败时为 nil
+func (this_ *ConnectorInfo) GetSession() *Session {
+	return this_.sess
+}
+
+// Connector websocket 客户端，和 tcp.Connector 的用法一样，事件投递到 EventQueue，用 DispatchMsg 分发
+//
+//	c := ws.NewConnector("wss://host/ws", queue, log, "gate", ws.WithReconnect(), ws.WithHeader(h))
+//	c.Connect()
+type Connector struct {
+	isReconnect    bool
+	ci             DispatchInterface
+	cf             func(connector *Connector, err error)
+	sc             func(c *Connector, old int64, new int64)
+	connectState   int64
+	url            string
+	ConnectTimeout time.Duration
+	queue          *eventqueue.EventQueue
+	sess           *Session
+	logger         *logger.Logger
+	ConnectorName  string
+	isDebugLog     bool
+	contextType    string
+	maxMessageLen  int64
+	rpcTimeout     time.Duration
+
+	header    http.Header
+	cookies   []*http.Cookie
+	jar       http.CookieJar
+	proxy     func(*http.Request) (*url.URL, error)
+	tlsConfig *tls.Config
+
+	heartbeat        tcp.HeartbeatConfig
+	policy           tcp.ReconnectPolicy
+	reconnectAttempt int
+	stateMu          sync.Mutex
+	stateCh          chan struct{}
+}
+
+type Option func(c *Connector)
+
+func WithOpenDebugLog() Option {
+	return func(c *Connector) {
+		c.isDebugLog = true
+	}
+}
+
+// WithReconnect 断开或者连接失败后使用 tcp.DefaultReconnectPolicy 重连
+func WithReconnect() Option {
+	return func(c *Connector) {
+		c.isReconnect = true
+		c.policy = tcp.DefaultReconnectPolicy
+	}
+}
+
+// WithReconnectPolicy 断开或者连接失败后按照 p 重连
+func WithReconnectPolicy(p tcp.ReconnectPolicy) Option {
+	return func(c *Connector) {
+		c.isReconnect = true
+		c.policy = p
+	}
+}
+
+// WithHeartbeat 设置心跳，默认每10秒发送一次 ping，30秒没有收到 pong 断开
+func WithHeartbeat(cfg tcp.HeartbeatConfig) Option {
+	return func(c *Connector) {
+		if cfg.Interval <= 0 {
+			cfg.Interval = tcp.DefaultHeartbeat.Interval
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = cfg.Interval * 3
+		}
+		c.heartbeat = cfg
+	}
+}
+
+// WithHeader 升级请求附带的 header，比如 Authorization、Origin
+func WithHeader(h http.Header) Option {
+	return func(c *Connector) {
+		for k, v := range h {
+			for _, vv := range v {
+				c.header.Add(k, vv)
+			}
+		}
+	}
+}
+
+// WithCookies 升级请求附带的 cookie，只使用 Name 和 Value
+func WithCookies(cookies ...*http.Cookie) Option {
+	return func(c *Connector) {
+		c.cookies = append(c.cookies, cookies...)
+	}
+}
+
+// WithCookieJar 升级请求从 jar 中取 cookie，服务器返回的 Set-Cookie 也会保存到 jar 中
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(c *Connector) {
+		c.jar = jar
+	}
+}
+
+// WithProxy 通过 http 代理连接，默认使用环境变量 HTTP_PROXY/HTTPS_PROXY，比如 WithProxy(http.ProxyURL(u))
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(c *Connector) {
+		c.proxy = proxy
+	}
+}
+
+// WithTLS wss 使用的 tls 配置
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Connector) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithContextType 发送消息的格式，默认 ContextTypeJSON
+func WithContextType(contextType string) Option {
+	return func(c *Connector) {
+		c.contextType = contextType
+	}
+}
+
+// WithMaxMessageLen 设置单个消息的最大长度，默认1M
+func WithMaxMessageLen(n int64) Option {
+	return func(c *Connector) {
+		c.maxMessageLen = n
+	}
+}
+
+// WithRPCTimeout 设置 Request 等待返回的超时时间，默认2秒
+func WithRPCTimeout(timeout time.Duration) Option {
+	return func(c *Connector) {
+		c.rpcTimeout = timeout
+	}
+}
+
+// WithConnectTimeout 连接和升级的超时时间，默认3秒
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(c *Connector) {
+		c.ConnectTimeout = timeout
+	}
+}
+
+func NewConnector(rawURL string, queue *eventqueue.EventQueue, logger *logger.Logger, connectorName string, options ...Option) *Connector {
+	c := &Connector{
+		url:            rawURL,
+		ConnectTimeout: time.Second * 3,
+		queue:          queue,
+		logger:         logger,
+		ConnectorName:  connectorName,
+		contextType:    ContextTypeJSON,
+		maxMessageLen:  defaultMaxMessageLen,
+		rpcTimeout:     defaultRPCTimeout,
+		header:         http.Header{},
+		proxy:          http.ProxyFromEnvironment,
+		heartbeat:      tcp.DefaultHeartbeat,
+		stateCh:        make(chan struct{}),
+	}
+	for _, v := range options {
+		v(c)
+	}
+	return c
+}
+
+func (this_ *Connector) GetURL() string {
+	return this_.url
+}
+
+func (this_ *Connector) SetOnConnectFailed(f func(connector *Connector, err error)) {
+	this_.cf = f
+}
+
+// SetCallback 当前连接和之后重连的连接都生效
+func (this_ *Connector) SetCallback(ci DispatchInterface) {
+	this_.ci = ci
+}
+
+// SetOnStateChange 连接状态变化时在 queue 中调用 f
+func (this_ *Connector) SetOnStateChange(f func(c *Connector, old int64, new int64)) {
+	this_.sc = f
+}
+
+func (this_ *Connector) GetSession() *Session {
+	return this_.sess
+}
+
+// State 当前的连接状态 ConnectStateInvalid/ConnectStateConnecting/ConnectStateConnected/ConnectStateDisconnected
+func (this_ *Connector) State() int64 {
+	return atomic.LoadInt64(&this_.connectState)
+}
+
+// WaitConnected 阻塞直到连接成功或者 ctx 结束，别在 queue 中调用
+func (this_ *Connector) WaitConnected(ctx context.Context) error {
+	for {
+		this_.stateMu.Lock()
+		ch := this_.stateCh
+		this_.stateMu.Unlock()
+		if this_.State() == ConnectStateConnected {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+func (this_ *Connector) setConnectState(state int64) {
+	old := atomic.SwapInt64(&this_.connectState, state)
+	if old == state {
+		return
+	}
+	this_.stateMu.Lock()
+	close(this_.stateCh)
+	this_.stateCh = make(chan struct{})
+	this_.stateMu.Unlock()
+	if sc := this_.sc; sc != nil {
+		this_.queue.Post(func() {
+			sc(this_, old, state)
+		})
+	}
+}
+
+func (this_ *Connector) OnSessionConnected(s *Session) {
+	if this_.isDebugLog {
+		this_.logger.Debug("websocket connect success", zap.String("url", this_.url))
+	}
+	this_.reconnectAttempt = 0
+	if this_.ci != nil {
+		this_.ci.OnSessionConnected(s)
+	}
+}
+
+// 连接失败时 s 为 nil
+func (this_ *Connector) OnSessionDisConnected(s *Session, err error) {
+	if this_.isDebugLog {
+		this_.logger.Debug("websocket connect disconnected", zap.Error(err), zap.String("url", this_.url))
+	}
+	if s == nil && this_.cf != nil {
+		this_.cf(this_, err)
+	} else if this_.isReconnect {
+		this_.reconnect()
+	}
+	if this_.ci != nil {
+		this_.ci.OnSessionDisConnected(s, err)
+	}
+}
+
+func (this_ *Connector) OnRPCRequest(s *Session, msg proto.Message) proto.Message {
+	if this_.isDebugLog {
+		this_.logger.Debug("OnRPCRequest", zap.String("url", this_.url), zap.String("msg name", string(proto.MessageName(msg))), zap.Any("msg", msg))
+	}
+	if this_.ci != nil {
+		return this_.ci.OnRPCRequest(s, msg)
+	}
+	return &basepb.Base_Success{}
+}
+
+func (this_ *Connector) OnNormalMsg(s *Session, msg proto.Message) {
+	if this_.isDebugLog {
+		this_.logger.Debug("OnNormalMsg", zap.String("url", this_.url), zap.String("msg name", string(proto.MessageName(msg))), zap.Any("msg", msg))
+	}
+	if this_.ci != nil {
+		this_.ci.OnNormalMsg(s, msg)
+	}
+}
+
+// 在 queue 中调用
+func (this_ *Connector) reconnect() {
+	this_.reconnectAttempt++
+	policy := this_.policy
+	if policy == nil {
+		policy = tcp.DefaultReconnectPolicy
+	}
+	d, ok := policy.NextDelay(this_.reconnectAttempt)
+	if !ok {
+		this_.logger.WarnFormat("websocket connector %s reconnect %s give up after %d attempts", this_.ConnectorName, this_.url, this_.reconnectAttempt-1)
+		if this_.cf != nil {
+			this_.cf(this_, tcp.ErrReconnectGiveUp)
+		}
+		return
+	}
+	if d <= 0 {
+		this_.Connect()
+		return
+	}
+	this_.queue.AfterFunc(d, func(time.Time) {
+		this_.Connect()
+	})
+}
+
+func (this_ *Connector) Send(msg proto.Message) (err error) {
+	sess := this_.sess
+	if sess == nil {
+		this_.logger.Error("Send msg failed,session not connected", zap.String("msgID", string(proto.MessageName(msg))), zap.Any("data", msg))
+		return ErrSessionClosed
+	}
+	err = sess.Send(msg)
+	if err != nil {
+		this_.logger.Error("Send msg error", zap.Error(err), zap.String("msgID", string(proto.MessageName(msg))), zap.Any("data", msg))
+	}
+	return
+}
+
+func (this_ *Connector) SendNoError(msg proto.Message) {
+	_ = this_.Send(msg)
+}
+
+func (this_ *Connector) Request(msg proto.Message, resp RPCResponse) error {
+	sess := this_.sess
+	if sess == nil {
+		if resp != nil {
+			resp(&basepb.Base_Error{
+				ErrorCode:    1,
+				ErrorMessage: "connector not connected",
+			})
+		}
+		return ErrSessionClosed
+	}
+	return sess.Request(msg, resp)
+}
+
+func (this_ *Connector) RequestNoError(msg proto.Message, resp RPCResponse) {
+	sess := this_.sess
+	if sess != nil {
+		sess.RequestNoError(msg, resp)
+	} else if resp != nil {
+		resp(&basepb.Base_Error{
+			ErrorCode:    1,
+			ErrorMessage: "connector not connected",
+		})
+	}
+}
+
+// RequestContext 见 Session.RequestContext，未连接时返回 ErrSessionClosed
+func (this_ *Connector) RequestContext(ctx context.Context, msg proto.Message) (proto.Message, error) {
+	sess := this_.sess
+	if sess == nil {
+		return nil, ErrSessionClosed
+	}
+	return sess.RequestContext(ctx, msg)
+}
+
+// SyncRequestContext 见 Session.SyncRequestContext，未连接时返回 ErrSessionClosed
+func (this_ *Connector) SyncRequestContext(ctx context.Context, msg proto.Message, out proto.Message) error {
+	sess := this_.sess
+	if sess == nil {
+		return ErrSessionClosed
+	}
+	return sess.SyncRequestContext(ctx, msg, out)
+}
+
+func (this_ *Connector) Close(err error) {
+	if this_.State() == ConnectStateConnected {
+		if sess := this_.sess; sess != nil {
+			sess.Close(err)
+		}
+		this_.setConnectState(ConnectStateDisconnected)
+	}
+}
+
+func (this_ *Connector) requestHeader() http.Header {
+	h := this_.header.Clone()
+	if len(this_.cookies) > 0 {
+		pairs := make([]string, 0, len(this_.cookies))
+		for _, v := range this_.cookies {
+			pairs = append(pairs, (&http.Cookie{Name: v.Name, Value: v.Value}).String())
+		}
+		h.Add("Cookie", strings.Join(pairs, "; "))
+	}
+	return h
+}
+
+func (this_ *Connector) dial() (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{
+		Proxy:            this_.proxy,
+		TLSClientConfig:  this_.tlsConfig,
+		HandshakeTimeout: this_.ConnectTimeout,
+		Jar:              this_.jar,
+	}
+	conn, resp, err := dialer.Dial(this_.url, this_.requestHeader())
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%w: %s", err, resp.Status)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (this_ *Connector) Connect() {
+	connectState := this_.State()
+	if !this_.queue.Stopped() && (connectState == ConnectStateInvalid || connectState == ConnectStateDisconnected) {
+		this_.setConnectState(ConnectStateConnecting)
+		utils.SafeGO(func(e interface{}) {
+			this_.Close(fmt.Errorf("%+v", e))
+			this_.setConnectState(ConnectStateInvalid)
+		}, func() {
+			conn, err := this_.dial()
+			if err != nil {
+				this_.setConnectState(ConnectStateInvalid)
+				this_.queue.Post(&ConnectorInfo{
+					Connector: this_,
+					Error:     &tcp.ConnectError{Err: err},
+				})
+				return
+			}
+			this_.startSession(conn)
+		})
+	}
+}
+
+func (this_ *Connector) startSession(conn *websocket.Conn) {
+	conn.SetReadLimit(this_.maxMessageLen)
+	sess := NewClient(this_.ConnectorName, conn, func(s *Session, err error) {
+		this_.setConnectState(ConnectStateDisconnected)
+		this_.queue.Post(&ConnectorInfo{
+			Connector: this_,
+			Error:     &tcp.CloseError{Err: err},
+			sess:      s,
+		})
+	}, func(s *Session, p *packet) {
+		this_.queue.Post(&Packet{Sess: s, Protocol: p.Protocol, Index: p.Index, Msg: p.Msg})
+	})
+	sess.log = this_.logger
+	sess.contextType = this_.contextType
+	sess.readIdleTimeout = this_.heartbeat.ReadIdleTimeout
+	sess.queue = this_.queue
+	sess.SetRPCTimeout(this_.rpcTimeout)
+	sess.Dispatch = this_
+	this_.sess = sess
+	this_.setConnectState(ConnectStateConnected)
+	this_.queue.Post(&ConnectorInfo{
+		Connector: this_,
+		sess:      sess,
+	})
+	sess.Start()
+	startHeartbeat(this_.queue, sess, this_.heartbeat)
+}
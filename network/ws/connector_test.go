@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/network/basepb"
+	"github.com/njmdk/common/network/tcp"
+)
+
+func TestConnector(t *testing.T) {
+	r := require.New(t)
+	log := newTestLogger(t)
+	queue := eventqueue.NewEventQueue(100, log)
+	queue.Run(nil, DispatchMsg(func(interface{}) {}))
+
+	server := &recordDispatch{connected: make(chan *Session, 2), disconnected: make(chan error, 2), msgs: make(chan proto.Message, 2)}
+	acceptor := NewAcceptor(queue, log, false)
+	acceptor.SetCallback(server)
+	headers := make(chan http.Header, 2)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers <- req.Header
+		acceptor.ServeHTTP(w, req)
+	}))
+	defer hs.Close()
+
+	client := &recordDispatch{connected: make(chan *Session, 2), disconnected: make(chan error, 2), msgs: make(chan proto.Message, 2)}
+	c := NewConnector("ws"+strings.TrimPrefix(hs.URL, "http"), queue, log, "test",
+		WithHeader(http.Header{"Authorization": []string{"token"}}),
+		WithCookies(&http.Cookie{Name: "a", Value: "1"}, &http.Cookie{Name: "b", Value: "2"}),
+		WithContextType(ContextTypeProto),
+		WithHeartbeat(tcp.HeartbeatConfig{Interval: time.Millisecond * 20}),
+		WithReconnectPolicy(&tcp.ExponentialBackoff{Initial: time.Millisecond * 10}),
+	)
+	c.SetCallback(client)
+	c.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	r.NoError(c.WaitConnected(ctx))
+
+	h := <-headers
+	r.Equal("token", h.Get("Authorization"))
+	r.Equal("a=1; b=2", h.Get("Cookie"))
+	sess := <-server.connected
+	<-client.connected
+
+	// 对端返回 Base_Error 时是 RemoteError
+	_, err := c.RequestContext(ctx, &basepb.Base_Error{ErrorCode: 5})
+	r.IsType(&tcp.RemoteError{}, err)
+	r.EqualValues(6, err.(*tcp.RemoteError).Code)
+
+	r.NoError(sess.Send(&basepb.Base_Error{ErrorCode: 7}))
+	r.EqualValues(7, (<-client.msgs).(*basepb.Base_Error).ErrorCode)
+
+	r.Eventually(func() bool { return c.GetSession().RTT() > 0 }, time.Second*3, time.Millisecond*10)
+
+	// 服务器断开之后重连
+	sess.Close(nil)
+	r.Error(<-client.disconnected)
+	<-client.connected
+	r.Equal(ConnectStateConnected, int(c.State()))
+	r.NotEqual(sess, <-server.connected)
+}
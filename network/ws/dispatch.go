@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/handler"
+)
+
+// DispatchMsg 分发 websocket 的事件，其他事件交给 f，可以和 tcp.DispatchMsg 串起来使用同一个 EventQueue
+func DispatchMsg(f func(event interface{})) func(event interface{}) {
+	if f == nil {
+		panic("f==nil")
+	}
+	return func(event interface{}) {
+		switch e := event.(type) {
+		case *AcceptSession:
+			if e.Dispatch != nil {
+				e.Dispatch.OnSessionConnected(e.Session)
+			} else {
+				f(event)
+			}
+		case *SessionClosed:
+			if e.Sess.Dispatch != nil {
+				e.Sess.Dispatch.OnSessionDisConnected(e.Sess, e.Err)
+			} else {
+				f(event)
+			}
+			// OnSessionDisConnected 中还可以读取
+			e.Sess.ClearAttributes()
+		case *ConnectorInfo:
+			sess := e.GetSession()
+			if sess == nil {
+				// 连接失败
+				e.Connector.OnSessionDisConnected(nil, e.Error)
+				return
+			}
+			if e.Error == nil {
+				sess.Dispatch.OnSessionConnected(sess)
+				return
+			}
+			sess.Dispatch.OnSessionDisConnected(sess, e.Error)
+			sess.ClearAttributes()
+		case *Packet:
+			sess := e.Sess
+			if e.Protocol == packetProtocolRPCResponse {
+				sess.response(e.Index, e.Msg)
+				return
+			}
+			if sess.Dispatch == nil {
+				f(event)
+				return
+			}
+			if e.Protocol == packetProtocolNormal {
+				sess.Dispatch.OnNormalMsg(sess, e.Msg)
+				return
+			}
+			resp := sess.Dispatch.OnRPCRequest(sess, e.Msg)
+			if err := sess.sendResponse(e.Index, resp); err != nil && err != ErrSessionClosed {
+				// 一个返回发送失败不关闭连接，对端的请求会超时
+				sess.log.Error("send response msg failed", zap.Error(err), zap.String("id", sess.id), zap.String("index", e.Index))
+			}
+		default:
+			f(event)
+		}
+	}
+}
+
+var _ handler.Session = (*Session)(nil)
+
+type sharedDispatch struct {
+	d handler.DispatchInterface
+}
+
+// WrapDispatch 把和 tcp 共用的 handler.DispatchInterface 转成 DispatchInterface
+func WrapDispatch(d handler.DispatchInterface) DispatchInterface {
+	return &sharedDispatch{d: d}
+}
+
+func (this_ *sharedDispatch) OnSessionConnected(s *Session) {
+	this_.d.OnSessionConnected(s)
+}
+
+func (this_ *sharedDispatch) OnSessionDisConnected(s *Session, err error) {
+	this_.d.OnSessionDisConnected(s, err)
+}
+
+func (this_ *sharedDispatch) OnRPCRequest(s *Session, msg proto.Message) proto.Message {
+	return this_.d.OnRPCRequest(s, msg)
+}
+
+func (this_ *sharedDispatch) OnNormalMsg(s *Session, msg proto.Message) {
+	this_.d.OnNormalMsg(s, msg)
+}
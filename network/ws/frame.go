@@ -0,0 +1,177 @@
+package ws
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/njmdk/common/utils"
+)
+
+// 消息有两种格式，收到时两种都能解析，发送时使用 session 的 contextType
+//
+// ContextTypeJSON 文本帧，json 数组 [index, msgName, body] 或者 [index, msgName, body, protocol]
+// body 为 protojson，只有三个元素时 index 不为空表示 rpc 请求，rpc 返回带上第四个元素 "2"
+//
+// ContextTypeProto 二进制帧，和 tcp 的包格式一样，长度由 websocket 帧给出，没有 totalLen
+// msgIDLen(1) + packetProtocol(1) + rpcIndex(4) + msgID + body，body 为 protobuf
+const (
+	ContextTypeJSON  = "application/json"
+	ContextTypeProto = "application/x-protobuf"
+)
+
+type packetProtocol uint8
+
+// 和 tcp 的 packetProtocol 取值一样
+const (
+	packetProtocolNormal      packetProtocol = 0
+	packetProtocolRPCRequest  packetProtocol = 1
+	packetProtocolRPCResponse packetProtocol = 2
+)
+
+const (
+	msgIDLenLen       = 1
+	packetProtocolLen = 1
+	rpcIndexLen       = 4
+	headerLen         = msgIDLenLen + packetProtocolLen + rpcIndexLen
+)
+
+var errInvalidRPCIndex = errors.New("invalid rpc index")
+
+func (this_ *Session) isBinary() bool {
+	return this_.contextType == ContextTypeProto
+}
+
+func (this_ *Session) encode(p *packet) (int, []byte, error) {
+	msgName := string(proto.MessageName(p.Msg))
+	if this_.isBinary() {
+		body, err := proto.Marshal(p.Msg)
+		if err != nil {
+			return 0, nil, err
+		}
+		data, err := encodeBinary(p.Protocol, p.Index, msgName, body)
+		return websocket.BinaryMessage, data, err
+	}
+	body, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(p.Msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	arr := []string{p.Index, msgName, utils.BytesToString(body)}
+	if p.Protocol != packetProtocolNormal {
+		arr = append(arr, strconv.Itoa(int(p.Protocol)))
+	}
+	data, err := json.Marshal(arr)
+	return websocket.TextMessage, data, err
+}
+
+// 二进制格式的 rpcIndex 是数字，文本帧发来的 rpc 请求 index 不是数字时没法用二进制格式回复
+func (this_ *Session) checkIndex(p *packet) error {
+	if !this_.isBinary() || p.Protocol != packetProtocolRPCRequest {
+		return nil
+	}
+	if _, err := strconv.ParseUint(p.Index, 10, 32); err != nil {
+		return errInvalidRPCIndex
+	}
+	return nil
+}
+
+func encodeBinary(pp packetProtocol, index string, msgName string, body []byte) ([]byte, error) {
+	if len(msgName) > 0xff {
+		return nil, errInvalidMsg
+	}
+	var rpcIndex uint64
+	if index != "" {
+		var err error
+		rpcIndex, err = strconv.ParseUint(index, 10, 32)
+		if err != nil {
+			return nil, errInvalidRPCIndex
+		}
+	}
+	data := make([]byte, headerLen, headerLen+len(msgName)+len(body))
+	data[0] = uint8(len(msgName))
+	data[msgIDLenLen] = byte(pp)
+	binary.LittleEndian.PutUint32(data[msgIDLenLen+packetProtocolLen:], uint32(rpcIndex))
+	data = append(data, msgName...)
+	return append(data, body...), nil
+}
+
+func decode(messageType int, data []byte) (*packet, error) {
+	switch messageType {
+	case websocket.BinaryMessage:
+		return decodeBinary(data)
+	case websocket.TextMessage:
+		return decodeJSON(data)
+	}
+	return nil, errInvalidMsg
+}
+
+func decodeBinary(data []byte) (*packet, error) {
+	if len(data) < headerLen {
+		return nil, errInvalidMsg
+	}
+	msgIDLen := int(data[0])
+	pp := packetProtocol(data[msgIDLenLen])
+	rpcIndex := binary.LittleEndian.Uint32(data[msgIDLenLen+packetProtocolLen:])
+	if len(data) < headerLen+msgIDLen || pp > packetProtocolRPCResponse {
+		return nil, errInvalidMsg
+	}
+	msg, err := newMsg(string(data[headerLen : headerLen+msgIDLen]))
+	if err != nil {
+		return nil, err
+	}
+	if err = proto.Unmarshal(data[headerLen+msgIDLen:], msg); err != nil {
+		return nil, err
+	}
+	p := &packet{Protocol: pp, Msg: msg}
+	if rpcIndex != 0 {
+		p.Index = strconv.FormatUint(uint64(rpcIndex), 10)
+	}
+	return p, nil
+}
+
+func decodeJSON(data []byte) (*packet, error) {
+	var arr []string
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return nil, err
+	}
+	p := &packet{}
+	switch len(arr) {
+	case 3:
+		if arr[0] != "" {
+			p.Protocol = packetProtocolRPCRequest
+		}
+	case 4:
+		pp, err := strconv.ParseUint(arr[3], 10, 8)
+		if err != nil || pp > uint64(packetProtocolRPCResponse) {
+			return nil, errInvalidMsg
+		}
+		p.Protocol = packetProtocol(pp)
+	default:
+		return nil, errInvalidMsg
+	}
+	msg, err := newMsg(arr[1])
+	if err != nil {
+		return nil, err
+	}
+	if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(utils.StringToBytes(arr[2]), msg); err != nil {
+		return nil, err
+	}
+	p.Index = arr[0]
+	p.Msg = msg
+	return p, nil
+}
+
+func newMsg(name string) (proto.Message, error) {
+	t, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, errInvalidMsg
+	}
+	return t.New().Interface(), nil
+}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/network/basepb"
+)
+
+func TestFrame(t *testing.T) {
+	r := require.New(t)
+	msg := &basepb.Base_Error{ErrorCode: 3, ErrorMessage: "错误"}
+
+	for _, contextType := range []string{ContextTypeJSON, ContextTypeProto} {
+		s := &Session{contextType: contextType}
+		for _, p := range []*packet{
+			{Protocol: packetProtocolNormal, Msg: msg},
+			{Protocol: packetProtocolRPCRequest, Index: "7", Msg: msg},
+			{Protocol: packetProtocolRPCResponse, Index: "7", Msg: msg},
+		} {
+			mt, data, err := s.encode(p)
+			r.NoError(err)
+			got, err := decode(mt, data)
+			r.NoError(err)
+			r.Equal(p.Protocol, got.Protocol)
+			r.Equal(p.Index, got.Index)
+			r.True(proto.Equal(msg, got.Msg))
+		}
+	}
+
+	// 二进制帧的 Index 只能是数字
+	_, _, err := (&Session{contextType: ContextTypeProto}).encode(&packet{Protocol: packetProtocolRPCResponse, Index: "a", Msg: msg})
+	r.Equal(errInvalidRPCIndex, err)
+	sess := &Session{contextType: ContextTypeProto}
+	r.Equal(errInvalidRPCIndex, sess.checkIndex(&packet{Protocol: packetProtocolRPCRequest, Index: "a"}))
+	r.Equal(errInvalidRPCIndex, sess.checkIndex(&packet{Protocol: packetProtocolRPCRequest, Index: "4294967296"}))
+	r.NoError(sess.checkIndex(&packet{Protocol: packetProtocolRPCRequest, Index: "7"}))
+	r.NoError(sess.checkIndex(&packet{Protocol: packetProtocolRPCResponse, Index: "a"}))
+	r.NoError((&Session{contextType: ContextTypeJSON}).checkIndex(&packet{Protocol: packetProtocolRPCRequest, Index: "a"}))
+
+	// 旧的三个元素的格式，Index 不为空是 rpc 请求
+	p, err := decode(websocket.TextMessage, []byte(`["a","basepb.base.Error","{}"]`))
+	r.NoError(err)
+	r.Equal(packetProtocolRPCRequest, p.Protocol)
+	p, err = decode(websocket.TextMessage, []byte(`["","basepb.base.Error","{}"]`))
+	r.NoError(err)
+	r.Equal(packetProtocolNormal, p.Protocol)
+
+	_, err = decode(websocket.BinaryMessage, []byte{200, 0, 0, 0, 0, 0})
+	r.Equal(errInvalidMsg, err)
+	_, err = decode(websocket.TextMessage, []byte(`["","basepb.base.Error","{}","9"]`))
+	r.Equal(errInvalidMsg, err)
+}
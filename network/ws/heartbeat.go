@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/network/tcp"
+)
+
+// 心跳使用 websocket 的 ping/pong 控制帧，浏览器会自动回复 pong，不需要客户端处理
+
+// RTT 最近一次 ping/pong 测得的往返延迟，还没测到时返回0
+func (this_ *Session) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this_.rtt))
+}
+
+// LastPongTime 最近一次收到对端 ping 或者 pong 的时间
+func (this_ *Session) LastPongTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&this_.lastPongTime))
+}
+
+func (this_ *Session) onPingPong(isPong bool) {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&this_.lastPongTime, now)
+	if isPong {
+		if sent := atomic.SwapInt64(&this_.pingSentAt, 0); sent != 0 {
+			atomic.StoreInt64(&this_.rtt, now-sent)
+		}
+	}
+	this_.extendReadDeadline()
+}
+
+// WriteControl 可以和其他的写同时调用
+func (this_ *Session) sendPing() error {
+	atomic.CompareAndSwapInt64(&this_.pingSentAt, 0, time.Now().UnixNano())
+	return this_.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+}
+
+// 在 queue 中每隔 Interval 发送一次 ping，超过 Timeout 没有收到 pong 关闭连接，连接关闭之后停止
+func startHeartbeat(queue *eventqueue.EventQueue, sess *Session, cfg tcp.HeartbeatConfig) {
+	atomic.StoreInt64(&sess.lastPongTime, time.Now().UnixNano())
+	queue.Tick(cfg.Interval, func(now time.Time) bool {
+		if sess.isClosed() {
+			return false
+		}
+		if now.Sub(sess.LastPongTime()) > cfg.Timeout {
+			sess.Close(tcp.ErrHeartbeatTimeout)
+			return false
+		}
+		if err := sess.sendPing(); err != nil {
+			sess.Close(err)
+			return false
+		}
+		return true
+	})
+}
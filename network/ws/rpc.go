@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njmdk/common/eventqueue"
+	"github.com/njmdk/common/network/basepb"
+	"github.com/njmdk/common/network/tcp"
+)
+
+// 和 tcp 用同一个错误，同时处理 tcp 和 websocket 的代码不用区分
+var ErrRPCTimeout = tcp.ErrRPCTimeout
+
+// 默认2秒，和 tcp.Connector 一样
+const defaultRPCTimeout = time.Second * 2
+
+type RPCResponse = tcp.RPCResponse
+
+func (this_ *Session) getRPCRequestIndex() uint32 {
+	return atomic.AddUint32(&this_.rpcIndex, 1)
+}
+
+// SetRPCTimeout 设置 Request 等待返回的超时时间，最小500毫秒
+func (this_ *Session) SetRPCTimeout(timeout time.Duration) {
+	if timeout < time.Millisecond*500 {
+		timeout = time.Millisecond * 500
+	}
+	this_.rpcTimeout = timeout
+}
+
+// Request 发送 rpc 请求，返回的消息在 EventQueue 中回调 resp，超时回调 basepb.Base_Error
+func (this_ *Session) Request(msg proto.Message, resp RPCResponse) error {
+	_, err := this_.request(msg, resp, this_.rpcTimeout)
+	return err
+}
+
+// 等待返回的 rpc 回调和它的超时定时器
+type rpcCall struct {
+	resp  RPCResponse
+	timer eventqueue.TimerID
+}
+
+// timeout <= 0 或者没有 queue 时不设置超时，由调用者自己负责清理 rpcFunc
+func (this_ *Session) request(msg proto.Message, resp RPCResponse, timeout time.Duration) (uint32, error) {
+	index := this_.getRPCRequestIndex()
+
+	call := &rpcCall{resp: resp}
+	if timeout > 0 && this_.queue != nil {
+		call.timer = this_.queue.AfterFunc(timeout, func(_ time.Time) {
+			if call, ok := this_.deleteRPC(index); ok {
+				this_.log.Error("recv response msg timeout", zap.String("id", this_.id),
+					zap.String("msg name", string(proto.MessageName(msg))), zap.Any("msg", msg), zap.Uint32("index", index))
+				call.resp(&basepb.Base_Error{
+					ErrorCode:    1,
+					ErrorMessage: "RPCResponse Timeout",
+				})
+			}
+		})
+	}
+	// 先保存回调，避免发送之后返回的太快找不到回调
+	this_.storeRPC(index, call)
+	err := this_.send(packetProtocolRPCRequest, strconv.FormatUint(uint64(index), 10), msg)
+	if err != nil {
+		this_.deleteRPC(index)
+		return 0, err
+	}
+	return index, nil
+}
+
+func (this_ *Session) RequestNoError(msg proto.Message, resp RPCResponse) {
+	err := this_.Request(msg, resp)
+	if err != nil {
+		resp(&basepb.Base_Error{
+			ErrorCode:    1,
+			ErrorMessage: "RPCRequest send failed",
+		})
+		this_.log.Error("request msg error", zap.Error(err), zap.String("id", this_.id),
+			zap.String("msg name", string(proto.MessageName(msg))), zap.Any("msg", msg))
+		this_.Close(err)
+	}
+}
+
+func (this_ *Session) storeRPC(index uint32, call *rpcCall) {
+	atomic.AddInt32(&this_.pendingRPC, 1)
+	this_.rpcFunc.Store(index, call)
+}
+
+// 删除回调的同时停止超时定时器
+func (this_ *Session) deleteRPC(index uint32) (*rpcCall, bool) {
+	v, ok := this_.rpcFunc.LoadAndDelete(index)
+	if !ok {
+		return nil, false
+	}
+	atomic.AddInt32(&this_.pendingRPC, -1)
+	call := v.(*rpcCall)
+	call.timer.Cancel()
+	return call, true
+}
+
+// PendingRPC 已经发出还没有收到返回的 rpc 请求数
+func (this_ *Session) PendingRPC() int {
+	return int(atomic.LoadInt32(&this_.pendingRPC))
+}
+
+// 在 queue 中调用，找不到回调的返回(已经超时)直接丢掉
+func (this_ *Session) response(index string, msg proto.Message) {
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return
+	}
+	if call, ok := this_.deleteRPC(uint32(i)); ok {
+		call.resp(msg)
+	}
+}
+
+func (this_ *Session) sendResponse(index string, msg proto.Message) error {
+	return this_.send(packetProtocolRPCResponse, index, msg)
+}
+
+// RequestContext 发送RPC请求并阻塞等待返回，会阻塞，别在queue或者逻辑线程里面用
+// ctx 没有设置超时时间时使用 session 的 rpcTimeout
+// 超时返回 ErrRPCTimeout，ctx 被取消返回 ctx.Err()，连接断开返回 ErrSessionClosed，对端返回 basepb.Base_Error 时返回 *tcp.RemoteError
+func (this_ *Session) RequestContext(ctx context.Context, msg proto.Message) (proto.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this_.rpcTimeout)
+		defer cancel()
+	}
+
+	c := make(chan proto.Message, 1)
+	index, err := this_.request(msg, func(m proto.Message) {
+		c <- m
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-c:
+		if e, ok := resp.(*basepb.Base_Error); ok {
+			return nil, &tcp.RemoteError{Code: e.ErrorCode, Message: e.ErrorMessage, Fields: e.Fields}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		this_.deleteRPC(index)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrRPCTimeout
+		}
+		return nil, ctx.Err()
+	case <-this_.closeChan:
+		this_.deleteRPC(index)
+		return nil, ErrSessionClosed
+	}
+}
+
+// 用于异步RPC同步等待，别在queue或者逻辑线程里面用，只适合http逻辑里面调用，会阻塞
+// 等待时间为 session 的 rpcTimeout
+func SyncRequest(s *Session, msg proto.Message, out proto.Message) error {
+	if s == nil {
+		return errors.New("session is nil")
+	}
+	return s.SyncRequestContext(context.Background(), msg, out)
+}
+
+// SyncRequestContext 同 RequestContext，返回的消息会合并到 out 中，返回的消息类型和 out 不一致时返回错误
+func (this_ *Session) SyncRequestContext(ctx context.Context, msg proto.Message, out proto.Message) error {
+	resp, err := this_.RequestContext(ctx, msg)
+	if err != nil {
+		return err
+	}
+	outName := proto.MessageName(out)
+	name := proto.MessageName(resp)
+	if name != outName {
+		return fmt.Errorf("recv unknown message:%s,expected:%s", name, outName)
+	}
+	proto.Reset(out)
+	proto.Merge(out, resp)
+	return nil
+}
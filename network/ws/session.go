@@ -1,26 +1,39 @@
 package ws
 
 import (
-	"encoding/json"
 	"errors"
-	"reflect"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/njmdk/common/eventqueue"
 	"github.com/njmdk/common/logger"
+	"github.com/njmdk/common/network/attr"
+	"github.com/njmdk/common/network/tcp"
 	"github.com/njmdk/common/utils"
 )
 
+var (
+	ErrSessionClosed = tcp.ErrSessionClosed
+	errInvalidMsg    = errors.New("invalid msg")
+)
+
+var _ attr.Holder = (*Session)(nil)
+
+// rpc 请求和返回的 Index 相同
 type packet struct {
-	Index string
-	Msg   proto.Message
+	Protocol packetProtocol
+	Index    string
+	Msg      proto.Message
 }
 
 type Session struct {
+	// 连接上附带的数据，Acceptor 的连接在关闭事件分发之后清空，其他的在 Close 时清空
+	attr.Attributes
 	id          string
 	cond        *sync.Cond
 	sendBuf     []*packet
@@ -31,6 +44,22 @@ type Session struct {
 	close       int32
 	contextType string
 	log         *logger.Logger
+	remoteAddr  string
+	// 读超时，收到任何消息或者 ping 都会重新计时，为0时不超时
+	readIdleTimeout time.Duration
+
+	queue      *eventqueue.EventQueue
+	rpcIndex   uint32
+	rpcFunc    sync.Map
+	pendingRPC int32
+	rpcTimeout time.Duration
+	closeChan  chan struct{}
+
+	lastPongTime int64
+	pingSentAt   int64
+	rtt          int64
+
+	Dispatch DispatchInterface
 }
 
 func NewClient(id string, ws *websocket.Conn, onClose func(c *Session, err error), onMessage func(c *Session, p *packet)) *Session {
@@ -41,34 +70,72 @@ func NewClient(id string, ws *websocket.Conn, onClose func(c *Session, err error
 		once:        &sync.Once{},
 		onClose:     onClose,
 		onMessage:   onMessage,
-		contextType: "application/json",
+		contextType: ContextTypeJSON,
+		remoteAddr:  ws.RemoteAddr().String(),
+		rpcTimeout:  defaultRPCTimeout,
+		closeChan:   make(chan struct{}),
 	}
 	return c
 }
 
+func (this_ *Session) ID() string {
+	return this_.id
+}
+
+func (this_ *Session) RemoteAddr() string {
+	return this_.remoteAddr
+}
+
+func (this_ *Session) GetLogger() *logger.Logger {
+	return this_.log
+}
+
+// Start 开始收发消息
+func (this_ *Session) Start() {
+	this_.doSend()
+	this_.doRead()
+}
+
 func (this_ *Session) Close(err error) {
 	this_.once.Do(func() {
 		if atomic.AddInt32(&this_.close, 1) == 1 {
 			_ = this_.ws.Close()
+			close(this_.closeChan)
+			this_.cond.L.Lock()
+			this_.cond.Broadcast()
+			this_.cond.L.Unlock()
 			if this_.onClose != nil {
 				this_.onClose(this_, err)
+			} else {
+				this_.ClearAttributes()
 			}
 		}
 	})
 }
 
-func (this_ *Session) Send() {
+func (this_ *Session) Send(msg proto.Message) error {
+	return this_.send(packetProtocolNormal, "", msg)
+}
 
+func (this_ *Session) SendNoError(msg proto.Message) {
+	err := this_.Send(msg)
+	if err != nil {
+		this_.log.Error("send msg failed", zap.Error(err), zap.String("id", this_.id), zap.Any("msg", msg))
+	}
 }
 
-func (this_ *Session) send(index string, msg proto.Message) {
+func (this_ *Session) send(pp packetProtocol, index string, msg proto.Message) error {
 	if this_.isClosed() {
-		return
+		return ErrSessionClosed
+	}
+	if proto.MessageName(msg) == "" {
+		return errors.New("unknown msg,because MessageName is '' ")
 	}
 	this_.cond.L.Lock()
-	this_.sendBuf = append(this_.sendBuf, &packet{Index: index, Msg: msg})
+	this_.sendBuf = append(this_.sendBuf, &packet{Protocol: pp, Index: index, Msg: msg})
 	this_.cond.L.Unlock()
 	this_.cond.Signal()
+	return nil
 }
 
 func (this_ *Session) isClosed() bool {
@@ -84,13 +151,14 @@ func (this_ *Session) doSend() {
 		defer func() {
 			this_.Close(err)
 		}()
-		for !this_.isClosed() {
+		for {
 			this_.cond.L.Lock()
-			for len(this_.sendBuf) == 0 {
+			for len(this_.sendBuf) == 0 && !this_.isClosed() {
 				this_.cond.Wait()
-				if this_.isClosed() {
-					return
-				}
+			}
+			if this_.isClosed() {
+				this_.cond.L.Unlock()
+				return
 			}
 
 			writeBuf := this_.sendBuf
@@ -108,53 +176,63 @@ func (this_ *Session) doSend() {
 }
 
 func (this_ *Session) writeOne(p *packet) error {
-	msgName := proto.MessageName(p.Msg)
-	if msgName == "" {
+	mt, data, err := this_.encode(p)
+	if err != nil {
+		// 单个消息编码失败只丢掉这个消息，不影响连接
+		this_.log.Error("encode msg failed", zap.Error(err), zap.String("id", this_.id), zap.String("index", p.Index),
+			zap.String("msg name", string(proto.MessageName(p.Msg))))
 		return nil
 	}
-	var data []byte
-	if this_.contextType == "application/json" {
-		data, _ = json.Marshal(p.Msg)
-	} else {
-		data, _ = proto.Marshal(p.Msg)
+	return this_.ws.WriteMessage(mt, data)
+}
+
+func (this_ *Session) extendReadDeadline() {
+	if this_.readIdleTimeout > 0 {
+		_ = this_.ws.SetReadDeadline(time.Now().Add(this_.readIdleTimeout))
 	}
-	return this_.ws.WriteJSON([]string{p.Index, msgName, utils.BytesToString(data)})
 }
 
 func (this_ *Session) doRead() {
 	utils.SafeGO(func(i interface{}) {
-		this_.log.Error("doSend panic", zap.Any("panic info", i))
+		this_.log.Error("doRead panic", zap.Any("panic info", i))
 		this_.Close(errors.New("panic"))
 	}, func() {
 		var err error
 		defer func() {
 			this_.Close(err)
 		}()
+		this_.ws.SetPongHandler(func(string) error {
+			this_.onPingPong(true)
+			return nil
+		})
+		this_.ws.SetPingHandler(func(appData string) error {
+			this_.onPingPong(false)
+			err := this_.ws.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+			if err == websocket.ErrCloseSent {
+				return nil
+			}
+			return err
+		})
 		for !this_.isClosed() {
-			var data []string
-			err = this_.ws.ReadJSON(&data)
+			this_.extendReadDeadline()
+			var mt int
+			var data []byte
+			mt, data, err = this_.ws.ReadMessage()
 			if err != nil {
 				return
 			}
-			if len(data) != 3 {
-				err = errors.New("invalid msg")
-				return
-			}
-			t := proto.MessageType(data[1])
-			if t == nil {
-				err = errors.New("invalid msg")
-				return
-			}
-			msg := reflect.New(t.Elem()).Interface().(proto.Message)
-			if this_.contextType == "application/json" {
-				err = json.Unmarshal(utils.StringToBytes(data[2]), msg)
-			} else {
-				err = proto.Unmarshal(utils.StringToBytes(data[2]), msg)
-			}
+			var p *packet
+			p, err = decode(mt, data)
 			if err != nil {
 				return
 			}
-			this_.onMessage(this_, &packet{Index: data[0], Msg: msg})
+			if err = this_.checkIndex(p); err != nil {
+				this_.log.Warn("drop rpc request", zap.Error(err), zap.String("id", this_.id), zap.String("index", p.Index),
+					zap.String("msg name", string(proto.MessageName(p.Msg))))
+				err = nil
+				continue
+			}
+			this_.onMessage(this_, p)
 		}
 	})
 }